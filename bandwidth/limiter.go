@@ -0,0 +1,248 @@
+// Package bandwidth limits the rate of the transfers of file content to and from pCloud.
+package bandwidth
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limiter is a token bucket that limits a transfer rate, in bytes per second. The rate
+// follows a schedule of time-of-day windows, and is the default rate outside of them.
+// A rate of 0 is unlimited.
+// It is safe for concurrent use.
+type Limiter struct {
+	lock     sync.Mutex // protects the fields below
+	settings Settings
+	tokens   float64 // may be negative: the bytes transferred ahead of the rate
+	last     time.Time
+
+	now   func() time.Time
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+// Settings are the settings of a Limiter.
+type Settings struct {
+	Rate     int64    // the default rate, in bytes per second
+	Schedule Schedule `json:",omitempty"`
+}
+
+// Status is the state of a Limiter.
+type Status struct {
+	Settings
+	Current int64 // the rate in effect
+}
+
+// NewLimiter returns a Limiter with the settings s.
+func NewLimiter(s Settings) *Limiter {
+	return &Limiter{
+		settings: s,
+		now:      time.Now,
+		sleep:    sleep,
+	}
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// Set replaces the settings of the limiter.
+func (l *Limiter) Set(s Settings) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.settings = s
+	l.last = time.Time{}
+}
+
+// Status returns the settings of the limiter, and the rate in effect.
+func (l *Limiter) Status() Status {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return Status{Settings: l.settings, Current: l.settings.rate(l.now())}
+}
+
+// Wait blocks until n bytes can be transferred within the rate in effect, or until ctx is
+// done. The bytes are counted against the rate, whether they are transferred already.
+func (l *Limiter) Wait(ctx context.Context, n int) error {
+	l.lock.Lock()
+	now := l.now()
+	rate := l.settings.rate(now)
+	if rate == 0 {
+		l.lock.Unlock()
+		return nil
+	}
+
+	// the bucket holds up to a second of transfer, and starts full.
+	if l.last.IsZero() {
+		l.tokens = float64(rate)
+	} else {
+		l.tokens = min(l.tokens+now.Sub(l.last).Seconds()*float64(rate), float64(rate))
+	}
+	l.last = now
+
+	l.tokens -= float64(n)
+	debt := l.tokens
+	l.lock.Unlock()
+
+	if debt >= 0 {
+		return nil
+	}
+
+	return l.sleep(ctx, time.Duration(-debt/float64(rate)*float64(time.Second)))
+}
+
+// rate returns the rate in effect at t.
+func (s Settings) rate(t time.Time) int64 {
+	if w, ok := s.Schedule.window(t); ok {
+		return w.Rate
+	}
+	return s.Rate
+}
+
+// Schedule is a list of time-of-day windows during which the rate differs from the default
+// rate. The first window that includes the time of day applies.
+type Schedule []Window
+
+// Window is a time of day, from From (included) to To (excluded), in minutes since midnight.
+// It spans midnight when To is before From.
+type Window struct {
+	From, To int
+	Rate     int64
+}
+
+// window returns the window of the schedule that includes the local time of day of t.
+func (s Schedule) window(t time.Time) (Window, bool) {
+	minute := t.Hour()*60 + t.Minute()
+
+	for _, w := range s {
+		if w.From <= w.To && minute >= w.From && minute < w.To ||
+			w.From > w.To && (minute >= w.From || minute < w.To) {
+			return w, true
+		}
+	}
+
+	return Window{}, false
+}
+
+// ParseSchedule parses a schedule of comma-separated windows, such as
+// "08:00-18:00=1M,22:00-06:00=0". The rate of each window is parsed with ParseRate.
+func ParseSchedule(str string) (Schedule, error) {
+	var s Schedule
+
+	for _, item := range strings.Split(str, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		span, rate, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid schedule window '%s': expected <from>-<to>=<rate>", item)
+		}
+		from, to, ok := strings.Cut(span, "-")
+		if !ok {
+			return nil, fmt.Errorf("invalid schedule window '%s': expected <from>-<to>=<rate>", item)
+		}
+
+		w := Window{}
+		var err error
+		if w.From, err = parseTimeOfDay(from); err != nil {
+			return nil, err
+		}
+		if w.To, err = parseTimeOfDay(to); err != nil {
+			return nil, err
+		}
+		if w.Rate, err = ParseRate(rate); err != nil {
+			return nil, err
+		}
+		s = append(s, w)
+	}
+
+	return s, nil
+}
+
+func parseTimeOfDay(s string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day '%s': expected HH:MM", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// String returns the schedule in the format of ParseSchedule.
+func (s Schedule) String() string {
+	items := make([]string, 0, len(s))
+	for _, w := range s {
+		items = append(items, fmt.Sprintf("%02d:%02d-%02d:%02d=%s", w.From/60, w.From%60, w.To/60, w.To%60, FormatRate(w.Rate)))
+	}
+	return strings.Join(items, ",")
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (s Schedule) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (s *Schedule) UnmarshalText(text []byte) error {
+	schedule, err := ParseSchedule(string(text))
+	if err != nil {
+		return err
+	}
+	*s = schedule
+	return nil
+}
+
+// rateUnits are the suffixes of the rates, by multiple of bytes per second.
+var rateUnits = []struct {
+	suffix string
+	size   int64
+}{
+	{"G", 1 << 30},
+	{"M", 1 << 20},
+	{"K", 1 << 10},
+}
+
+// ParseRate parses a rate in bytes per second, with an optional K, M or G suffix for KiB,
+// MiB or GiB per second, such as "512K". A rate of 0 is unlimited.
+func ParseRate(s string) (int64, error) {
+	number := strings.ToUpper(strings.TrimSpace(s))
+
+	size := int64(1)
+	for _, u := range rateUnits {
+		if n, ok := strings.CutSuffix(number, u.suffix); ok {
+			number, size = n, u.size
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid rate '%s': expected a number of bytes per second, optionally suffixed with K, M or G", s)
+	}
+
+	return n * size, nil
+}
+
+// FormatRate returns rate in the format of ParseRate, in the largest exact unit.
+func FormatRate(rate int64) string {
+	for _, u := range rateUnits {
+		if rate != 0 && rate%u.size == 0 {
+			return strconv.FormatInt(rate/u.size, 10) + u.suffix
+		}
+	}
+	return strconv.FormatInt(rate, 10)
+}
@@ -0,0 +1,150 @@
+package bandwidth
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClock is the clock of a Limiter, which sleeps by advancing it.
+type fakeClock struct {
+	now   time.Time
+	slept time.Duration
+}
+
+func (c *fakeClock) install(l *Limiter) {
+	l.now = func() time.Time { return c.now }
+	l.sleep = func(_ context.Context, d time.Duration) error {
+		c.slept += d
+		c.now = c.now.Add(d)
+		return nil
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	ctx := context.Background()
+	clock := &fakeClock{now: time.Date(2024, 3, 1, 12, 0, 0, 0, time.Local)}
+
+	l := NewLimiter(Settings{Rate: 1000})
+	clock.install(l)
+
+	// the first second of transfer is not delayed.
+	require.NoError(t, l.Wait(ctx, 1000))
+	require.Zero(t, clock.slept)
+
+	// then, the transfers are delayed down to the rate.
+	require.NoError(t, l.Wait(ctx, 500))
+	require.Equal(t, 500*time.Millisecond, clock.slept)
+	require.NoError(t, l.Wait(ctx, 2000))
+	require.Equal(t, 2500*time.Millisecond, clock.slept)
+
+	// the idle time refills the bucket, up to a second of transfer.
+	clock.now = clock.now.Add(time.Minute)
+	clock.slept = 0
+	require.NoError(t, l.Wait(ctx, 1000))
+	require.NoError(t, l.Wait(ctx, 100))
+	require.Equal(t, 100*time.Millisecond, clock.slept)
+
+	// the schedule overrides the default rate.
+	schedule, err := ParseSchedule("11:00-13:00=0")
+	require.NoError(t, err)
+	l.Set(Settings{Rate: 1000, Schedule: schedule})
+	clock.slept = 0
+	require.NoError(t, l.Wait(ctx, 1_000_000))
+	require.Zero(t, clock.slept)
+	require.Equal(t, Status{Settings: Settings{Rate: 1000, Schedule: schedule}}, l.Status())
+
+	clock.now = time.Date(2024, 3, 1, 14, 0, 0, 0, time.Local)
+	require.EqualValues(t, 1000, l.Status().Current)
+}
+
+func TestParseSchedule(t *testing.T) {
+	s, err := ParseSchedule("08:00-18:30=512K, 22:00-06:00=2M")
+	require.NoError(t, err)
+	require.Equal(t, Schedule{{From: 8 * 60, To: 18*60 + 30, Rate: 512 << 10}, {From: 22 * 60, To: 6 * 60, Rate: 2 << 20}}, s)
+	require.Equal(t, "08:00-18:30=512K,22:00-06:00=2M", s.String())
+
+	at := func(hour, minute int) time.Time { return time.Date(2024, 3, 1, hour, minute, 0, 0, time.Local) }
+
+	w, ok := s.window(at(18, 29))
+	require.True(t, ok)
+	require.EqualValues(t, 512<<10, w.Rate)
+	_, ok = s.window(at(18, 30))
+	require.False(t, ok)
+
+	// a window may span midnight.
+	w, ok = s.window(at(23, 0))
+	require.True(t, ok)
+	require.EqualValues(t, 2<<20, w.Rate)
+	_, ok = s.window(at(5, 59))
+	require.True(t, ok)
+	_, ok = s.window(at(6, 0))
+	require.False(t, ok)
+
+	for _, invalid := range []string{"08:00=1M", "08:00-18:00", "8h-18h=1M", "08:00-18:00=fast"} {
+		_, err = ParseSchedule(invalid)
+		require.Error(t, err, invalid)
+	}
+}
+
+func TestParseRate(t *testing.T) {
+	for s, want := range map[string]int64{"0": 0, "1500": 1500, "512k": 512 << 10, "2M": 2 << 20, "1G": 1 << 30} {
+		rate, err := ParseRate(s)
+		require.NoError(t, err, s)
+		require.Equal(t, want, rate, s)
+	}
+
+	_, err := ParseRate("-1M")
+	require.Error(t, err)
+
+	require.Equal(t, "2M", FormatRate(2<<20))
+	require.Equal(t, "1500", FormatRate(1500))
+	require.Equal(t, "0", FormatRate(0))
+}
+
+func TestTransport(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.URL.Path == "/api" {
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			_, _ = w.Write([]byte(`{"result": 0}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		_, _ = w.Write(body)
+	}))
+	defer srv.Close()
+
+	upClock := &fakeClock{now: time.Now()}
+	upload := NewLimiter(Settings{Rate: 100})
+	upClock.install(upload)
+	downClock := &fakeClock{now: time.Now()}
+	download := NewLimiter(Settings{Rate: 50})
+	downClock.install(download)
+
+	c := &http.Client{Transport: NewTransport(nil, upload, download)}
+
+	// the content is limited both ways.
+	resp, err := c.Post(srv.URL+"/content", "application/octet-stream", strings.NewReader(strings.Repeat("x", 300)))
+	require.NoError(t, err)
+	data, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Len(t, data, 300)
+	require.Equal(t, 2*time.Second, upClock.slept)
+	require.Equal(t, 5*time.Second, downClock.slept)
+
+	// the API results are not.
+	resp, err = c.Get(srv.URL + "/api")
+	require.NoError(t, err)
+	_, err = io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, 5*time.Second, downClock.slept)
+}
@@ -0,0 +1,67 @@
+package bandwidth
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Transport is an http.RoundTripper that limits the rate of the file content sent to and
+// received from pCloud: the bodies of the requests, and those of the responses that are not
+// API results, such as file_pread and the content servers.
+type Transport struct {
+	Base     http.RoundTripper
+	Upload   *Limiter
+	Download *Limiter
+}
+
+// NewTransport wraps base with the limits of upload and download.
+func NewTransport(base http.RoundTripper, upload, download *Limiter) *Transport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &Transport{Base: base, Upload: upload, Download: download}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+
+	if req.Body != nil && req.Body != http.NoBody {
+		req = req.Clone(ctx)
+		req.Body = &limitedBody{ctx: ctx, body: req.Body, limiter: t.Upload}
+	}
+
+	resp, err := t.Base.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		resp.Body = &limitedBody{ctx: ctx, body: resp.Body, limiter: t.Download}
+	}
+
+	return resp, nil
+}
+
+// limitedBody is a request or response body whose reads are limited by limiter.
+type limitedBody struct {
+	ctx     context.Context
+	body    io.ReadCloser
+	limiter *Limiter
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	if n > 0 {
+		if werr := b.limiter.Wait(b.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+func (b *limitedBody) Close() error {
+	return b.body.Close()
+}
@@ -0,0 +1,286 @@
+// Package cache keeps the content of pCloud files locally, so that they can be read again
+// without pCloud, such as when it is unreachable.
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tmpSuffix is the suffix of the files of the cache that are being written.
+const tmpSuffix = ".tmp"
+
+// Cache is a directory of the content of pCloud files, each identified by its file ID and
+// the hash of its content. The least recently used files are evicted once the cache
+// exceeds its maximum size, except those that are pinned.
+// It is safe for concurrent use.
+type Cache struct {
+	dir     string
+	maxSize int64
+
+	lock    sync.Mutex // protects entries, size and pins
+	entries map[uint64]*entry
+	size    int64
+	pins    pinState
+}
+
+// entry is the content of a file in the cache.
+type entry struct {
+	hash uint64
+	size int64
+	used time.Time
+}
+
+// Open opens the cache kept in the directory dir, which is created if it does not exist.
+// The cache holds at most maxSize bytes.
+func Open(dir string, maxSize int64) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	c := &Cache{
+		dir:     dir,
+		maxSize: maxSize,
+		entries: map[uint64]*entry{},
+	}
+
+	if err := c.loadPins(); err != nil {
+		return nil, err
+	}
+
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, de := range dirEntries {
+		name := de.Name()
+		if strings.HasSuffix(name, tmpSuffix) {
+			// left by a drive that stopped while writing it.
+			_ = os.Remove(filepath.Join(dir, name))
+			continue
+		}
+
+		fileID, hash, ok := parseName(name)
+		if !ok {
+			continue
+		}
+		info, err := de.Info()
+		if err != nil {
+			return nil, err
+		}
+
+		if old, ok := c.entries[fileID]; ok {
+			// only the latest content of a file is kept.
+			if old.used.After(info.ModTime()) {
+				_ = os.Remove(filepath.Join(dir, name))
+				continue
+			}
+			c.drop(fileID)
+		}
+		c.entries[fileID] = &entry{hash: hash, size: info.Size(), used: info.ModTime()}
+		c.size += info.Size()
+	}
+
+	c.lock.Lock()
+	c.evict(0)
+	c.lock.Unlock()
+
+	return c, nil
+}
+
+func fileName(fileID, hash uint64) string {
+	return fmt.Sprintf("%d-%d", fileID, hash)
+}
+
+func parseName(name string) (fileID, hash uint64, ok bool) {
+	id, h, ok := strings.Cut(name, "-")
+	if !ok {
+		return 0, 0, false
+	}
+
+	fileID, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	hash, err = strconv.ParseUint(h, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+
+	return fileID, hash, true
+}
+
+// Open opens the content of the file fileID whose hash is hash, if the cache holds it.
+func (c *Cache) Open(fileID, hash uint64) (*os.File, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	e, ok := c.entries[fileID]
+	if !ok || e.hash != hash {
+		return nil, false
+	}
+
+	name := filepath.Join(c.dir, fileName(fileID, hash))
+	f, err := os.Open(name)
+	if err != nil {
+		c.drop(fileID)
+		return nil, false
+	}
+
+	// the modification time of the files records their last use, across restarts.
+	e.used = time.Now()
+	_ = os.Chtimes(name, e.used, e.used)
+
+	return f, true
+}
+
+// Has reports whether the cache holds the content of the file fileID whose hash is hash.
+func (c *Cache) Has(fileID, hash uint64) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	e, ok := c.entries[fileID]
+	return ok && e.hash == hash
+}
+
+// Create returns a Writer of the content of the file fileID whose hash is hash. The content
+// is added to the cache once committed.
+func (c *Cache) Create(fileID, hash uint64) (*Writer, error) {
+	f, err := os.CreateTemp(c.dir, fileName(fileID, hash)+".*"+tmpSuffix)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Writer{cache: c, fileID: fileID, hash: hash, file: f}, nil
+}
+
+// Remove drops the content of the file fileID from the cache, such as when it changes.
+func (c *Cache) Remove(fileID uint64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.drop(fileID)
+}
+
+// Usage returns how many files the cache holds, and their total size.
+func (c *Cache) Usage() (int, int64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return len(c.entries), c.size
+}
+
+// add records the content of the file fileID, written at tmp. The caller must hold the lock.
+func (c *Cache) add(fileID, hash uint64, tmp string, size int64) error {
+	if err := os.Rename(tmp, filepath.Join(c.dir, fileName(fileID, hash))); err != nil {
+		return err
+	}
+
+	if e, ok := c.entries[fileID]; !ok || e.hash != hash {
+		c.drop(fileID)
+	} else {
+		c.size -= e.size
+	}
+	c.entries[fileID] = &entry{hash: hash, size: size, used: time.Now()}
+	c.size += size
+
+	c.evict(fileID)
+
+	return nil
+}
+
+// drop removes the content of the file fileID. The caller must hold the lock.
+func (c *Cache) drop(fileID uint64) {
+	e, ok := c.entries[fileID]
+	if !ok {
+		return
+	}
+
+	_ = os.Remove(filepath.Join(c.dir, fileName(fileID, e.hash)))
+	delete(c.entries, fileID)
+	c.size -= e.size
+}
+
+// evict removes the least recently used content that is not pinned until the cache no
+// longer exceeds its maximum size. The content of the file keep is evicted last. The caller
+// must hold the lock.
+func (c *Cache) evict(keep uint64) {
+	if c.size <= c.maxSize {
+		return
+	}
+
+	fileIDs := make([]uint64, 0, len(c.entries))
+	for fileID := range c.entries {
+		if fileID != keep && !c.pins.Files[fileID] {
+			fileIDs = append(fileIDs, fileID)
+		}
+	}
+	sort.Slice(fileIDs, func(i, j int) bool { return c.entries[fileIDs[i]].used.Before(c.entries[fileIDs[j]].used) })
+	if _, ok := c.entries[keep]; ok && !c.pins.Files[keep] {
+		fileIDs = append(fileIDs, keep)
+	}
+
+	for _, fileID := range fileIDs {
+		if c.size <= c.maxSize {
+			return
+		}
+		c.drop(fileID)
+	}
+}
+
+// Writer writes the content of a file to the cache, sequentially.
+type Writer struct {
+	cache  *Cache
+	fileID uint64
+	hash   uint64
+	file   *os.File
+	size   int64
+}
+
+// Size returns how much of the content is written.
+func (w *Writer) Size() int64 {
+	return w.size
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// Commit adds the content written to the cache.
+func (w *Writer) Commit() error {
+	if err := w.file.Close(); err != nil {
+		_ = os.Remove(w.file.Name())
+		return err
+	}
+
+	w.cache.lock.Lock()
+	defer w.cache.lock.Unlock()
+
+	if w.size > w.cache.maxSize && !w.cache.pins.Files[w.fileID] {
+		_ = os.Remove(w.file.Name())
+		return errors.New("content larger than the cache")
+	}
+
+	if err := w.cache.add(w.fileID, w.hash, w.file.Name(), w.size); err != nil {
+		_ = os.Remove(w.file.Name())
+		return err
+	}
+
+	return nil
+}
+
+// Abort discards the content written.
+func (w *Writer) Abort() {
+	_ = w.file.Close()
+	_ = os.Remove(w.file.Name())
+}
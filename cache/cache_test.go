@@ -0,0 +1,140 @@
+package cache_test
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/seborama/pcloud-drive/v1/cache"
+)
+
+func put(t *testing.T, c *cache.Cache, fileID, hash uint64, content string) {
+	t.Helper()
+
+	w, err := c.Create(fileID, hash)
+	require.NoError(t, err)
+	_, err = io.WriteString(w, content)
+	require.NoError(t, err)
+	require.NoError(t, w.Commit())
+}
+
+func read(t *testing.T, c *cache.Cache, fileID, hash uint64) (string, bool) {
+	t.Helper()
+
+	f, ok := c.Open(fileID, hash)
+	if !ok {
+		return "", false
+	}
+	defer func() { _ = f.Close() }()
+
+	data, err := io.ReadAll(f)
+	require.NoError(t, err)
+	return string(data), true
+}
+
+func TestCache(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "content")
+
+	c, err := cache.Open(dir, 10)
+	require.NoError(t, err)
+
+	put(t, c, 1, 100, "aaaa")
+	put(t, c, 2, 200, "bbbb")
+
+	content, ok := read(t, c, 1, 100)
+	require.True(t, ok)
+	require.Equal(t, "aaaa", content)
+
+	// a different hash is a different content.
+	_, ok = read(t, c, 1, 101)
+	require.False(t, ok)
+
+	// the least recently used content is evicted: file 2.
+	time.Sleep(10 * time.Millisecond)
+	_, _ = read(t, c, 1, 100)
+	put(t, c, 3, 300, "cccc")
+	require.True(t, c.Has(1, 100))
+	require.False(t, c.Has(2, 200))
+	require.True(t, c.Has(3, 300))
+
+	files, size := c.Usage()
+	require.Equal(t, 2, files)
+	require.EqualValues(t, 8, size)
+
+	// the new content of a file replaces the previous one.
+	put(t, c, 1, 102, "dd")
+	require.False(t, c.Has(1, 100))
+	files, size = c.Usage()
+	require.Equal(t, 2, files)
+	require.EqualValues(t, 6, size)
+
+	// an aborted content is discarded.
+	w, err := c.Create(4, 400)
+	require.NoError(t, err)
+	_, _ = io.WriteString(w, "eee")
+	w.Abort()
+	require.False(t, c.Has(4, 400))
+
+	// the content persists across sessions.
+	c, err = cache.Open(dir, 10)
+	require.NoError(t, err)
+	content, ok = read(t, c, 1, 102)
+	require.True(t, ok)
+	require.Equal(t, "dd", content)
+	require.True(t, c.Has(3, 300))
+
+	c.Remove(3)
+	require.False(t, c.Has(3, 300))
+
+	names, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, names, 1)
+}
+
+func TestCache_Pins(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "content")
+
+	c, err := cache.Open(dir, 10)
+	require.NoError(t, err)
+
+	require.NoError(t, c.AddPin(cache.Pin{Path: "/docs", Folder: true, ID: 5}))
+	require.NoError(t, c.AddPin(cache.Pin{Path: "/a.txt", ID: 1}))
+	require.Equal(t, []cache.Pin{{Path: "/a.txt", ID: 1}, {Path: "/docs", Folder: true, ID: 5}}, c.Pins())
+	require.True(t, c.PinnedFolder(5))
+	require.NoError(t, c.SetPinned([]uint64{1, 2}, []uint64{5}))
+
+	// the pinned content is exempt from eviction, and from the maximum size.
+	put(t, c, 1, 100, "aaaaaaaa")
+	put(t, c, 2, 200, "bbbbbbbbbbbb")
+	put(t, c, 3, 300, "cc")
+	require.True(t, c.Has(1, 100))
+	require.True(t, c.Has(2, 200))
+	require.False(t, c.Has(3, 300))
+
+	files, size := c.PinnedUsage()
+	require.Equal(t, 2, files)
+	require.EqualValues(t, 20, size)
+
+	// the pins persist across sessions.
+	c, err = cache.Open(dir, 10)
+	require.NoError(t, err)
+	require.Len(t, c.Pins(), 2)
+	require.True(t, c.Pinned(2))
+	require.True(t, c.Has(2, 200))
+
+	// the content that is no longer pinned is evicted.
+	removed, err := c.RemovePin(true, 5)
+	require.NoError(t, err)
+	require.True(t, removed)
+	require.NoError(t, c.SetPinned([]uint64{1}, nil))
+	require.False(t, c.Has(2, 200))
+	require.True(t, c.Has(1, 100))
+
+	removed, err = c.RemovePin(true, 5)
+	require.NoError(t, err)
+	require.False(t, removed)
+}
@@ -0,0 +1,196 @@
+package cache
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// pinsFile is the name of the file of the cache that records the pins.
+const pinsFile = "pins.json"
+
+// Pin is a file, or a folder along with its subtree, whose content is kept in the cache.
+type Pin struct {
+	Path   string
+	Folder bool   `json:",omitempty"`
+	ID     uint64 // the file ID, or the folder ID of a folder
+}
+
+// pinState is the content of the pins file.
+type pinState struct {
+	Pins []Pin
+
+	// Files and Folders are those that the pins cover, as last found in pCloud. The content
+	// of Files is exempt from eviction.
+	Files   map[uint64]bool
+	Folders map[uint64]bool
+}
+
+// Pins returns the pins, sorted by path.
+func (c *Cache) Pins() []Pin {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return slices.Clone(c.pins.Pins)
+}
+
+// AddPin records p, which covers its own file or folder until SetPinned says otherwise.
+func (c *Cache) AddPin(p Pin) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.pins.Pins = slices.DeleteFunc(c.pins.Pins, func(old Pin) bool { return old.Folder == p.Folder && old.ID == p.ID })
+	c.pins.Pins = append(c.pins.Pins, p)
+	slices.SortFunc(c.pins.Pins, func(a, b Pin) int { return cmp.Compare(a.Path, b.Path) })
+
+	if p.Folder {
+		c.pins.Folders[p.ID] = true
+	} else {
+		c.pins.Files[p.ID] = true
+	}
+
+	return c.savePins()
+}
+
+// RemovePin removes the pin of the file, or of the folder if folder is set, whose ID is id.
+// It reports whether it existed. The files and folders it covered remain pinned until
+// SetPinned says otherwise.
+func (c *Cache) RemovePin(folder bool, id uint64) (bool, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	n := len(c.pins.Pins)
+	c.pins.Pins = slices.DeleteFunc(c.pins.Pins, func(p Pin) bool { return p.Folder == folder && p.ID == id })
+	if len(c.pins.Pins) == n {
+		return false, nil
+	}
+
+	return true, c.savePins()
+}
+
+// SetPinned records the files and folders that the pins cover. The content of the files
+// that are no longer pinned becomes subject to eviction.
+func (c *Cache) SetPinned(fileIDs, folderIDs []uint64) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.pins.Files = make(map[uint64]bool, len(fileIDs))
+	for _, fileID := range fileIDs {
+		c.pins.Files[fileID] = true
+	}
+	c.pins.Folders = make(map[uint64]bool, len(folderIDs))
+	for _, folderID := range folderIDs {
+		c.pins.Folders[folderID] = true
+	}
+
+	if err := c.savePins(); err != nil {
+		return err
+	}
+	c.evict(0)
+
+	return nil
+}
+
+// Pinned reports whether a pin covers the file fileID.
+func (c *Cache) Pinned(fileID uint64) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.pins.Files[fileID]
+}
+
+// PinnedFolder reports whether a pin covers the folder folderID.
+func (c *Cache) PinnedFolder(folderID uint64) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.pins.Folders[folderID]
+}
+
+// PinnedUsage returns how many of the files the cache holds are pinned, and their total
+// size.
+func (c *Cache) PinnedUsage() (int, int64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	var files int
+	var size int64
+	for fileID, e := range c.entries {
+		if c.pins.Files[fileID] {
+			files++
+			size += e.size
+		}
+	}
+
+	return files, size
+}
+
+// loadPins reads the pins file, if it exists.
+func (c *Cache) loadPins() error {
+	c.pins = pinState{Files: map[uint64]bool{}, Folders: map[uint64]bool{}}
+
+	data, err := os.ReadFile(filepath.Join(c.dir, pinsFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err = json.Unmarshal(data, &c.pins); err != nil {
+		return fmt.Errorf("pins file '%s' is corrupt: %w", filepath.Join(c.dir, pinsFile), err)
+	}
+	if c.pins.Files == nil {
+		c.pins.Files = map[uint64]bool{}
+	}
+	if c.pins.Folders == nil {
+		c.pins.Folders = map[uint64]bool{}
+	}
+
+	return nil
+}
+
+// savePins writes the pins file, atomically. The caller must hold the lock.
+func (c *Cache) savePins() error {
+	data, err := json.Marshal(c.pins)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(c.dir, pinsFile+".*"+tmpSuffix)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	if _, err = tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	// the pins must be on disk before they replace the previous ones: they would be lost
+	// after a crash otherwise.
+	if err = tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	if err = os.Rename(tmp.Name(), filepath.Join(c.dir, pinsFile)); err != nil {
+		return err
+	}
+
+	// the rename is durable once the directory is.
+	dir, err := os.Open(c.dir)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = dir.Close() }()
+
+	return dir.Sync()
+}
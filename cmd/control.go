@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+
+	ucli "github.com/urfave/cli/v2"
+
+	"github.com/seborama/pcloud-drive/v1/bandwidth"
+	"github.com/seborama/pcloud-drive/v1/control"
+)
+
+func status(c *ucli.Context) error {
+	s, err := control.NewClient(c.String("control-socket")).Status(c.Context)
+	if err != nil {
+		return err
+	}
+
+	return printJSON(s)
+}
+
+func stats(c *ucli.Context) error {
+	s, err := control.NewClient(c.String("control-socket")).Stats(c.Context)
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(s))
+	for name := range s {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Printf("%s %g\n", name, s[name])
+	}
+
+	return nil
+}
+
+func cacheFlush(c *ucli.Context) error {
+	return control.NewClient(c.String("control-socket")).FlushCache(c.Context)
+}
+
+func refresh(c *ucli.Context) error {
+	if c.NArg() != 1 {
+		return errors.New("refresh expects exactly one path")
+	}
+
+	return control.NewClient(c.String("control-socket")).Refresh(c.Context, c.Args().First())
+}
+
+func revert(c *ucli.Context) error {
+	if c.NArg() != 2 {
+		return errors.New("revert expects a path and a revision ID")
+	}
+
+	revisionID, err := strconv.ParseUint(c.Args().Get(1), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid revision ID '%s': %w", c.Args().Get(1), err)
+	}
+
+	return control.NewClient(c.String("control-socket")).RevertRevision(c.Context, c.Args().First(), revisionID)
+}
+
+func setBandwidth(c *ucli.Context) error {
+	s, err := control.NewClient(c.String("control-socket")).Status(c.Context)
+	if err != nil {
+		return err
+	}
+	if s.Bandwidth == nil {
+		return errors.New("the drive has no bandwidth limits")
+	}
+
+	upload, err := changedLimit(c, "upload", s.Bandwidth.Upload.Settings)
+	if err != nil {
+		return err
+	}
+	download, err := changedLimit(c, "download", s.Bandwidth.Download.Settings)
+	if err != nil {
+		return err
+	}
+	if upload == nil && download == nil {
+		return errors.New("bandwidth expects at least one of --upload, --download, --upload-schedule and --download-schedule")
+	}
+
+	return control.NewClient(c.String("control-socket")).SetBandwidth(c.Context, upload, download)
+}
+
+// changedLimit returns the settings of the limit of direction, "upload" or "download", with
+// the changes that the flags ask for applied to current, or nil when there are none.
+func changedLimit(c *ucli.Context, direction string, current bandwidth.Settings) (*bandwidth.Settings, error) {
+	if !c.IsSet(direction) && !c.IsSet(direction+"-schedule") {
+		return nil, nil
+	}
+
+	var err error
+	if c.IsSet(direction) {
+		if current.Rate, err = bandwidth.ParseRate(c.String(direction)); err != nil {
+			return nil, err
+		}
+	}
+	if c.IsSet(direction + "-schedule") {
+		if current.Schedule, err = bandwidth.ParseSchedule(c.String(direction + "-schedule")); err != nil {
+			return nil, err
+		}
+	}
+
+	return &current, nil
+}
+
+func pin(c *ucli.Context) error {
+	if c.NArg() != 1 {
+		return errors.New("pin expects exactly one path")
+	}
+
+	return control.NewClient(c.String("control-socket")).Pin(c.Context, c.Args().First())
+}
+
+func unpin(c *ucli.Context) error {
+	if c.NArg() != 1 {
+		return errors.New("unpin expects exactly one path")
+	}
+
+	return control.NewClient(c.String("control-socket")).Unpin(c.Context, c.Args().First())
+}
+
+func unmount(c *ucli.Context) error {
+	return control.NewClient(c.String("control-socket")).Unmount(c.Context)
+}
+
+func printJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
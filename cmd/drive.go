@@ -2,13 +2,25 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
+	"path"
 	"time"
 
 	ucli "github.com/urfave/cli/v2"
 
+	"github.com/seborama/pcloud-drive/v1/bandwidth"
+	"github.com/seborama/pcloud-drive/v1/cache"
+	"github.com/seborama/pcloud-drive/v1/control"
 	"github.com/seborama/pcloud-drive/v1/fuse"
+	"github.com/seborama/pcloud-drive/v1/metastore"
+	"github.com/seborama/pcloud-drive/v1/metrics"
+	"github.com/seborama/pcloud-drive/v1/pcloud"
+	"github.com/seborama/pcloud-drive/v1/scheduler"
+	"github.com/seborama/pcloud-drive/v1/tracing"
+	"github.com/seborama/pcloud-drive/v1/uploads"
 	"github.com/seborama/pcloud-sdk/sdk"
 )
 
@@ -16,20 +28,60 @@ func drive(c *ucli.Context) error {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	if c.String("pcloud-username") == "" || c.String("pcloud-password") == "" {
+		return errors.New("the pCloud credentials are required: see --pcloud-username and --pcloud-password")
+	}
+
+	if addr := c.String("metrics-listen"); addr != "" {
+		if err := metrics.Serve(ctx, addr); err != nil {
+			return err
+		}
+	}
+
+	shutdownTracing, err := tracing.Setup(ctx, tracing.Config{
+		Endpoint:    c.String("otlp-endpoint"),
+		Insecure:    c.Bool("otlp-insecure"),
+		SampleRatio: c.Float64("trace-sample-ratio"),
+	})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = shutdownTracing(shutdownCtx)
+	}()
+
+	uploadLimit, err := limiter(c, "upload-limit")
+	if err != nil {
+		return err
+	}
+	downloadLimit, err := limiter(c, "download-limit")
+	if err != nil {
+		return err
+	}
+
+	var transport http.RoundTripper = &http.Transport{
+		MaxIdleConnsPerHost:   2,
+		MaxConnsPerHost:       10,
+		ResponseHeaderTimeout: 20 * time.Second,
+		Proxy:                 http.ProxyFromEnvironment,
+	}
+	transport = bandwidth.NewTransport(transport, uploadLimit, downloadLimit)
+	apiScheduler := scheduler.New(c.Int("api-concurrency"))
+	transport = scheduler.NewTransport(transport, apiScheduler)
+	transport = metrics.NewTransport(transport)
+	transport = tracing.NewTransport(transport)
+
 	sdkHTTPClient := &http.Client{
-		Transport: &http.Transport{
-			MaxIdleConnsPerHost:   2,
-			MaxConnsPerHost:       10,
-			ResponseHeaderTimeout: 20 * time.Second,
-			Proxy:                 http.ProxyFromEnvironment,
-		},
-		Timeout: 0,
+		Transport: transport,
+		Timeout:   0,
 	}
 
-	pCloudClient := sdk.NewClient(sdkHTTPClient)
+	pCloudClient := pcloud.NewClient(sdkHTTPClient)
 
 	slog.Info("logging into pCloud")
-	err := pCloudClient.Login(
+	err = pCloudClient.Login(
 		ctx,
 		c.String("pcloud-otp-code"),
 		sdk.WithGlobalOptionUsername(c.String("pcloud-username")),
@@ -39,16 +91,109 @@ func drive(c *ucli.Context) error {
 		return err
 	}
 
+	rootFolderID, err := remoteRoot(ctx, c, pCloudClient)
+	if err != nil {
+		return err
+	}
+
+	metadataPath := c.String("metadata-store")
+	if metadataPath == "" {
+		if metadataPath, err = defaultMetadataStore(c.String("pcloud-username")); err != nil {
+			return err
+		}
+	}
+	metadata, err := metastore.Open(metadataPath)
+	if err != nil {
+		return err
+	}
+
+	attrMode, err := fuse.ParseAttrMode(c.String("attr-mode"))
+	if err != nil {
+		return err
+	}
+
+	nameForm, err := fuse.ParseNameForm(c.String("name-form"))
+	if err != nil {
+		return err
+	}
+
+	readMode, err := fuse.ParseReadMode(c.String("read-mode"))
+	if err != nil {
+		return err
+	}
+
+	driveOpts := []fuse.Option{
+		fuse.WithRootFolderID(rootFolderID),
+		fuse.WithReadMode(readMode),
+		fuse.WithDownloadParallelism(c.Int("download-parallelism")),
+		fuse.WithMetadataStore(metadata),
+		fuse.WithAttrMode(attrMode),
+		fuse.WithNameForm(nameForm),
+		fuse.WithBandwidthLimits(uploadLimit, downloadLimit),
+		fuse.WithScheduler(apiScheduler),
+	}
+	if c.Bool("case-insensitive") {
+		driveOpts = append(driveOpts, fuse.WithCaseInsensitiveLookup())
+	}
+	if len(c.StringSlice("exclude")) > 0 {
+		filter, err := fuse.NewFilter(c.StringSlice("include"), c.StringSlice("exclude"))
+		if err != nil {
+			return err
+		}
+		driveOpts = append(driveOpts, fuse.WithFilter(filter, c.Bool("exclude-create")))
+	}
+	if c.Bool("chunked-uploads") {
+		if !c.Bool("read-write") {
+			return errors.New("--chunked-uploads requires --read-write")
+		}
+		uploadDir := c.String("upload-dir")
+		if uploadDir == "" {
+			if uploadDir, err = defaultUploadDir(c.String("pcloud-username")); err != nil {
+				return err
+			}
+		}
+		journal, err := uploads.Open(uploadDir)
+		if err != nil {
+			return err
+		}
+		driveOpts = append(driveOpts, fuse.WithChunkedUploads(journal, int64(c.Int("upload-chunk-size"))<<20, c.Int("upload-workers")))
+	}
+	if c.Int("cache-size") > 0 {
+		cacheDir := c.String("cache-dir")
+		if cacheDir == "" {
+			if cacheDir, err = defaultCacheDir(c.String("pcloud-username")); err != nil {
+				return err
+			}
+		}
+		contentCache, err := cache.Open(cacheDir, int64(c.Int("cache-size"))<<20)
+		if err != nil {
+			return err
+		}
+		driveOpts = append(driveOpts, fuse.WithContentCache(contentCache))
+	}
+	if c.Bool("trash") {
+		driveOpts = append(driveOpts, fuse.WithTrash())
+	}
+	if c.Bool("revisions") {
+		driveOpts = append(driveOpts, fuse.WithRevisions())
+	}
+
 	slog.Info("creating drive")
 	drive, err := fuse.NewDrive(
 		c.String("mount-point"),
 		c.Bool("read-write"),
 		pCloudClient,
+		driveOpts...,
 	)
 	if err != nil {
 		panic(err)
 	}
-	defer func() { _ = drive.Unmount() }()
+	defer func() { _ = drive.Close() }()
+
+	if err = control.Serve(ctx, c.String("control-socket"), drive); err != nil {
+		_ = drive.Unmount()
+		return err
+	}
 
 	slog.Info("mouting FS", "location", c.String("mount-point"), "read-write", c.Bool("read-write"))
 	err = drive.Mount()
@@ -58,3 +203,52 @@ func drive(c *ucli.Context) error {
 
 	return nil
 }
+
+// remoteRoot returns the ID of the pCloud folder to mount at the root of the drive, as set by
+// --remote-path or --remote-folder-id.
+func remoteRoot(ctx context.Context, c *ucli.Context, pCloudClient *pcloud.Client) (uint64, error) {
+	remotePath := c.String("remote-path")
+	if c.IsSet("remote-folder-id") {
+		if remotePath != "" {
+			return 0, errors.New("--remote-path and --remote-folder-id are mutually exclusive")
+		}
+
+		folderID := c.Uint64("remote-folder-id")
+		if _, err := pCloudClient.StatFolder(ctx, sdk.T1FolderByID(folderID)); err != nil {
+			if pcloud.IsNotFound(err) {
+				return 0, fmt.Errorf("remote folder ID %d does not exist", folderID)
+			}
+			return 0, err
+		}
+		return folderID, nil
+	}
+
+	if remotePath == "" || remotePath == "/" {
+		return 0, nil
+	}
+
+	md, err := pCloudClient.StatFolder(ctx, sdk.T1FolderByPath(path.Clean("/"+remotePath)))
+	if err != nil {
+		if pcloud.IsNotFound(err) {
+			return 0, fmt.Errorf("remote path '%s' does not exist or is not a folder", remotePath)
+		}
+		return 0, err
+	}
+	slog.Info("mounting remote folder", "path", remotePath, "folderID", md.FolderID)
+
+	return md.FolderID, nil
+}
+
+// limiter returns the bandwidth limiter set by the rate flag name and its schedule flag.
+func limiter(c *ucli.Context, name string) (*bandwidth.Limiter, error) {
+	rate, err := bandwidth.ParseRate(c.String(name))
+	if err != nil {
+		return nil, fmt.Errorf("--%s: %w", name, err)
+	}
+	schedule, err := bandwidth.ParseSchedule(c.String(name + "-schedule"))
+	if err != nil {
+		return nil, fmt.Errorf("--%s-schedule: %w", name, err)
+	}
+
+	return bandwidth.NewLimiter(bandwidth.Settings{Rate: rate, Schedule: schedule}), nil
+}
@@ -1,35 +1,89 @@
 package main
 
 import (
+	"fmt"
 	"log"
 	"os"
+	"path/filepath"
+	"time"
 
+	"github.com/seborama/pcloud-drive/v1/fuse"
 	"github.com/seborama/pcloud-drive/v1/logger"
 	"github.com/urfave/cli/v2"
 )
 
 func main() {
-	logger.LoggerSetup()
-
 	app := &cli.App{
+		Before: func(c *cli.Context) error {
+			return logger.LoggerSetup(logger.Config{
+				Level:      c.String("log-level"),
+				Format:     c.String("log-format"),
+				File:       c.String("log-file"),
+				MaxSize:    c.Int64("log-max-size") * 1_048_576,
+				MaxAge:     c.Duration("log-max-age"),
+				MaxBackups: c.Int("log-max-backups"),
+			})
+		},
+		After: func(c *cli.Context) error {
+			return logger.Close()
+		},
+
 		Flags: []cli.Flag{
 			&cli.StringFlag{
 				Name:     "pcloud-username",
 				EnvVars:  []string{"PCLOUD_USERNAME"},
-				Usage:    "pCloud account username",
-				Required: true,
+				Usage:    "pCloud account username (required by the drive command)",
+				Required: false,
 			},
 			&cli.StringFlag{
 				Name:     "pcloud-password",
 				EnvVars:  []string{"PCLOUD_PASSWORD"},
-				Usage:    "pCloud account password",
-				Required: true,
+				Usage:    "pCloud account password (required by the drive command)",
+				Required: false,
 			},
 			&cli.StringFlag{
 				Name:    "pcloud-otp-code",
 				EnvVars: []string{"PCLOUD_OTP_CODE"},
 				Usage:   "pCloud account login One-Time-Password (for two-factor authentication)",
 			},
+			&cli.StringFlag{
+				Name:    "control-socket",
+				EnvVars: []string{"PCLOUD_DRIVE_CONTROL_SOCKET"},
+				Usage:   "Path of the Unix socket used to control a running drive",
+				Value:   defaultControlSocket(),
+			},
+			&cli.StringFlag{
+				Name:    "log-level",
+				EnvVars: []string{"PCLOUD_DRIVE_LOG_LEVEL"},
+				Usage:   "Minimum level of log messages: debug, info, warn or error",
+				Value:   "info",
+			},
+			&cli.StringFlag{
+				Name:    "log-format",
+				EnvVars: []string{"PCLOUD_DRIVE_LOG_FORMAT"},
+				Usage:   "Format of log messages: text or json",
+				Value:   logger.FormatText,
+			},
+			&cli.StringFlag{
+				Name:    "log-file",
+				EnvVars: []string{"PCLOUD_DRIVE_LOG_FILE"},
+				Usage:   "Path of the log file (default is stdout)",
+			},
+			&cli.Int64Flag{
+				Name:  "log-max-size",
+				Usage: "Size in MiB beyond which the log file is rotated (0 disables it)",
+				Value: 100,
+			},
+			&cli.DurationFlag{
+				Name:  "log-max-age",
+				Usage: "Age beyond which the log file is rotated (0 disables it)",
+				Value: 24 * time.Hour,
+			},
+			&cli.IntFlag{
+				Name:  "log-max-backups",
+				Usage: "Number of rotated log files to keep (0 keeps them all)",
+				Value: 7,
+			},
 		},
 
 		Commands: []*cli.Command{
@@ -49,8 +103,205 @@ func main() {
 						Usage:    "Mount drive in read-write mode (default is read-only)",
 						Required: false,
 					},
+					&cli.StringFlag{
+						Name:  "remote-path",
+						Usage: "Path of the pCloud folder to mount at the root of the drive (default is the root of the account)",
+					},
+					&cli.Uint64Flag{
+						Name:  "remote-folder-id",
+						Usage: "ID of the pCloud folder to mount at the root of the drive, as an alternative to --remote-path",
+					},
+					&cli.BoolFlag{
+						Name:  "trash",
+						Usage: "Expose pCloud's trash as a virtual .trash folder at the root of the drive",
+					},
+					&cli.BoolFlag{
+						Name:  "revisions",
+						Usage: "Expose the past revisions of files in a virtual .revisions folder at the root of the drive",
+					},
+					&cli.StringFlag{
+						Name:  "read-mode",
+						Usage: "How files opened for reading only are read: fd (through a pCloud file descriptor) or http (with range requests to pCloud's content servers)",
+						Value: string(fuse.ReadModeFD),
+					},
+					&cli.IntFlag{
+						Name:  "download-parallelism",
+						Usage: "How many chunks of a file are fetched concurrently when it is read sequentially with --read-mode http (1 disables the parallel fetches)",
+						Value: 4,
+					},
+					&cli.StringFlag{
+						Name:  "attr-mode",
+						Usage: "What becomes of chmod and chown changes: ignore, memory (until the entry is fetched anew from pCloud) or persist (in the metadata store)",
+						Value: string(fuse.AttrModeMemory),
+					},
+					&cli.StringFlag{
+						Name:  "name-form",
+						Usage: "Unicode normalisation form of the names of files and folders: none (as stored by pCloud), nfc or nfd",
+						Value: string(fuse.NameFormNone),
+					},
+					&cli.BoolFlag{
+						Name:  "case-insensitive",
+						Usage: "Look up names that do not match exactly without regard to case",
+					},
+					&cli.StringSliceFlag{
+						Name:  "exclude",
+						Usage: "Glob pattern of the files and folders to hide (repeatable), matched against their name or, for patterns with a '/', their path",
+					},
+					&cli.StringSliceFlag{
+						Name:  "include",
+						Usage: "Glob pattern of the files and folders to show even though they match an --exclude pattern (repeatable)",
+					},
+					&cli.BoolFlag{
+						Name:  "exclude-create",
+						Usage: "Refuse to create the files that match an --exclude pattern, so that they never reach pCloud",
+					},
+					&cli.StringFlag{
+						Name:  "metadata-store",
+						Usage: "Path of the local file that holds the user extended attributes of files and folders (default is in the user's config directory, per pCloud account)",
+					},
+					&cli.BoolFlag{
+						Name:  "chunked-uploads",
+						Usage: "Upload the files that are created or truncated in chunks, staged locally, so that interrupted uploads resume (requires --read-write)",
+					},
+					&cli.IntFlag{
+						Name:  "upload-chunk-size",
+						Usage: "Size in MiB of the chunks of the chunked uploads",
+						Value: 8,
+					},
+					&cli.IntFlag{
+						Name:  "upload-workers",
+						Usage: "How many closed files are uploaded at once by the background workers of the chunked uploads",
+						Value: 2,
+					},
+					&cli.StringFlag{
+						Name:  "upload-dir",
+						Usage: "Path of the local directory that holds the journal and the staged content of the chunked uploads (default is in the user's cache directory, per pCloud account)",
+					},
+					&cli.StringFlag{
+						Name:  "cache-dir",
+						Usage: "Path of the local directory that holds the content cache, from where the files read are read again, including offline (default is in the user's cache directory, per pCloud account)",
+					},
+					&cli.IntFlag{
+						Name:  "cache-size",
+						Usage: "Maximum size in MiB of the content cache, which pinning and offline reads require (0, the default, disables it)",
+					},
+					&cli.IntFlag{
+						Name:  "api-concurrency",
+						Usage: "Maximum number of concurrent requests to pCloud: the look-ups, attributes and directory listings go ahead of the other requests, and the background transfers go last",
+						Value: 8,
+					},
+					&cli.StringFlag{
+						Name:  "upload-limit",
+						Usage: "Maximum rate of the file content uploaded to pCloud, in bytes per second with an optional K, M or G suffix (0 is unlimited)",
+						Value: "0",
+					},
+					&cli.StringFlag{
+						Name:  "download-limit",
+						Usage: "Maximum rate of the file content downloaded from pCloud, in bytes per second with an optional K, M or G suffix (0 is unlimited)",
+						Value: "0",
+					},
+					&cli.StringFlag{
+						Name:  "upload-limit-schedule",
+						Usage: "Time-of-day windows during which the upload rate differs from --upload-limit, such as '08:00-18:00=512K,22:00-06:00=0'",
+					},
+					&cli.StringFlag{
+						Name:  "download-limit-schedule",
+						Usage: "Time-of-day windows during which the download rate differs from --download-limit, such as '08:00-18:00=2M'",
+					},
+					&cli.StringFlag{
+						Name:  "metrics-listen",
+						Usage: "Address (host:port) to expose Prometheus metrics on, at /metrics (disabled by default)",
+					},
+					&cli.StringFlag{
+						Name:    "otlp-endpoint",
+						EnvVars: []string{"PCLOUD_DRIVE_OTLP_ENDPOINT"},
+						Usage:   "Address (host:port) of the OTLP/HTTP collector to export traces to (tracing is disabled by default)",
+					},
+					&cli.BoolFlag{
+						Name:  "otlp-insecure",
+						Usage: "Connect to the OTLP collector without TLS",
+						Value: true,
+					},
+					&cli.Float64Flag{
+						Name:  "trace-sample-ratio",
+						Usage: "Fraction of the FUSE operations to trace, between 0 and 1",
+						Value: 1,
+					},
 				},
 			},
+			{
+				Name:   "status",
+				Usage:  "Show the status of the running drive",
+				Action: status,
+			},
+			{
+				Name:   "stats",
+				Usage:  "Show the metrics of the running drive",
+				Action: stats,
+			},
+			{
+				Name:  "cache",
+				Usage: "Manage the cache of the running drive",
+				Subcommands: []*cli.Command{
+					{
+						Name:   "flush",
+						Usage:  "Drop the directory cache",
+						Action: cacheFlush,
+					},
+				},
+			},
+			{
+				Name:      "refresh",
+				Usage:     "Fetch anew the details of a file or folder from pCloud",
+				ArgsUsage: "<path relative to the drive root>",
+				Action:    refresh,
+			},
+			{
+				Name:      "revert",
+				Usage:     "Make a past revision the current version of a file",
+				ArgsUsage: "<path relative to the drive root> <revision ID>",
+				Action:    revert,
+			},
+			{
+				Name:  "bandwidth",
+				Usage: "Change the bandwidth limits of the running drive (see the Bandwidth field of status for the current ones)",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "upload",
+						Usage: "Maximum upload rate, in bytes per second with an optional K, M or G suffix (0 is unlimited)",
+					},
+					&cli.StringFlag{
+						Name:  "download",
+						Usage: "Maximum download rate, in bytes per second with an optional K, M or G suffix (0 is unlimited)",
+					},
+					&cli.StringFlag{
+						Name:  "upload-schedule",
+						Usage: "Time-of-day windows during which the upload rate differs, such as '08:00-18:00=512K' (empty to remove them)",
+					},
+					&cli.StringFlag{
+						Name:  "download-schedule",
+						Usage: "Time-of-day windows during which the download rate differs, such as '08:00-18:00=2M' (empty to remove them)",
+					},
+				},
+				Action: setBandwidth,
+			},
+			{
+				Name:      "pin",
+				Usage:     "Keep a file, or a folder and its subtree, in the content cache for offline use",
+				ArgsUsage: "<path relative to the drive root>",
+				Action:    pin,
+			},
+			{
+				Name:      "unpin",
+				Usage:     "Remove the pin of a file or folder",
+				ArgsUsage: "<path relative to the drive root>",
+				Action:    unpin,
+			},
+			{
+				Name:   "unmount",
+				Usage:  "Unmount the running drive",
+				Action: unmount,
+			},
 		},
 	}
 
@@ -59,3 +310,43 @@ func main() {
 		log.Fatalf("%+v", err)
 	}
 }
+
+// defaultControlSocket returns the default path of the control socket of the drive, in the
+// user's runtime directory when available.
+func defaultControlSocket() string {
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, fmt.Sprintf("pcloud-drive-%d.sock", os.Getuid()))
+}
+
+// defaultMetadataStore returns the default path of the metadata store of the pCloud account
+// username, in the user's config directory.
+func defaultMetadataStore(username string) (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "pcloud-drive", username, "metadata.json"), nil
+}
+
+// defaultUploadDir returns the default directory of the chunked uploads of the pCloud account
+// username, in the user's cache directory.
+func defaultUploadDir(username string) (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "pcloud-drive", username, "uploads"), nil
+}
+
+// defaultCacheDir returns the default directory of the content cache of the pCloud account
+// username, in the user's cache directory.
+func defaultCacheDir(username string) (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "pcloud-drive", username, "content"), nil
+}
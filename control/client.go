@@ -0,0 +1,123 @@
+package control
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+
+	"github.com/seborama/pcloud-drive/v1/bandwidth"
+	"github.com/seborama/pcloud-drive/v1/fuse"
+)
+
+// Client talks to a running drive through its control socket.
+type Client struct {
+	httpClient *http.Client
+}
+
+// NewClient creates a Client for the drive listening on the Unix socket at socketPath.
+func NewClient(socketPath string) *Client {
+	return &Client{
+		httpClient: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					return (&net.Dialer{}).DialContext(ctx, "unix", socketPath)
+				},
+			},
+		},
+	}
+}
+
+// Status returns the state of the drive.
+func (c *Client) Status(ctx context.Context) (*fuse.Status, error) {
+	s := &fuse.Status{}
+	if err := c.do(ctx, http.MethodGet, "/status", nil, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Stats returns the metrics of the drive.
+func (c *Client) Stats(ctx context.Context) (map[string]float64, error) {
+	stats := map[string]float64{}
+	if err := c.do(ctx, http.MethodGet, "/stats", nil, &stats); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// FlushCache drops the directory cache of the drive.
+func (c *Client) FlushCache(ctx context.Context) error {
+	return c.do(ctx, http.MethodPost, "/cache/flush", nil, nil)
+}
+
+// Refresh fetches anew the details of the file or folder at path from pCloud.
+func (c *Client) Refresh(ctx context.Context, path string) error {
+	return c.do(ctx, http.MethodPost, "/refresh", RefreshRequest{Path: path}, nil)
+}
+
+// RevertRevision makes the revision revisionID the current version of the file at path.
+func (c *Client) RevertRevision(ctx context.Context, path string, revisionID uint64) error {
+	return c.do(ctx, http.MethodPost, "/revert", RevertRequest{Path: path, RevisionID: revisionID}, nil)
+}
+
+// Pin pins the file or folder at path, so that its content is kept in the content cache.
+func (c *Client) Pin(ctx context.Context, path string) error {
+	return c.do(ctx, http.MethodPost, "/pin", PinRequest{Path: path}, nil)
+}
+
+// Unpin removes the pin of the file or folder at path.
+func (c *Client) Unpin(ctx context.Context, path string) error {
+	return c.do(ctx, http.MethodPost, "/unpin", PinRequest{Path: path}, nil)
+}
+
+// SetBandwidth replaces the settings of the upload and download limits of the drive that are
+// not nil.
+func (c *Client) SetBandwidth(ctx context.Context, upload, download *bandwidth.Settings) error {
+	return c.do(ctx, http.MethodPost, "/bandwidth", BandwidthRequest{Upload: upload, Download: download}, nil)
+}
+
+// Unmount unmounts the drive.
+func (c *Client) Unmount(ctx context.Context) error {
+	return c.do(ctx, http.MethodPost, "/unmount", nil, nil)
+}
+
+func (c *Client) do(ctx context.Context, method, endpoint string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	// the host is irrelevant: the transport always dials the control socket.
+	req, err := http.NewRequestWithContext(ctx, method, "http://pcloud-drive"+endpoint, body)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("is the drive running? %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		er := ErrorResponse{}
+		if err = json.NewDecoder(resp.Body).Decode(&er); err != nil {
+			return fmt.Errorf("control request failed with HTTP status %d", resp.StatusCode)
+		}
+		return fmt.Errorf("control request failed: %s", er.Error)
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
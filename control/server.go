@@ -0,0 +1,222 @@
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/seborama/pcloud-drive/v1/bandwidth"
+	"github.com/seborama/pcloud-drive/v1/fuse"
+	"github.com/seborama/pcloud-drive/v1/logger"
+)
+
+// Drive is the running drive, as controlled through the control socket.
+type Drive interface {
+	Status() fuse.Status
+	Stats() (map[string]float64, error)
+	FlushCache()
+	Refresh(ctx context.Context, path string) error
+	RevertRevision(ctx context.Context, path string, revisionID uint64) error
+	Pin(ctx context.Context, path string) error
+	Unpin(ctx context.Context, path string) error
+	SetBandwidth(upload, download *bandwidth.Settings) error
+	Unmount() error
+}
+
+// RefreshRequest is the payload of a refresh request.
+type RefreshRequest struct {
+	Path string
+}
+
+// RevertRequest is the payload of a revert request.
+type RevertRequest struct {
+	Path       string
+	RevisionID uint64
+}
+
+// PinRequest is the payload of a pin or unpin request.
+type PinRequest struct {
+	Path string
+}
+
+// BandwidthRequest is the payload of a bandwidth request. The limits that are nil are left
+// unchanged.
+type BandwidthRequest struct {
+	Upload   *bandwidth.Settings `json:",omitempty"`
+	Download *bandwidth.Settings `json:",omitempty"`
+}
+
+// ErrorResponse is the payload returned when a request fails.
+type ErrorResponse struct {
+	Error string
+}
+
+// Serve serves the control API of drive on the Unix socket at socketPath, in the
+// background, until ctx is done.
+// It returns an error when the socket cannot be listened on.
+func Serve(ctx context.Context, socketPath string, drive Drive) error {
+	if err := removeStaleSocket(socketPath); err != nil {
+		return err
+	}
+
+	ln, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return err
+	}
+	// the control API can unmount the drive: restrict it to the owner.
+	if err = os.Chmod(socketPath, 0o600); err != nil {
+		_ = ln.Close()
+		return err
+	}
+
+	srv := &http.Server{
+		Handler:           newHandler(drive),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+
+	go func() {
+		logger.Infof("serving control API", "socket", socketPath)
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Errorf("control server failed", "error", err)
+		}
+	}()
+
+	return nil
+}
+
+func newHandler(drive Drive) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, drive.Status())
+	})
+
+	mux.HandleFunc("GET /stats", func(w http.ResponseWriter, r *http.Request) {
+		stats, err := drive.Stats()
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, stats)
+	})
+
+	mux.HandleFunc("POST /cache/flush", func(w http.ResponseWriter, r *http.Request) {
+		drive.FlushCache()
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("POST /refresh", func(w http.ResponseWriter, r *http.Request) {
+		req := RefreshRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+			return
+		}
+		if err := drive.Refresh(r.Context(), req.Path); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("POST /revert", func(w http.ResponseWriter, r *http.Request) {
+		req := RevertRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+			return
+		}
+		if err := drive.RevertRevision(r.Context(), req.Path, req.RevisionID); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("POST /pin", func(w http.ResponseWriter, r *http.Request) {
+		req := PinRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+			return
+		}
+		if err := drive.Pin(r.Context(), req.Path); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("POST /unpin", func(w http.ResponseWriter, r *http.Request) {
+		req := PinRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+			return
+		}
+		if err := drive.Unpin(r.Context(), req.Path); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("POST /bandwidth", func(w http.ResponseWriter, r *http.Request) {
+		req := BandwidthRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+			return
+		}
+		if err := drive.SetBandwidth(req.Upload, req.Download); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("POST /unmount", func(w http.ResponseWriter, r *http.Request) {
+		if err := drive.Unmount(); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Errorf("control response encoding failed", "error", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+}
+
+// removeStaleSocket removes the socket file left behind by a drive that is no longer
+// running. It fails if another drive is listening on it.
+func removeStaleSocket(socketPath string) error {
+	if _, err := os.Stat(socketPath); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	conn, err := net.DialTimeout("unix", socketPath, time.Second)
+	if err == nil {
+		_ = conn.Close()
+		return fmt.Errorf("control socket '%s' is in use by another drive", socketPath)
+	}
+
+	return os.Remove(socketPath)
+}
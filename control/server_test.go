@@ -0,0 +1,128 @@
+package control_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/seborama/pcloud-drive/v1/bandwidth"
+	"github.com/seborama/pcloud-drive/v1/control"
+	"github.com/seborama/pcloud-drive/v1/fuse"
+)
+
+type fakeDrive struct {
+	flushed   bool
+	refreshed string
+	reverted  string
+	pinned    map[string]bool
+	upload    *bandwidth.Settings
+	download  *bandwidth.Settings
+	unmounted bool
+}
+
+func (d *fakeDrive) Status() fuse.Status {
+	return fuse.Status{
+		Mountpoint: "/tmp/pcloud_mnt",
+		OpenFiles:  []fuse.OpenFile{{Path: "/a.txt", Writers: 1}},
+	}
+}
+
+func (d *fakeDrive) Stats() (map[string]float64, error) {
+	return map[string]float64{"pcloud_drive_fuse_open_handles": 1}, nil
+}
+
+func (d *fakeDrive) FlushCache() { d.flushed = true }
+
+func (d *fakeDrive) Refresh(_ context.Context, path string) error {
+	if path == "/missing" {
+		return errors.New("no such file or directory")
+	}
+	d.refreshed = path
+	return nil
+}
+
+func (d *fakeDrive) RevertRevision(_ context.Context, path string, revisionID uint64) error {
+	d.reverted = fmt.Sprintf("%s@%d", path, revisionID)
+	return nil
+}
+
+func (d *fakeDrive) Pin(_ context.Context, path string) error {
+	if d.pinned == nil {
+		d.pinned = map[string]bool{}
+	}
+	d.pinned[path] = true
+	return nil
+}
+
+func (d *fakeDrive) Unpin(_ context.Context, path string) error {
+	if !d.pinned[path] {
+		return fmt.Errorf("'%s' is not pinned itself", path)
+	}
+	delete(d.pinned, path)
+	return nil
+}
+
+func (d *fakeDrive) SetBandwidth(upload, download *bandwidth.Settings) error {
+	d.upload, d.download = upload, download
+	return nil
+}
+
+func (d *fakeDrive) Unmount() error {
+	d.unmounted = true
+	return nil
+}
+
+func TestControl(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	socket := filepath.Join(t.TempDir(), "control.sock")
+	drive := &fakeDrive{}
+
+	require.NoError(t, control.Serve(ctx, socket, drive))
+
+	// a second drive cannot take over the socket of a running drive.
+	require.Error(t, control.Serve(ctx, socket, drive))
+
+	c := control.NewClient(socket)
+
+	s, err := c.Status(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "/tmp/pcloud_mnt", s.Mountpoint)
+	require.Len(t, s.OpenFiles, 1)
+
+	stats, err := c.Stats(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1.0, stats["pcloud_drive_fuse_open_handles"])
+
+	require.NoError(t, c.FlushCache(ctx))
+	require.True(t, drive.flushed)
+
+	require.NoError(t, c.Refresh(ctx, "/docs"))
+	require.Equal(t, "/docs", drive.refreshed)
+
+	err = c.Refresh(ctx, "/missing")
+	require.ErrorContains(t, err, "no such file or directory")
+
+	require.NoError(t, c.RevertRevision(ctx, "/a.txt", 42))
+	require.Equal(t, "/a.txt@42", drive.reverted)
+
+	require.NoError(t, c.Pin(ctx, "/docs"))
+	require.True(t, drive.pinned["/docs"])
+	require.NoError(t, c.Unpin(ctx, "/docs"))
+	require.Empty(t, drive.pinned)
+	require.ErrorContains(t, c.Unpin(ctx, "/docs"), "not pinned")
+
+	schedule, err := bandwidth.ParseSchedule("08:00-18:00=1M")
+	require.NoError(t, err)
+	require.NoError(t, c.SetBandwidth(ctx, &bandwidth.Settings{Rate: 4 << 20, Schedule: schedule}, nil))
+	require.Equal(t, &bandwidth.Settings{Rate: 4 << 20, Schedule: schedule}, drive.upload)
+	require.Nil(t, drive.download)
+
+	require.NoError(t, c.Unmount(ctx))
+	require.True(t, drive.unmounted)
+}
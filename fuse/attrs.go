@@ -0,0 +1,113 @@
+package fuse
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+
+	"bazil.org/fuse"
+
+	"github.com/seborama/pcloud-drive/v1/logger"
+	"github.com/seborama/pcloud-drive/v1/metastore"
+)
+
+// AttrMode sets what becomes of the changes of mode and ownership (chmod, chown) of files and
+// folders, which pCloud cannot store.
+type AttrMode string
+
+const (
+	// AttrModeIgnore discards the changes.
+	AttrModeIgnore AttrMode = "ignore"
+	// AttrModeMemory keeps the changes in memory, until the entry is fetched anew from pCloud.
+	AttrModeMemory AttrMode = "memory"
+	// AttrModePersist keeps the changes in the metadata store.
+	AttrModePersist AttrMode = "persist"
+)
+
+// ParseAttrMode returns the AttrMode called s.
+func ParseAttrMode(s string) (AttrMode, error) {
+	switch mode := AttrMode(s); mode {
+	case AttrModeIgnore, AttrModeMemory, AttrModePersist:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown attribute mode '%s': expected ignore, memory or persist", s)
+	}
+}
+
+// Names of the attributes kept in the metadata store. They lie outside of the user
+// extended attributes namespace.
+const (
+	storedMode = "posix.mode"
+	storedUID  = "posix.uid"
+	storedGID  = "posix.gid"
+)
+
+// modeBits are the bits of the mode that chmod can change.
+const modeBits = os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+
+// applyStoredAttrs overrides attrs with the mode and ownership persisted for the node key.
+func (fs *FS) applyStoredAttrs(key metastore.Key, attrs *fuse.Attr) {
+	if fs.attrMode != AttrModePersist {
+		return
+	}
+
+	if v, ok := fs.storedAttr(key, storedMode); ok {
+		attrs.Mode = attrs.Mode&^modeBits | os.FileMode(v)&modeBits
+	}
+	if v, ok := fs.storedAttr(key, storedUID); ok {
+		attrs.Uid = uint32(v)
+	}
+	if v, ok := fs.storedAttr(key, storedGID); ok {
+		attrs.Gid = uint32(v)
+	}
+}
+
+func (fs *FS) storedAttr(key metastore.Key, name string) (uint64, bool) {
+	value, ok := fs.metadata.Get(key, name)
+	if !ok {
+		return 0, false
+	}
+
+	v, err := strconv.ParseUint(string(value), 10, 32)
+	if err != nil {
+		logger.Warnf("invalid stored attribute", "key", key, "name", name, "value", string(value))
+		return 0, false
+	}
+	return v, true
+}
+
+// setOwnership applies the changes of mode and ownership of req to attrs, the attributes of
+// the node key, according to the attribute mode of the drive.
+func (fs *FS) setOwnership(ctx context.Context, key metastore.Key, attrs *fuse.Attr, req *fuse.SetattrRequest) error {
+	if fs.attrMode == AttrModeIgnore {
+		return nil
+	}
+
+	changes := map[string]uint64{}
+	if req.Valid.Mode() {
+		attrs.Mode = attrs.Mode&^modeBits | req.Mode&modeBits
+		changes[storedMode] = uint64(req.Mode & modeBits)
+	}
+	if req.Valid.Uid() {
+		attrs.Uid = req.Uid
+		changes[storedUID] = uint64(req.Uid)
+	}
+	if req.Valid.Gid() {
+		attrs.Gid = req.Gid
+		changes[storedGID] = uint64(req.Gid)
+	}
+
+	if fs.attrMode != AttrModePersist {
+		return nil
+	}
+
+	for name, v := range changes {
+		if err := fs.metadata.Set(key, name, []byte(strconv.FormatUint(v, 10))); err != nil {
+			logger.ErrorContext(ctx, "metadata store Set failed", "name", name, "error", err)
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,61 @@
+package fuse
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"bazil.org/fuse"
+	"github.com/stretchr/testify/require"
+
+	"github.com/seborama/pcloud-drive/v1/metastore"
+)
+
+func TestSetOwnership(t *testing.T) {
+	store, err := metastore.Open(filepath.Join(t.TempDir(), "metadata.json"))
+	require.NoError(t, err)
+
+	key := metastore.FileKey(123)
+	req := &fuse.SetattrRequest{
+		Valid: fuse.SetattrMode | fuse.SetattrUid,
+		Mode:  0o755,
+		Uid:   1001,
+	}
+
+	tests := map[AttrMode]struct {
+		wantMode   os.FileMode
+		wantUID    uint32
+		wantStored bool
+	}{
+		AttrModeIgnore:  {wantMode: 0o640, wantUID: 1000},
+		AttrModeMemory:  {wantMode: 0o755, wantUID: 1001},
+		AttrModePersist: {wantMode: 0o755, wantUID: 1001, wantStored: true},
+	}
+
+	for mode, tt := range tests {
+		t.Run(string(mode), func(t *testing.T) {
+			fsys := &FS{metadata: store, attrMode: mode}
+			attrs := fuse.Attr{Mode: 0o640, Uid: 1000}
+
+			require.NoError(t, fsys.setOwnership(context.Background(), key, &attrs, req))
+			require.Equal(t, tt.wantMode, attrs.Mode)
+			require.Equal(t, tt.wantUID, attrs.Uid)
+
+			// the entry is fetched anew from pCloud.
+			refreshed := fuse.Attr{Mode: 0o640, Uid: 1000}
+			fsys.applyStoredAttrs(key, &refreshed)
+			if tt.wantStored {
+				require.Equal(t, attrs, refreshed)
+			} else {
+				require.Equal(t, os.FileMode(0o640), refreshed.Mode)
+			}
+		})
+	}
+
+	// the file type is preserved.
+	fsys := &FS{metadata: store, attrMode: AttrModePersist}
+	attrs := fuse.Attr{Mode: os.ModeDir | 0o750}
+	fsys.applyStoredAttrs(key, &attrs)
+	require.Equal(t, os.ModeDir|0o755, attrs.Mode)
+}
@@ -0,0 +1,301 @@
+package fuse
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"syscall"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+
+	"github.com/seborama/pcloud-drive/v1/bandwidth"
+	"github.com/seborama/pcloud-drive/v1/logger"
+	"github.com/seborama/pcloud-drive/v1/metrics"
+)
+
+// Status returns the current state of the drive.
+func (d *Drive) Status() Status {
+	s := Status{
+		Mountpoint: d.fs.mountpoint,
+		ReadWrite:  d.fs.readWrite,
+		StartTime:  d.fs.startTime,
+	}
+
+	d.fs.activity.status(&s)
+
+	s.Online = d.fs.online()
+	if !s.Online {
+		since := d.fs.pcClient.OfflineSince()
+		s.OfflineSince = &since
+	}
+
+	if d.fs.cache != nil {
+		s.Cache.ContentFiles, s.Cache.ContentSize = d.fs.cache.Usage()
+		s.Cache.PinnedFiles, s.Cache.PinnedSize = d.fs.cache.PinnedUsage()
+		s.Pins = d.fs.cache.Pins()
+	}
+
+	if d.fs.queue != nil {
+		s.PendingUploads = d.fs.queue.status()
+	}
+
+	if d.fs.uploadLimit != nil && d.fs.downloadLimit != nil {
+		s.Bandwidth = &Bandwidth{Upload: d.fs.uploadLimit.Status(), Download: d.fs.downloadLimit.Status()}
+	}
+
+	if d.fs.scheduler != nil {
+		api := d.fs.scheduler.Status()
+		s.APIRequests = &api
+	}
+
+	if d.fs.root != nil {
+		d.fs.root.walk(func(dir *Dir) {
+			dir.lock.RLock()
+			defer dir.lock.RUnlock()
+
+			s.Cache.Folders++
+			s.Cache.Entries += len(dir.Entries)
+		})
+	}
+
+	return s
+}
+
+// Stats returns the current value of the drive's metrics.
+func (d *Drive) Stats() (map[string]float64, error) {
+	return metrics.Snapshot()
+}
+
+// FlushCache marks the content of all the cached directories stale. It is fetched anew from
+// pCloud upon next access, which keeps the nodes of the files that are open or have uploads
+// pending.
+func (d *Drive) FlushCache() {
+	if d.fs.root == nil {
+		return
+	}
+
+	var flushed []*Dir
+	d.fs.root.walk(func(dir *Dir) { flushed = append(flushed, dir) })
+
+	for _, dir := range flushed {
+		dir.lock.Lock()
+		names := make([]string, 0, len(dir.Entries))
+		for name := range dir.Entries {
+			names = append(names, name)
+		}
+		dir.stale = true
+		dir.lock.Unlock()
+
+		for _, name := range names {
+			d.fs.invalidateEntry(dir, name)
+		}
+	}
+
+	logger.Infof("cache flushed", "folders", len(flushed))
+}
+
+// Refresh fetches anew from pCloud the details of the file or folder at p, a path relative
+// to the root of the drive.
+func (d *Drive) Refresh(ctx context.Context, p string) error {
+	if d.fs.root == nil {
+		return errors.New("drive is not mounted")
+	}
+
+	p = path.Clean("/" + p)
+	parent, node, err := d.fs.resolve(ctx, p)
+	if err != nil {
+		return err
+	}
+
+	if dir, ok := node.(*Dir); ok {
+		if err := dir.materialiseFolder(ctx); err != nil {
+			return err
+		}
+		d.fs.invalidateNode(dir)
+	}
+
+	if parent != nil {
+		if err := parent.materialiseFolder(ctx); err != nil {
+			return err
+		}
+		d.fs.invalidateEntry(parent, path.Base(p))
+	}
+
+	logger.InfoContext(ctx, "path refreshed", "path", p)
+
+	return nil
+}
+
+// RevertRevision makes the revision revisionID the current version of the file at p, a
+// path relative to the root of the drive.
+func (d *Drive) RevertRevision(ctx context.Context, p string, revisionID uint64) error {
+	if d.fs.root == nil {
+		return errors.New("drive is not mounted")
+	}
+
+	p = path.Clean("/" + p)
+	parent, node, err := d.fs.resolve(ctx, p)
+	if err != nil {
+		return err
+	}
+
+	file, ok := node.(*File)
+	if !ok {
+		return syscall.EISDIR
+	}
+
+	if err = d.fs.pcClient.RevertRevision(ctx, file.id(), revisionID); err != nil {
+		logger.ErrorContext(ctx, "RevertRevision failed", "path", p, "revisionID", revisionID, "error", err)
+		return err
+	}
+	logger.InfoContext(ctx, "revision reverted", "path", p, "revisionID", revisionID)
+
+	d.fs.invalidateNode(file)
+	if err := parent.materialiseFolder(ctx); err != nil {
+		return err
+	}
+	d.fs.invalidateEntry(parent, path.Base(p))
+
+	return nil
+}
+
+// Pin pins the file or folder at p, a path relative to the root of the drive: its content,
+// and that of its subtree, is downloaded into the content cache and kept up to date.
+func (d *Drive) Pin(ctx context.Context, p string) error {
+	if d.fs.root == nil {
+		return errors.New("drive is not mounted")
+	}
+	if d.fs.pins == nil {
+		return errNoContentCache
+	}
+
+	_, node, err := d.fs.resolve(ctx, path.Clean("/"+p))
+	if err != nil {
+		return err
+	}
+
+	return d.fs.pin(ctx, node)
+}
+
+// Unpin removes the pin of the file or folder at p, a path relative to the root of the
+// drive. Its content becomes subject to eviction from the content cache.
+func (d *Drive) Unpin(ctx context.Context, p string) error {
+	if d.fs.root == nil {
+		return errors.New("drive is not mounted")
+	}
+	if d.fs.pins == nil {
+		return errNoContentCache
+	}
+
+	p = path.Clean("/" + p)
+	_, node, err := d.fs.resolve(ctx, p)
+	if err != nil {
+		return err
+	}
+
+	removed, err := d.fs.unpin(ctx, node)
+	if err != nil {
+		return err
+	}
+	if !removed {
+		return fmt.Errorf("'%s' is not pinned itself", p)
+	}
+
+	return nil
+}
+
+// SetBandwidth replaces the settings of the upload and download limits that are not nil.
+func (d *Drive) SetBandwidth(upload, download *bandwidth.Settings) error {
+	if d.fs.uploadLimit == nil || d.fs.downloadLimit == nil {
+		return errors.New("bandwidth limits are not available")
+	}
+
+	if upload != nil {
+		d.fs.uploadLimit.Set(*upload)
+		logger.Infof("upload limit changed", "rate", bandwidth.FormatRate(upload.Rate), "schedule", upload.Schedule.String())
+	}
+	if download != nil {
+		d.fs.downloadLimit.Set(*download)
+		logger.Infof("download limit changed", "rate", bandwidth.FormatRate(download.Rate), "schedule", download.Schedule.String())
+	}
+
+	return nil
+}
+
+// walk calls fn for the receiver and each of its descendant directories whose content is
+// cached.
+func (d *Dir) walk(fn func(*Dir)) {
+	d.lock.RLock()
+	if d.Entries == nil {
+		d.lock.RUnlock()
+		return
+	}
+	subDirs := make([]*Dir, 0, len(d.Entries))
+	for _, node := range d.Entries {
+		if dir, ok := node.(*Dir); ok {
+			subDirs = append(subDirs, dir)
+		}
+	}
+	d.lock.RUnlock()
+
+	fn(d)
+
+	for _, dir := range subDirs {
+		dir.walk(fn)
+	}
+}
+
+// resolve returns the node at p, an absolute path within the drive, along with its parent
+// directory (nil for the root).
+func (fsys *FS) resolve(ctx context.Context, p string) (*Dir, fs.Node, error) {
+	var parent *Dir
+	var node fs.Node = fsys.root
+
+	for _, name := range strings.Split(strings.TrimPrefix(p, "/"), "/") {
+		if name == "" {
+			continue
+		}
+
+		dir, ok := node.(*Dir)
+		if !ok {
+			return nil, nil, syscall.ENOTDIR
+		}
+
+		child, ok := dir.entry(name)
+		if !ok || dir.isStale() {
+			if err := dir.materialiseFolder(ctx); err != nil {
+				return nil, nil, err
+			}
+			if child, ok = dir.entry(name); !ok {
+				return nil, nil, syscall.ENOENT
+			}
+		}
+
+		parent, node = dir, child
+	}
+
+	return parent, node, nil
+}
+
+// invalidateNode asks the kernel to drop its cached attributes and data of node.
+func (fs *FS) invalidateNode(node fs.Node) {
+	if fs.server == nil {
+		return
+	}
+	if err := fs.server.InvalidateNodeData(node); err != nil && !errors.Is(err, fuse.ErrNotCached) {
+		logger.Warnf("InvalidateNodeData failed", "error", err)
+	}
+}
+
+// invalidateEntry asks the kernel to drop its cached directory entry name in dir.
+func (fs *FS) invalidateEntry(dir *Dir, name string) {
+	if fs.server == nil {
+		return
+	}
+	if err := fs.server.InvalidateEntry(dir, name); err != nil && !errors.Is(err, fuse.ErrNotCached) {
+		logger.Warnf("InvalidateEntry failed", "name", name, "error", err)
+	}
+}
@@ -0,0 +1,208 @@
+package fuse
+
+import (
+	"context"
+	"sync"
+
+	"bazil.org/fuse"
+	"github.com/seborama/pcloud-sdk/sdk"
+
+	"github.com/seborama/pcloud-drive/v1/scheduler"
+)
+
+// downloadChunkSize is the size of the ranges that the downloader fetches.
+const downloadChunkSize = 4 * mb
+
+// downloadMinStreak is how much of a file must be read sequentially before the downloader
+// fetches ahead, so that reading the head of a file does not download more of it.
+const downloadMinStreak = mb
+
+// fetchFunc reads at most size bytes of a file at offset.
+type fetchFunc func(ctx context.Context, offset, size int64) ([]byte, error)
+
+// downloader speeds up the sequential reads of a file: once the reads are found sequential,
+// it fetches the next chunks of the file concurrently, ahead of the reads, and serves the
+// reads from them.
+type downloader struct {
+	fetch       fetchFunc
+	size        int64
+	chunkSize   int64
+	parallelism int
+
+	// ctx bounds the fetches, which outlive the reads that start them.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	lock   sync.Mutex // protects chunks, next and streak
+	chunks map[int64]*chunk
+	next   int64 // offset of the next sequential read
+	streak int64 // bytes read sequentially so far
+}
+
+// chunk is a range of the file, fetched in the background.
+type chunk struct {
+	done chan struct{} // closed once data or err is set
+	data []byte
+	err  error
+}
+
+func newDownloader(fetch fetchFunc, size, chunkSize int64, parallelism int) *downloader {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &downloader{
+		fetch:       fetch,
+		size:        size,
+		chunkSize:   chunkSize,
+		parallelism: parallelism,
+		ctx:         ctx,
+		cancel:      cancel,
+		chunks:      map[int64]*chunk{},
+	}
+}
+
+// read reads size bytes at offset. It reports false when the read is not part of a
+// sequential read, which the caller must then read by itself.
+func (d *downloader) read(ctx context.Context, offset, size int64) ([]byte, bool, error) {
+	first := offset / d.chunkSize
+	end := min(offset+size, d.size)
+
+	d.lock.Lock()
+	_, ahead := d.chunks[first]
+	if ahead || offset == d.next {
+		d.streak += end - offset
+		d.next = max(d.next, end)
+	} else {
+		d.streak = 0
+		d.next = end
+	}
+	if !ahead && d.streak < downloadMinStreak || offset >= d.size {
+		d.lock.Unlock()
+		return nil, false, nil
+	}
+
+	// drop the chunks that were read, and fetch those ahead.
+	for idx := range d.chunks {
+		if idx < first {
+			delete(d.chunks, idx)
+		}
+	}
+	lastChunk := (d.size - 1) / d.chunkSize
+	lastNeeded := (end - 1) / d.chunkSize
+	for idx := first; idx < first+int64(d.parallelism) && idx <= lastChunk || idx <= lastNeeded; idx++ {
+		if _, ok := d.chunks[idx]; ok {
+			continue
+		}
+		// the chunks that the read waits for are fetched at its priority, those ahead of it
+		// are prefetch.
+		priority := scheduler.Background
+		if idx <= lastNeeded {
+			priority = scheduler.PriorityFrom(ctx)
+		}
+		d.chunks[idx] = d.start(idx, priority)
+	}
+
+	var needed []*chunk
+	for idx := first; idx <= lastNeeded; idx++ {
+		needed = append(needed, d.chunks[idx])
+	}
+	d.lock.Unlock()
+
+	data := make([]byte, 0, end-offset)
+	for i, c := range needed {
+		select {
+		case <-c.done:
+		case <-ctx.Done():
+			return nil, true, ctx.Err()
+		}
+		if c.err != nil {
+			d.forget(first+int64(i), c)
+			return nil, true, c.err
+		}
+
+		chunkOffset := (first + int64(i)) * d.chunkSize
+		from := max(offset-chunkOffset, 0)
+		to := min(end-chunkOffset, int64(len(c.data)))
+		if from < to {
+			data = append(data, c.data[from:to]...)
+		}
+	}
+
+	return data, true, nil
+}
+
+// start fetches the chunk idx in the background, at priority.
+func (d *downloader) start(idx int64, priority scheduler.Priority) *chunk {
+	c := &chunk{done: make(chan struct{})}
+	ctx := scheduler.WithPriority(d.ctx, priority)
+
+	go func() {
+		defer close(c.done)
+		c.data, c.err = d.fetch(ctx, idx*d.chunkSize, d.chunkSize)
+	}()
+
+	return c
+}
+
+// forget drops the chunk idx if it is c, so that it is fetched anew.
+func (d *downloader) forget(idx int64, c *chunk) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if d.chunks[idx] == c {
+		delete(d.chunks, idx)
+	}
+}
+
+// close cancels the fetches in progress.
+func (d *downloader) close() {
+	d.cancel()
+}
+
+// downloader returns the downloader of the handle of the file, creating it upon first use,
+// so that the sequential reads of each handle are told apart. Its fetches read the file from
+// the content servers.
+func (f *File) downloader(handle fuse.HandleID) *downloader {
+	f.downloadLock.Lock()
+	defer f.downloadLock.Unlock()
+
+	if d, ok := f.downloads[handle]; ok {
+		return d
+	}
+
+	fetch := func(ctx context.Context, offset, size int64) ([]byte, error) {
+		fileLink := func(refresh bool) (*sdk.FileLink, error) {
+			return f.fs.links.get(ctx, f.fs.pcClient, f.id(), refresh)
+		}
+		return f.fs.readLink(ctx, fileLink, offset, size)
+	}
+	d := newDownloader(fetch, int64(f.attrs().Size), downloadChunkSize, f.fs.downloadParallelism)
+	if f.downloads == nil {
+		f.downloads = map[fuse.HandleID]*downloader{}
+	}
+	f.downloads[handle] = d
+
+	return d
+}
+
+// closeDownloader cancels the fetches of the downloader of the handle of the file, if any,
+// and discards the chunks that it holds, once the handle is released.
+func (f *File) closeDownloader(handle fuse.HandleID) {
+	f.downloadLock.Lock()
+	defer f.downloadLock.Unlock()
+
+	if d, ok := f.downloads[handle]; ok {
+		d.close()
+		delete(f.downloads, handle)
+	}
+}
+
+// closeDownloaders closes the downloaders of all the handles of the file, such as when its
+// content changes.
+func (f *File) closeDownloaders() {
+	f.downloadLock.Lock()
+	defer f.downloadLock.Unlock()
+
+	for handle, d := range f.downloads {
+		d.close()
+		delete(f.downloads, handle)
+	}
+}
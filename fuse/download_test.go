@@ -0,0 +1,138 @@
+package fuse
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"bazil.org/fuse"
+	"github.com/stretchr/testify/require"
+
+	"github.com/seborama/pcloud-drive/v1/scheduler"
+)
+
+func TestDownloader(t *testing.T) {
+	const chunkSize = 4 * mb
+
+	content := make([]byte, 10*mb+123)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+
+	var fetches atomic.Int32
+	var failNext atomic.Bool
+	fetch := func(_ context.Context, offset, size int64) ([]byte, error) {
+		fetches.Add(1)
+		if failNext.CompareAndSwap(true, false) {
+			return nil, errors.New("connection reset")
+		}
+		return content[offset:min(offset+size, int64(len(content)))], nil
+	}
+
+	ctx := context.Background()
+	d := newDownloader(fetch, int64(len(content)), chunkSize, 2)
+	defer d.close()
+
+	// the head of the file is left to the caller.
+	_, ok, err := d.read(ctx, 0, 128*1024)
+	require.NoError(t, err)
+	require.False(t, ok)
+	require.Zero(t, fetches.Load())
+
+	// a sequential read is served from chunks fetched ahead.
+	var got bytes.Buffer
+	got.Write(content[:128*1024])
+	for offset := int64(128 * 1024); offset < int64(len(content)); offset += 128 * 1024 {
+		data, ok, err := d.read(ctx, offset, 128*1024)
+		require.NoError(t, err)
+		if !ok {
+			data = content[offset:min(offset+128*1024, int64(len(content)))]
+		}
+		got.Write(data)
+	}
+	require.Equal(t, content, got.Bytes())
+	require.EqualValues(t, 3, fetches.Load()) // one per chunk
+
+	// a random read is left to the caller.
+	_, ok, err = d.read(ctx, 5*mb, 100)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	// a failed chunk is fetched anew by the next read.
+	d = newDownloader(fetch, int64(len(content)), chunkSize, 1)
+	defer d.close()
+	failNext.Store(true)
+	_, ok, err = d.read(ctx, 0, mb)
+	require.True(t, ok)
+	require.Error(t, err)
+	data, ok, err := d.read(ctx, mb, mb)
+	require.True(t, ok)
+	require.NoError(t, err)
+	require.Equal(t, content[mb:2*mb], data)
+}
+
+func TestFile_Downloaders(t *testing.T) {
+	pfs := &FS{activity: newActivity(), downloadParallelism: 2}
+	f := &File{fs: pfs, path: "/a.bin", fileID: 11}
+
+	// each handle of the file has its own downloader.
+	first, second := f.downloader(1), f.downloader(2)
+	require.NotSame(t, first, second)
+	require.Same(t, first, f.downloader(1))
+
+	// closing a descriptor of a handle leaves the fetches of the handles in progress.
+	ctx := context.Background()
+	require.NoError(t, f.Flush(ctx, &fuse.FlushRequest{Header: fuse.Header{ID: 1}, Handle: 1}))
+	require.NoError(t, first.ctx.Err())
+	require.NoError(t, second.ctx.Err())
+
+	// releasing a handle cancels the fetches of its downloader only.
+	require.NoError(t, f.Release(ctx, &fuse.ReleaseRequest{Handle: 1, Flags: fuse.OpenReadOnly}))
+	require.Error(t, first.ctx.Err())
+	require.NoError(t, second.ctx.Err())
+	require.NotSame(t, first, f.downloader(1))
+
+	// a change of content cancels them all.
+	f.closeDownloaders()
+	require.Error(t, second.ctx.Err())
+}
+
+func TestDownloader_Priority(t *testing.T) {
+	const chunkSize = 4 * mb
+
+	var lock sync.Mutex
+	priorities := map[int64]scheduler.Priority{} // of the fetches, by offset
+	fetch := func(ctx context.Context, offset, size int64) ([]byte, error) {
+		lock.Lock()
+		priorities[offset] = scheduler.PriorityFrom(ctx)
+		lock.Unlock()
+		return make([]byte, size), nil
+	}
+
+	ctx := scheduler.WithPriority(context.Background(), scheduler.Normal)
+	d := newDownloader(fetch, 3*chunkSize, chunkSize, 3)
+	defer d.close()
+
+	_, ok, err := d.read(ctx, 0, mb/2)
+	require.NoError(t, err)
+	require.False(t, ok)
+	_, ok, err = d.read(ctx, mb/2, mb)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	// the chunk that the read waits for is fetched at its priority, the others are prefetch.
+	require.Eventually(t, func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return len(priorities) == 3
+	}, 5*time.Second, time.Millisecond)
+	require.Equal(t, map[int64]scheduler.Priority{
+		0:             scheduler.Normal,
+		chunkSize:     scheduler.Background,
+		2 * chunkSize: scheduler.Background,
+	}, priorities)
+}
@@ -0,0 +1,131 @@
+package fuse
+
+import (
+	"context"
+	"path"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/seborama/pcloud-sdk/sdk"
+
+	"github.com/seborama/pcloud-drive/v1/logger"
+	"github.com/seborama/pcloud-drive/v1/metrics"
+)
+
+// uniqueNames returns the names under which the items of a pCloud folder are presented,
+// as given by name, and the sorted names of the items that are disambiguated.
+// pCloud lets a folder hold a file and a folder of the same name, and its trash can hold
+// several entries of the same name: all but one of them are presented with a suffix made of
+// their ID. The entry that keeps its name is the folder, if any, or else the oldest file
+// (the lowest ID), so that the names are stable across listings.
+func uniqueNames(items []*sdk.Metadata, name func(*sdk.Metadata) string) (map[*sdk.Metadata]string, []string) {
+	byName := map[string][]*sdk.Metadata{}
+	for _, item := range items {
+		n := name(item)
+		byName[n] = append(byName[n], item)
+	}
+
+	names := make(map[*sdk.Metadata]string, len(items))
+	var duplicates []string
+
+	// the names in order, so that the suffixes added to avoid collisions are stable.
+	sorted := make([]string, 0, len(byName))
+	for n := range byName {
+		sorted = append(sorted, n)
+	}
+	sort.Strings(sorted)
+
+	taken := make(map[string]bool, len(items))
+	for n := range byName {
+		taken[n] = true
+	}
+
+	for _, n := range sorted {
+		dups := byName[n]
+		if len(dups) == 1 {
+			names[dups[0]] = n
+			continue
+		}
+
+		sort.Slice(dups, func(i, j int) bool {
+			if dups[i].IsFolder != dups[j].IsFolder {
+				return dups[i].IsFolder
+			}
+			return itemID(dups[i]) < itemID(dups[j])
+		})
+
+		names[dups[0]] = n
+		for _, item := range dups[1:] {
+			unique := disambiguate(n, item)
+			for taken[unique] {
+				// the name of another entry: the suffix is repeated until the name is free.
+				unique = disambiguate(unique, item)
+			}
+			taken[unique] = true
+			names[item] = unique
+			duplicates = append(duplicates, unique)
+		}
+	}
+	sort.Strings(duplicates)
+
+	return names, duplicates
+}
+
+// duplicates keeps track of the entries presented under a disambiguated name, by folder,
+// so that they are reported when they change rather than upon every listing.
+type duplicates struct {
+	lock     sync.Mutex
+	byFolder map[string][]string
+}
+
+// report records the sorted names that are disambiguated in the folder, such as
+// "folder:123". They are logged when they differ from those of the previous listing.
+func (d *duplicates) report(ctx context.Context, folder string, names []string) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if slices.Equal(d.byFolder[folder], names) {
+		return
+	}
+
+	if len(names) == 0 {
+		delete(d.byFolder, folder)
+	} else {
+		if d.byFolder == nil {
+			d.byFolder = map[string][]string{}
+		}
+		d.byFolder[folder] = names
+		logger.WarnContext(ctx, "several pCloud entries have the same name: presenting them with distinct names", "folder", folder, "names", names)
+	}
+
+	total := 0
+	for _, names := range d.byFolder {
+		total += len(names)
+	}
+	metrics.DuplicateNames(total)
+}
+
+// disambiguate returns name with a suffix made of the ID of item. The suffix comes before
+// the extension of files, so that they still open with the same application.
+func disambiguate(name string, item *sdk.Metadata) string {
+	if item.IsFolder {
+		return name + "~d" + strconv.FormatUint(item.FolderID, 10)
+	}
+
+	ext := path.Ext(name)
+	if ext == name {
+		// dot file, such as .profile
+		ext = ""
+	}
+	return strings.TrimSuffix(name, ext) + "~f" + strconv.FormatUint(item.FileID, 10) + ext
+}
+
+func itemID(item *sdk.Metadata) uint64 {
+	if item.IsFolder {
+		return item.FolderID
+	}
+	return item.FileID
+}
@@ -0,0 +1,59 @@
+package fuse
+
+import (
+	"context"
+	"testing"
+
+	"github.com/seborama/pcloud-sdk/sdk"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUniqueNames(t *testing.T) {
+	newerFile := &sdk.Metadata{Name: "report.pdf", FileID: 30}
+	olderFile := &sdk.Metadata{Name: "report.pdf", FileID: 20}
+	folder := &sdk.Metadata{Name: "report.pdf", FolderID: 10, IsFolder: true}
+	dotFile := &sdk.Metadata{Name: ".profile", FileID: 40}
+	otherDotFile := &sdk.Metadata{Name: ".profile", FileID: 50}
+	unique := &sdk.Metadata{Name: "notes.txt", FileID: 60}
+
+	names, duplicates := uniqueNames(
+		[]*sdk.Metadata{newerFile, olderFile, folder, dotFile, otherDotFile, unique},
+		func(item *sdk.Metadata) string { return item.Name },
+	)
+
+	require.Equal(t, map[*sdk.Metadata]string{
+		folder:       "report.pdf",
+		olderFile:    "report~f20.pdf",
+		newerFile:    "report~f30.pdf",
+		dotFile:      ".profile",
+		otherDotFile: ".profile~f50",
+		unique:       "notes.txt",
+	}, names)
+	require.Equal(t, []string{".profile~f50", "report~f20.pdf", "report~f30.pdf"}, duplicates)
+
+	// a disambiguated name that another entry has is disambiguated again.
+	taken := &sdk.Metadata{Name: "report~f20.pdf", FileID: 70}
+	names, _ = uniqueNames(
+		[]*sdk.Metadata{olderFile, folder, taken},
+		func(item *sdk.Metadata) string { return item.Name },
+	)
+	require.Equal(t, map[*sdk.Metadata]string{
+		folder:    "report.pdf",
+		olderFile: "report~f20~f20.pdf",
+		taken:     "report~f20.pdf",
+	}, names)
+}
+
+func TestDuplicates(t *testing.T) {
+	ctx := context.Background()
+	d := &duplicates{}
+
+	d.report(ctx, "folder:1", []string{"a~f2", "b~f3"})
+	d.report(ctx, "folder:1", []string{"a~f2", "b~f3"})
+	d.report(ctx, "folder:4", []string{"c~f5"})
+	require.Equal(t, map[string][]string{"folder:1": {"a~f2", "b~f3"}, "folder:4": {"c~f5"}}, d.byFolder)
+
+	// the folders whose duplicates are gone are no longer counted.
+	d.report(ctx, "folder:1", nil)
+	require.Equal(t, map[string][]string{"folder:4": {"c~f5"}}, d.byFolder)
+}
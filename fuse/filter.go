@@ -0,0 +1,57 @@
+package fuse
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+)
+
+// Filter hides the entries of the drive whose name or path matches its exclude patterns,
+// unless they also match its include patterns.
+// Patterns follow the syntax of path.Match. A pattern without a slash is matched against
+// the name of the entries, whatever their folder. A pattern with a slash is matched against
+// their path relative to the root of the drive.
+type Filter struct {
+	include []string
+	exclude []string
+}
+
+// NewFilter returns a Filter made of the include and exclude patterns.
+func NewFilter(include, exclude []string) (*Filter, error) {
+	for _, pattern := range slices.Concat(include, exclude) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern '%s': %w", pattern, err)
+		}
+	}
+
+	return &Filter{
+		include: include,
+		exclude: exclude,
+	}, nil
+}
+
+// Hidden reports whether the entry at p, an absolute path within the drive, is hidden.
+func (f *Filter) Hidden(p string) bool {
+	if f == nil {
+		return false
+	}
+	return matchAny(f.exclude, p) && !matchAny(f.include, p)
+}
+
+func matchAny(patterns []string, p string) bool {
+	name := path.Base(p)
+	rel := strings.TrimPrefix(p, "/")
+
+	for _, pattern := range patterns {
+		target := name
+		if strings.Contains(pattern, "/") {
+			target = rel
+		}
+		if ok, _ := path.Match(strings.TrimPrefix(pattern, "/"), target); ok {
+			return true
+		}
+	}
+
+	return false
+}
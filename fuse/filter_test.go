@@ -0,0 +1,33 @@
+package fuse_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	pfuse "github.com/seborama/pcloud-drive/v1/fuse"
+)
+
+func TestFilter(t *testing.T) {
+	f, err := pfuse.NewFilter(
+		[]string{"keep/node_modules"},
+		[]string{".DS_Store", "Thumbs.db", "node_modules", "*.tmp", "/build/*"},
+	)
+	require.NoError(t, err)
+
+	require.True(t, f.Hidden("/.DS_Store"))
+	require.True(t, f.Hidden("/photos/2024/Thumbs.db"))
+	require.True(t, f.Hidden("/src/app/node_modules"))
+	require.True(t, f.Hidden("/docs/draft.tmp"))
+	require.True(t, f.Hidden("/build/app"))
+
+	require.False(t, f.Hidden("/docs/report.pdf"))
+	require.False(t, f.Hidden("/src/build/app"))
+	require.False(t, f.Hidden("/keep/node_modules"))
+
+	var none *pfuse.Filter
+	require.False(t, none.Hidden("/.DS_Store"))
+
+	_, err = pfuse.NewFilter(nil, []string{"[a-"})
+	require.Error(t, err)
+}
@@ -0,0 +1,150 @@
+package fuse
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/seborama/pcloud-sdk/sdk"
+
+	"github.com/seborama/pcloud-drive/v1/logger"
+	"github.com/seborama/pcloud-drive/v1/metrics"
+	"github.com/seborama/pcloud-drive/v1/pcloud"
+)
+
+// ReadMode sets how the content of the files opened for reading only is read.
+type ReadMode string
+
+const (
+	// ReadModeFD reads through a pCloud file descriptor, on the API servers.
+	ReadModeFD ReadMode = "fd"
+	// ReadModeHTTP reads with HTTP range requests to the content servers, through the links
+	// that pCloud issues for the files.
+	ReadModeHTTP ReadMode = "http"
+)
+
+// ParseReadMode returns the ReadMode called s.
+func ParseReadMode(s string) (ReadMode, error) {
+	switch mode := ReadMode(s); mode {
+	case ReadModeFD, ReadModeHTTP:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown read mode '%s': expected fd or http", s)
+	}
+}
+
+// linkExpiryMargin is how long before their expiry the links are renewed.
+const linkExpiryMargin = time.Minute
+
+// linkCache holds the download links of the files, so that they serve all the reads of the
+// files until they expire.
+type linkCache struct {
+	lock     sync.Mutex
+	links    map[uint64]*sdk.FileLink
+	fetching map[uint64]*linkFetch // the links being obtained from pCloud, by file ID
+}
+
+// linkFetch is a link being obtained from pCloud, which the concurrent reads of the file wait
+// for rather than each ask pCloud for one.
+type linkFetch struct {
+	done chan struct{} // closed once link and err are set
+	link *sdk.FileLink
+	err  error
+}
+
+func newLinkCache() *linkCache {
+	return &linkCache{links: map[uint64]*sdk.FileLink{}, fetching: map[uint64]*linkFetch{}}
+}
+
+// get returns the download link of the file fileID, obtaining it from pCloud when there is
+// none yet, when it is about to expire or when refresh is set.
+func (c *linkCache) get(ctx context.Context, pcClient *pcloud.Client, fileID uint64, refresh bool) (*sdk.FileLink, error) {
+	c.lock.Lock()
+	link, ok := c.links[fileID]
+	if ok && !refresh && time.Until(link.Expires.Time) > linkExpiryMargin {
+		c.lock.Unlock()
+		metrics.CacheLookup("links", true)
+		return link, nil
+	}
+	metrics.CacheLookup("links", false)
+
+	fetch, ok := c.fetching[fileID]
+	if !ok {
+		fetch = &linkFetch{done: make(chan struct{})}
+		c.fetching[fileID] = fetch
+		c.lockedEvict()
+	}
+	c.lock.Unlock()
+
+	if ok {
+		// another read is obtaining the link already.
+		select {
+		case <-fetch.done:
+			return fetch.link, fetch.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	fetch.link, fetch.err = pcClient.GetFileLink(ctx, sdk.T3FileByID(fileID), true, "", 0, true)
+	if fetch.err != nil {
+		logger.ErrorContext(ctx, "GetFileLink failed", "fileID", fileID, "error", fetch.err)
+	}
+
+	c.lock.Lock()
+	// the link is not kept when the file was dropped meanwhile: it may serve stale content.
+	if c.fetching[fileID] == fetch {
+		delete(c.fetching, fileID)
+		if fetch.err == nil {
+			c.links[fileID] = fetch.link
+		}
+	}
+	c.lock.Unlock()
+	close(fetch.done)
+
+	return fetch.link, fetch.err
+}
+
+// lockedEvict forgets the links that have expired, which no longer serve any read. The
+// caller must hold the lock.
+func (c *linkCache) lockedEvict() {
+	for fileID, link := range c.links {
+		if time.Until(link.Expires.Time) <= 0 {
+			delete(c.links, fileID)
+		}
+	}
+}
+
+// drop forgets the link of the file fileID, such as when its content changes.
+func (c *linkCache) drop(fileID uint64) {
+	c.lock.Lock()
+	delete(c.links, fileID)
+	delete(c.fetching, fileID)
+	c.lock.Unlock()
+}
+
+// readLink reads size bytes at offset from the content servers, through the link that
+// fileLink returns. The link is refreshed once if it has expired.
+func (fs *FS) readLink(ctx context.Context, fileLink func(refresh bool) (*sdk.FileLink, error), offset, size int64) ([]byte, error) {
+	link, err := fileLink(false)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := fs.pcClient.ReadRange(ctx, link, offset, size)
+	if errors.Is(err, pcloud.ErrLinkExpired) {
+		logger.DebugContext(ctx, "file link expired: refreshing it")
+		if link, err = fileLink(true); err != nil {
+			return nil, err
+		}
+		data, err = fs.pcClient.ReadRange(ctx, link, offset, size)
+	}
+	if err != nil {
+		logger.ErrorContext(ctx, "ReadRange failed", "error", err)
+		return nil, err
+	}
+
+	return data, nil
+}
@@ -0,0 +1,74 @@
+package fuse
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLinkCache(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	pcClient := newFakePCloud(t, func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		switch r.URL.Path {
+		case "/getfilelink":
+			calls.Add(1)
+			expires := "Sat, 24 Jul 2100 10:00:00 +0000"
+			switch q.Get("fileid") {
+			case "1":
+				<-release
+			case "3":
+				expires = "Sat, 24 Jul 2021 10:00:00 +0000"
+			}
+			_, _ = fmt.Fprintf(w, `{"result": 0, "path": "/content/%s", "hosts": ["c1.pcloud.com"], "expires": %q}`, q.Get("fileid"), expires)
+
+		default:
+			t.Errorf("unexpected method %s", r.URL.Path)
+		}
+	})
+
+	c := newLinkCache()
+	ctx := context.Background()
+
+	_, err := c.get(ctx, pcClient, 2, false)
+	require.NoError(t, err)
+
+	// the concurrent reads of a file share the link obtained for the first of them.
+	var wg sync.WaitGroup
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			link, err := c.get(ctx, pcClient, 1, false)
+			require.NoError(t, err)
+			require.Equal(t, "/content/1", link.Path)
+		}()
+	}
+	require.Eventually(t, func() bool { return calls.Load() == 2 }, time.Second, time.Millisecond)
+
+	// the links of the other files are served meanwhile.
+	link, err := c.get(ctx, pcClient, 2, false)
+	require.NoError(t, err)
+	require.Equal(t, "/content/2", link.Path)
+
+	time.Sleep(10 * time.Millisecond) // for the reads to wait for the link
+	close(release)
+	wg.Wait()
+	require.EqualValues(t, 2, calls.Load())
+
+	// the expired links are forgotten.
+	_, err = c.get(ctx, pcClient, 3, false)
+	require.NoError(t, err)
+	require.Contains(t, c.links, uint64(3))
+	_, err = c.get(ctx, pcClient, 4, false)
+	require.NoError(t, err)
+	require.NotContains(t, c.links, uint64(3))
+	require.Contains(t, c.links, uint64(2))
+}
@@ -2,11 +2,15 @@ package fuse
 
 import (
 	"context"
-	"log"
+	"errors"
+	"io"
 	"log/slog"
 	"os"
 	"os/user"
+	"path"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -14,7 +18,14 @@ import (
 	"bazil.org/fuse/fs"
 	_ "bazil.org/fuse/fs/fstestutil"
 	"github.com/samber/lo"
+	"github.com/seborama/pcloud-drive/v1/bandwidth"
+	"github.com/seborama/pcloud-drive/v1/cache"
 	"github.com/seborama/pcloud-drive/v1/logger"
+	"github.com/seborama/pcloud-drive/v1/metastore"
+	"github.com/seborama/pcloud-drive/v1/metrics"
+	"github.com/seborama/pcloud-drive/v1/pcloud"
+	"github.com/seborama/pcloud-drive/v1/scheduler"
+	"github.com/seborama/pcloud-drive/v1/uploads"
 	"github.com/seborama/pcloud-sdk/sdk"
 )
 
@@ -22,13 +33,13 @@ import (
 // Dir.Entries - eg: create / delete Dir / File, rename, move, etc
 
 type Drive struct {
-	fs   fs.FS
+	fs   *FS
 	conn *fuse.Conn // TODO: define an interface
 }
 
 const mb = 1_048_576
 
-func NewDrive(mountpoint string, readWrite bool, pcClient *sdk.Client) (*Drive, error) {
+func NewDrive(mountpoint string, readWrite bool, pcClient *pcloud.Client, opts ...Option) (*Drive, error) {
 	mountOpts := []fuse.MountOption{
 		fuse.FSName("pcloud"),
 		fuse.Subtype("seborama"),
@@ -60,39 +71,125 @@ func NewDrive(mountpoint string, readWrite bool, pcClient *sdk.Client) (*Drive,
 		return nil, err
 	}
 
+	pfs := &FS{
+		conn:                conn,
+		pcClient:            pcClient,
+		mountpoint:          mountpoint,
+		readWrite:           readWrite,
+		startTime:           time.Now(),
+		activity:            newActivity(),
+		uid:                 uint32(uid),
+		gid:                 uint32(gid),
+		dirPerms:            0o750,
+		filePerms:           0o640,
+		dirValid:            2 * time.Second,
+		fileValid:           time.Second,
+		attrMode:            AttrModeMemory,
+		readMode:            ReadModeFD,
+		links:               newLinkCache(),
+		downloadParallelism: 4,
+		uploadChunkSize:     defaultUploadChunkSize,
+		uploadWorkers:       2,
+		names:               names{form: NameFormNone},
+	}
+	for _, opt := range opts {
+		opt(pfs)
+	}
+
+	if pfs.uploads != nil {
+		pfs.queue = newUploadQueue(pfs, pfs.uploadWorkers)
+	}
+	if pfs.cache != nil {
+		pfs.pins = newPinner(pfs)
+	}
+
+	if pfs.attrMode == AttrModePersist && pfs.metadata == nil {
+		_ = conn.Close()
+		return nil, errors.New("persisting attributes requires a metadata store")
+	}
+
 	return &Drive{
-		fs: &FS{
-			conn:      conn,
-			pcClient:  pcClient,
-			uid:       uint32(uid),
-			gid:       uint32(gid),
-			dirPerms:  0o750,
-			filePerms: 0o640,
-			dirValid:  2 * time.Second,
-			fileValid: time.Second,
-		},
+		fs:   pfs,
 		conn: conn,
 	}, nil
 }
 
+// Unmount unmounts the drive from its mount point, which makes Mount return.
 func (d *Drive) Unmount() error {
+	return fuse.Unmount(d.fs.mountpoint)
+}
+
+// Close closes the FUSE connection. It should be called once Mount has returned.
+func (d *Drive) Close() error {
 	return d.conn.Close()
 }
 
 func (d *Drive) Mount() error {
-	return fs.Serve(d.conn, d.fs)
+	d.fs.server = fs.New(d.conn, &fs.Config{
+		WithContext: withRequestContext,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go d.fs.watchConnectivity(ctx)
+
+	// nobody waits for the work of the background workers.
+	bgCtx := scheduler.WithPriority(ctx, scheduler.Background)
+	if d.fs.queue != nil {
+		d.fs.queue.run(bgCtx)
+		go d.fs.replayUploads(bgCtx)
+	}
+	if d.fs.pins != nil {
+		go d.fs.pins.run(bgCtx)
+	}
+	return d.fs.server.Serve(d.fs)
+}
+
+// withRequestContext attaches the FUSE request details to the context of each request so
+// that they appear on every log line the request produces.
+func withRequestContext(ctx context.Context, req fuse.Request) context.Context {
+	hdr := req.Hdr()
+	return logger.WithAttrs(ctx, slog.Uint64("fuse.req", uint64(hdr.ID)), slog.Uint64("pid", uint64(hdr.Pid)))
 }
 
 // FS implements the pCloud file system.
 type FS struct {
-	conn      *fuse.Conn
-	pcClient  *sdk.Client // TODO: define an interface
-	uid       uint32
-	gid       uint32
-	dirPerms  os.FileMode
-	filePerms os.FileMode
-	dirValid  time.Duration
-	fileValid time.Duration
+	conn                *fuse.Conn
+	server              *fs.Server
+	pcClient            *pcloud.Client // TODO: define an interface
+	mountpoint          string
+	readWrite           bool
+	startTime           time.Time
+	root                *Dir
+	rootFolderID        uint64           // pCloud folder at the root of the drive
+	trash               *TrashDir        // nil unless the trash is exposed
+	revisions           *RevisionsDir    // nil unless the revisions are exposed
+	metadata            *metastore.Store // nil unless user extended attributes are enabled
+	attrMode            AttrMode
+	names               names
+	filter              *Filter // nil unless entries are filtered
+	filterCreate        bool    // whether hidden entries cannot be created
+	readMode            ReadMode
+	links               *linkCache
+	downloadParallelism int              // chunks fetched concurrently by sequential HTTP reads
+	uploads             *uploads.Journal // nil unless chunked uploads are enabled
+	uploadChunkSize     int64
+	uploadWorkers       int
+	queue               *uploadQueue // nil unless chunked uploads are enabled
+	cache               *cache.Cache // nil unless file content is cached
+	pins                *pinner      // nil unless file content is cached
+	uploadLimit         *bandwidth.Limiter
+	downloadLimit       *bandwidth.Limiter
+	scheduler           *scheduler.Scheduler // nil unless the pCloud requests are scheduled
+	activity            *activity
+	duplicates          duplicates
+	offlineRetry        atomic.Int64 // when an interactive operation last tried pCloud while it was unreachable, in Unix nanoseconds
+	uid                 uint32
+	gid                 uint32
+	dirPerms            os.FileMode
+	filePerms           os.FileMode
+	dirValid            time.Duration
+	fileValid           time.Duration
 }
 
 // ensure interfaces conpliance
@@ -101,11 +198,16 @@ var (
 )
 
 func (fs *FS) Root() (fs.Node, error) {
-	logger.Infof("entering")
+	logger.Debugf("entering")
 
 	rootDir := &Dir{
-		Type: fuse.DT_Dir,
-		fs:   fs,
+		Type:     fuse.DT_Dir,
+		fs:       fs,
+		path:     "/",
+		folderID: fs.rootFolderID,
+	}
+	if fs.revisions != nil {
+		fs.revisions.dir = rootDir
 	}
 
 	err := rootDir.materialiseFolder(context.Background())
@@ -113,6 +215,8 @@ func (fs *FS) Root() (fs.Node, error) {
 		return nil, err
 	}
 
+	fs.root = rootDir
+
 	return rootDir, nil
 }
 
@@ -122,10 +226,15 @@ type Dir struct {
 	Attributes fuse.Attr
 
 	Entries map[string]fs.Node
+	folded  map[string]string // names of Entries by folded name, for case-insensitive look-ups
+	stale   bool              // whether Entries are fetched anew from pCloud before they are looked up
+	lock    sync.RWMutex      // protects Entries, folded and stale
 
 	fs             *FS
+	path           string
 	parentFolderID uint64
 	folderID       uint64
+	xattrs         xattrCache
 }
 
 // ensure interfaces conpliance
@@ -137,18 +246,37 @@ var (
 	_ fs.HandleReadDirAller = (*Dir)(nil)
 )
 
+// logContext returns a copy of ctx that carries the directory details for logging.
+func (d *Dir) logContext(ctx context.Context) context.Context {
+	return logger.WithAttrs(ctx, slog.String("path", d.path))
+}
+
 func (d *Dir) Attr(ctx context.Context, a *fuse.Attr) error {
-	logger.Infof("entering", slog.Uint64("folderID", d.folderID))
+	ctx = d.logContext(ctx)
+	logger.DebugContext(ctx, "entering", slog.Uint64("folderID", d.folderID))
 	*a = d.Attributes
 	return nil
 }
 
 func (d *Dir) materialiseFolder(ctx context.Context) error {
-	logger.Infof("entering", slog.Uint64("folderID", d.folderID))
+	ctx = d.logContext(ctx)
+	logger.DebugContext(ctx, "entering", slog.Uint64("folderID", d.folderID))
+
+	if !d.fs.reachable(ctx) {
+		if d.cached() {
+			logger.DebugContext(ctx, "offline: serving the last-known entries")
+			return nil
+		}
+		return errOffline
+	}
 
 	fsList, err := d.fs.pcClient.ListFolder(ctx, sdk.T1FolderByID(d.folderID), false, false, false, false)
 	if err != nil {
-		logger.Errorf("ListFolder failed", "folderID", d.folderID, "error", err)
+		if !d.fs.online() && d.cached() {
+			logger.WarnContext(ctx, "ListFolder failed: pCloud is unreachable, serving the last-known entries", "folderID", d.folderID, "error", err)
+			return nil
+		}
+		logger.ErrorContext(ctx, "ListFolder failed", "folderID", d.folderID, "error", err)
 		return err
 	}
 
@@ -166,10 +294,19 @@ func (d *Dir) materialiseFolder(ctx context.Context) error {
 	}
 	d.parentFolderID = fsList.Metadata.ParentFolderID
 	d.folderID = fsList.Metadata.FolderID
+	d.fs.applyStoredAttrs(metastore.FolderKey(d.folderID), &d.Attributes)
+
+	names, duplicates := uniqueNames(fsList.Metadata.Contents, presentedName)
+	d.fs.duplicates.report(ctx, "folder:"+strconv.FormatUint(d.folderID, 10), duplicates)
+
+	// the files already known are kept, along with their open handles and pending uploads.
+	previous, created := d.files()
 
 	entries := lo.SliceToMap(fsList.Metadata.Contents, func(item *sdk.Metadata) (string, fs.Node) {
+		name := names[item]
+
 		if item.IsFolder {
-			return item.Name, &Dir{
+			dir := &Dir{
 				Type: fuse.DT_Dir,
 				Attributes: fuse.Attr{
 					Valid: d.fs.dirValid,
@@ -184,12 +321,19 @@ func (d *Dir) materialiseFolder(ctx context.Context) error {
 				},
 				Entries:        nil, // will be populated upon access by Dir.Lookup or Dir.ReadDirAll
 				fs:             d.fs,
+				path:           path.Join(d.path, name),
 				parentFolderID: item.ParentFolderID,
 				folderID:       item.FolderID,
 			}
+			d.fs.applyStoredAttrs(metastore.FolderKey(item.FolderID), &dir.Attributes)
+			return name, dir
 		}
 
-		return item.Name, &File{
+		if _, ok := symlinkName(item.Name); ok {
+			return name, d.newLink(name, item)
+		}
+
+		file := &File{
 			Type: fuse.DT_File,
 			Attributes: fuse.Attr{
 				Valid:     d.fs.fileValid,
@@ -206,56 +350,247 @@ func (d *Dir) materialiseFolder(ctx context.Context) error {
 				BlockSize: 1_048_576,
 			},
 			fs:     d.fs,
+			path:   path.Join(d.path, name),
 			fileID: item.FileID,
+			hash:   item.Hash,
 			file:   nil,
 		}
+		d.fs.applyStoredAttrs(metastore.FileKey(item.FileID), &file.Attributes)
+
+		if prev, ok := previous[item.FileID]; ok {
+			if prev.path == file.path {
+				prev.refresh(file.Attributes, file.hash)
+				return name, prev
+			}
+			// the file was renamed on pCloud.
+			file.adoptUpload(prev)
+		}
+		return name, file
 	})
 
+	entries = lo.OmitBy(entries, func(name string, _ fs.Node) bool {
+		return d.fs.filter.Hidden(path.Join(d.path, name))
+	})
+	entries = d.fs.names.normaliseEntries(ctx, entries)
+	for name, file := range created {
+		if _, ok := entries[name]; !ok && file.hasUpload() {
+			// the file is not on pCloud until its upload is saved.
+			entries[name] = file
+		}
+	}
+	folded := d.fs.names.index(ctx, entries)
+
+	if d.path == "/" {
+		d.addVirtualEntries(ctx, entries)
+	}
+
+	d.lock.Lock()
 	d.Entries = entries
+	d.folded = folded
+	d.stale = false
+	d.lock.Unlock()
 
 	return nil
 }
 
+// addVirtualEntries adds the enabled virtual directories to entries, the content of the
+// root directory.
+func (d *Dir) addVirtualEntries(ctx context.Context, entries map[string]fs.Node) {
+	virtual := map[string]fs.Node{}
+	if d.fs.trash != nil {
+		virtual[TrashDirName] = d.fs.trash
+	}
+	if d.fs.revisions != nil {
+		virtual[RevisionsDirName] = d.fs.revisions
+	}
+
+	for name, node := range virtual {
+		if _, ok := entries[name]; ok {
+			logger.WarnContext(ctx, "a virtual directory hides a pCloud entry of the same name", "name", name)
+		}
+		entries[name] = node
+	}
+}
+
+// isVirtual reports whether node is one of the virtual directories of the root.
+func isVirtual(node fs.Node) bool {
+	switch node.(type) {
+	case *TrashDir, *RevisionsDir:
+		return true
+	default:
+		return false
+	}
+}
+
+// nodeFileID returns the pCloud file ID of node, a file or a link.
+func nodeFileID(node fs.Node) uint64 {
+	if link, ok := node.(*Link); ok {
+		return link.fileID
+	}
+	return node.(*File).id()
+}
+
+// files returns the files among the receiver's entries that are on pCloud, by ID, and
+// those created while pCloud was unreachable, by name.
+func (d *Dir) files() (map[uint64]*File, map[string]*File) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+
+	files, created := map[uint64]*File{}, map[string]*File{}
+	for name, node := range d.Entries {
+		file, ok := node.(*File)
+		switch {
+		case !ok:
+		case file.id() == 0:
+			created[name] = file
+		default:
+			files[file.id()] = file
+		}
+	}
+
+	return files, created
+}
+
+// entry returns the node called name in the receiver's entries, if present.
+// Failing an exact match, name is looked up in its normalised form and, for
+// case-insensitive look-ups, its folded form.
+func (d *Dir) entry(name string) (fs.Node, bool) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+
+	key, ok := d.lockedMatch(name)
+	return d.Entries[key], ok
+}
+
+// lockedMatch returns the key of the receiver's entries that name matches, as entry looks
+// it up. The caller must hold the lock.
+func (d *Dir) lockedMatch(name string) (string, bool) {
+	if _, ok := d.Entries[name]; ok {
+		return name, true
+	}
+	if key := d.fs.names.normalise(name); d.Entries[key] != nil {
+		return key, true
+	}
+	if d.folded != nil {
+		if key, ok := d.folded[d.fs.names.fold(name)]; ok && d.Entries[key] != nil {
+			return key, true
+		}
+	}
+
+	return "", false
+}
+
+// addEntry adds node to the receiver's entries under name, in normalised form, and indexes
+// it for case-insensitive look-ups.
+func (d *Dir) addEntry(name string, node fs.Node) {
+	key := d.fs.names.normalise(name)
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if d.Entries == nil {
+		d.Entries = map[string]fs.Node{}
+	}
+	d.Entries[key] = node
+
+	if d.folded == nil {
+		return
+	}
+	folded := d.fs.names.fold(key)
+	for other := range d.Entries {
+		if other != key && d.fs.names.fold(other) == folded {
+			// as in the index, names that differ only by case are left out.
+			delete(d.folded, folded)
+			return
+		}
+	}
+	d.folded[folded] = key
+}
+
+// removeEntry removes the entry that name matches from the receiver's entries, and from
+// their index.
+func (d *Dir) removeEntry(name string) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	key, ok := d.lockedMatch(name)
+	if !ok {
+		return
+	}
+	delete(d.Entries, key)
+
+	if folded := d.fs.names.fold(key); d.folded[folded] == key {
+		delete(d.folded, folded)
+	}
+}
+
 // Lookup looks up a specific entry in the receiver,
 // which must be a directory.  Lookup should return a Node
 // corresponding to the entry.  If the name does not exist in
 // the directory, Lookup should return ENOENT.
 //
 // Lookup need not to handle the names "." and "..".
-func (d *Dir) Lookup(ctx context.Context, name string) (fs.Node, error) {
-	logger.Infof("entering", slog.Uint64("folderID", d.folderID), slog.Group("args", slog.String("name", name)), slog.Int("entries_count", len(d.Entries)))
+func (d *Dir) Lookup(ctx context.Context, name string) (_ fs.Node, err error) {
+	ctx, end := d.fs.startOp(ctx, "Lookup", d.path)
+	defer end(&err)
+	ctx = d.logContext(ctx)
+	logger.DebugContext(ctx, "entering", slog.Uint64("folderID", d.folderID), slog.Group("args", slog.String("name", name)))
+
+	if d.fs.filter.Hidden(path.Join(d.path, name)) {
+		// spare pCloud the look-ups of hidden entries, which some systems make often. Those
+		// created through the drive are found among the entries, until they are refreshed.
+		if node, ok := d.entry(name); ok {
+			return node, nil
+		}
+		return nil, syscall.ENOENT
+	}
+
+	return d.lookup(ctx, name)
+}
 
-	if node, ok := d.Entries[name]; ok {
-		return node.(fs.Node), nil
+// lookup returns the entry called name, refreshing the receiver's entries from pCloud
+// if name is not found.
+func (d *Dir) lookup(ctx context.Context, name string) (fs.Node, error) {
+	node, ok := d.entry(name)
+	ok = ok && !d.isStale()
+	metrics.CacheLookup("entries", ok)
+	if ok {
+		return node, nil
 	}
 
 	// materialise the folder and try again
 	if err := d.materialiseFolder(ctx); err != nil {
-		logger.Errorf("materialiseFolder failed", "folderID", d.folderID, "name", name, "error", err)
+		logger.ErrorContext(ctx, "materialiseFolder failed", "folderID", d.folderID, "name", name, "error", err)
 		return nil, err
 	}
-	logger.Infof("content refreshed", slog.Uint64("folderID", d.folderID))
+	logger.DebugContext(ctx, "content refreshed", slog.Uint64("folderID", d.folderID))
 
-	if node, ok := d.Entries[name]; ok {
-		return node.(fs.Node), nil
+	if node, ok := d.entry(name); ok {
+		return node, nil
 	}
 
 	return nil, syscall.ENOENT
 }
 
-func (d *Dir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
-	logger.Infof("entering", slog.Uint64("folderID", d.folderID), slog.Uint64("parentFolderID", d.parentFolderID))
+func (d *Dir) ReadDirAll(ctx context.Context) (_ []fuse.Dirent, err error) {
+	ctx, end := d.fs.startOp(ctx, "ReadDirAll", d.path)
+	defer end(&err)
+	ctx = d.logContext(ctx)
+	logger.DebugContext(ctx, "entering", slog.Uint64("folderID", d.folderID), slog.Uint64("parentFolderID", d.parentFolderID))
 
 	if err := d.materialiseFolder(ctx); err != nil {
-		logger.Errorf("materialiseFolder failed", "folderID", d.folderID, "error", err)
+		logger.ErrorContext(ctx, "materialiseFolder failed", "folderID", d.folderID, "error", err)
 		return nil, err
 	}
 
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+
 	dirEntries := lo.MapToSlice(d.Entries, func(key string, value fs.Node) fuse.Dirent {
 		switch castEntry := value.(type) {
 		case *File:
 			return fuse.Dirent{
-				Inode: castEntry.Attributes.Inode,
+				Inode: castEntry.attrs().Inode,
 				Type:  castEntry.Type,
 				Name:  key,
 			}
@@ -267,8 +602,29 @@ func (d *Dir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
 				Name:  key,
 			}
 
+		case *Link:
+			return fuse.Dirent{
+				Inode: castEntry.Attributes.Inode,
+				Type:  fuse.DT_Link,
+				Name:  key,
+			}
+
+		case *TrashDir:
+			return fuse.Dirent{
+				Inode: castEntry.Attributes.Inode,
+				Type:  fuse.DT_Dir,
+				Name:  key,
+			}
+
+		case *RevisionsDir:
+			return fuse.Dirent{
+				Inode: castEntry.Attributes.Inode,
+				Type:  fuse.DT_Dir,
+				Name:  key,
+			}
+
 		default:
-			logger.Infof("unknown directory entry type", slog.Uint64("folderID", d.folderID), slog.String("type", slog.AnyValue(castEntry).Kind().String()))
+			logger.InfoContext(ctx, "unknown directory entry type", slog.Uint64("folderID", d.folderID), slog.String("type", slog.AnyValue(castEntry).Kind().String()))
 			return fuse.Dirent{
 				Inode: 9_505_505_505_505_505_505, // 9 followed by SOS
 				Type:  fuse.DT_Unknown,
@@ -281,21 +637,57 @@ func (d *Dir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
 }
 
 // TODO: should check FileMode (including but not only, ModeDir!)
-func (d *Dir) Create(ctx context.Context, req *fuse.CreateRequest, resp *fuse.CreateResponse) (fs.Node, fs.Handle, error) {
-	logger.Infof("entering", slog.Uint64("folderID", d.folderID), slog.Group("args", "req", req))
+func (d *Dir) Create(ctx context.Context, req *fuse.CreateRequest, resp *fuse.CreateResponse) (_ fs.Node, _ fs.Handle, err error) {
+	ctx, end := d.fs.startOp(ctx, "Create", d.path)
+	defer end(&err)
+	ctx = d.logContext(ctx)
+	logger.DebugContext(ctx, "entering", slog.Uint64("folderID", d.folderID), slog.Group("args", "req", req))
+
+	if _, ok := symlinkName(req.Name); ok {
+		// the file would be taken for a symbolic link.
+		return nil, nil, syscall.EINVAL
+	}
 
-	openFlags := fuseToPcloudFlags(req.Flags)
+	if d.fs.filterCreate && d.fs.filter.Hidden(path.Join(d.path, req.Name)) {
+		return nil, nil, syscall.EPERM
+	}
 
-	pcFile, err := d.fs.pcClient.FileOpen(ctx, openFlags, sdk.T4FileByFolderIDName(d.folderID, req.Name))
-	if err != nil {
-		logger.Errorf("FileOpen failed", "folderID", d.folderID, "req.Name", req.Name, "error", err)
-		return nil, nil, err
+	if node, ok := d.entry(req.Name); ok {
+		// the name is taken, possibly in another normalisation form or case: the existing
+		// file is opened rather than a second one created on pCloud.
+		file, isFile := node.(*File)
+		if !isFile || req.Flags&fuse.OpenExclusive != 0 {
+			return nil, nil, syscall.EEXIST
+		}
+		openReq := &fuse.OpenRequest{Header: req.Header, Flags: req.Flags &^ fuse.OpenCreate}
+		handle, err := file.Open(ctx, openReq, &resp.OpenResponse)
+		if err != nil {
+			return nil, nil, err
+		}
+		return file, handle, nil
 	}
 
-	if err = d.fs.pcClient.FileClose(ctx, pcFile.FD); err != nil {
-		// FileCreate returns an FD so we close it to avoid a leak.
-		// TODO: instead, we could store this in the File structure to re-use it, if that's safe...
-		logger.Warnf("FileClose failed", "FD", pcFile.FD, "FileID", pcFile.FileID, "error", err)
+	name := d.fs.names.normalise(req.Name)
+	// without a connection to pCloud, the file is created once its upload is saved.
+	offline := d.fs.uploads != nil && !d.fs.online()
+
+	var fileID uint64
+	inode := fs.GenerateDynamicInode(d.folderID, name) // until the file is on pCloud
+	if !offline {
+		openFlags := fuseToPcloudFlags(req.Flags)
+
+		pcFile, err := d.fs.pcClient.FileOpen(ctx, openFlags, sdk.T4FileByFolderIDName(d.folderID, req.Name))
+		if err != nil {
+			logger.ErrorContext(ctx, "FileOpen failed", "folderID", d.folderID, "req.Name", req.Name, "error", err)
+			return nil, nil, err
+		}
+
+		if err = d.fs.pcClient.FileClose(ctx, pcFile.FD); err != nil {
+			// FileCreate returns an FD so we close it to avoid a leak.
+			// TODO: instead, we could store this in the File structure to re-use it, if that's safe...
+			logger.WarnContext(ctx, "FileClose failed", "FD", pcFile.FD, "FileID", pcFile.FileID, "error", err)
+		}
+		fileID, inode = pcFile.FileID, pcFile.FileID
 	}
 
 	now := time.Now()
@@ -304,7 +696,7 @@ func (d *Dir) Create(ctx context.Context, req *fuse.CreateRequest, resp *fuse.Cr
 		Type: fuse.DT_File,
 		Attributes: fuse.Attr{
 			Valid:     d.fs.fileValid,
-			Inode:     pcFile.FileID,
+			Inode:     inode,
 			Size:      0,   // file was just created
 			Blocks:    0,   // file was just created
 			Atime:     now, // TODO: we should call pcClient.Stat() to get the file details from pCloud
@@ -316,51 +708,81 @@ func (d *Dir) Create(ctx context.Context, req *fuse.CreateRequest, resp *fuse.Cr
 			Gid:       d.fs.gid,
 			BlockSize: 1_048_576,
 		},
-		fs:     d.fs,
-		fileID: pcFile.FileID,
-		file:   nil,
+		fs:      d.fs,
+		path:    path.Join(d.path, name),
+		fileID:  fileID,
+		file:    nil,
+		handles: 1, // the handle returned
+	}
+
+	if offline {
+		logger.DebugContext(ctx, "offline: the file is created on pCloud once its upload is saved")
+		err = file.createUpload(ctx, d.folderID, req.Name)
+	} else if d.fs.uploads != nil {
+		err = file.startUpload(ctx)
 	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	d.addEntry(req.Name, file)
 
-	d.Entries[req.Name] = file
+	metrics.HandleOpened()
+	d.fs.activity.opened(file.path, req.Flags)
 
 	return file, file, nil
 }
 
-func (d *Dir) Remove(ctx context.Context, req *fuse.RemoveRequest) error {
-	logger.Infof("entering", slog.Uint64("folderID", d.folderID), slog.Group("args", "req", req))
+func (d *Dir) Remove(ctx context.Context, req *fuse.RemoveRequest) (err error) {
+	ctx, end := d.fs.startOp(ctx, "Remove", d.path)
+	defer end(&err)
+	ctx = d.logContext(ctx)
+	logger.DebugContext(ctx, "entering", slog.Uint64("folderID", d.folderID), slog.Group("args", "req", req))
 
-	node, ok := d.Entries[req.Name]
+	node, ok := d.entry(req.Name)
 	if !ok {
 		// materialise the folder and try again
 		if err := d.materialiseFolder(ctx); err != nil {
-			logger.Errorf("materialiseFolder failed", "folderID", d.folderID, "Name", req.Name, "error", err)
+			logger.ErrorContext(ctx, "materialiseFolder failed", "folderID", d.folderID, "Name", req.Name, "error", err)
 			return err
 		}
-		logger.Infof("content refreshed", slog.Uint64("folderID", d.folderID))
+		logger.DebugContext(ctx, "content refreshed", slog.Uint64("folderID", d.folderID))
 
-		if node, ok = d.Entries[req.Name]; !ok {
-			logger.Errorf("Remove failed", "req.ID", req.ID, "error", syscall.ENOENT)
+		if node, ok = d.entry(req.Name); !ok {
+			logger.ErrorContext(ctx, "Remove failed", "error", syscall.ENOENT)
 			return syscall.ENOENT
 		}
 	}
 
+	if isVirtual(node) {
+		// virtual directories cannot be removed.
+		return syscall.EPERM
+	}
+
 	// return node.(fs.Node), nil
 	if req.Dir {
 		if _, err := d.fs.pcClient.DeleteFolder(ctx, sdk.T1FolderByID(node.(*Dir).folderID)); err != nil {
-			logger.Errorf("DeleteFolder failed", "folderID", d.folderID, "Name", req.Name, "error", err)
+			logger.ErrorContext(ctx, "DeleteFolder failed", "folderID", d.folderID, "Name", req.Name, "error", err)
 		}
+	} else if fileID := nodeFileID(node); fileID == 0 {
+		// the file was created without a connection to pCloud, which does not have it yet.
+		node.(*File).abandonUpload(ctx)
 	} else {
-		if _, err := d.fs.pcClient.DeleteFile(ctx, sdk.T3FileByID(node.(*File).fileID)); err != nil {
-			logger.Errorf("DeleteFile failed", "fileID", node.(*File).fileID, "Name", req.Name, "error", err)
+		if _, err := d.fs.pcClient.DeleteFile(ctx, sdk.T3FileByID(fileID)); err != nil {
+			logger.ErrorContext(ctx, "DeleteFile failed", "fileID", fileID, "Name", req.Name, "error", err)
 		}
 	}
 
-	delete(d.Entries, req.Name)
+	d.removeEntry(req.Name)
+
 	return nil
 }
 
-func (d *Dir) Setattr(ctx context.Context, req *fuse.SetattrRequest, resp *fuse.SetattrResponse) error {
-	logger.Infof("entering", "req.ID", req.ID, slog.Group("args", "req", req), "valid", req.Valid.String())
+func (d *Dir) Setattr(ctx context.Context, req *fuse.SetattrRequest, resp *fuse.SetattrResponse) (err error) {
+	ctx, end := d.fs.startOp(ctx, "Setattr", d.path)
+	defer end(&err)
+	ctx = d.logContext(ctx)
+	logger.DebugContext(ctx, "entering", slog.Group("args", "req", req), "valid", req.Valid.String())
 
 	if req.Valid.Atime() {
 		d.Attributes.Atime = req.Atime
@@ -371,24 +793,21 @@ func (d *Dir) Setattr(ctx context.Context, req *fuse.SetattrRequest, resp *fuse.
 	if req.Valid.Size() {
 		d.Attributes.Size = req.Size
 	}
-	if req.Valid.Gid() {
-		d.Attributes.Gid = req.Gid
-	}
-	if req.Valid.Uid() {
-		d.Attributes.Uid = req.Uid
-	}
-	if req.Valid.Mode() {
-		d.Attributes.Mode = req.Mode
+	if err := d.fs.setOwnership(ctx, metastore.FolderKey(d.folderID), &d.Attributes, req); err != nil {
+		return err
 	}
 
 	resp.Attr = d.Attributes
-	logger.Infof("response", "resp", resp)
+	logger.DebugContext(ctx, "response", "resp", resp)
 
 	return nil
 }
 
-func (d *Dir) Getattr(ctx context.Context, req *fuse.GetattrRequest, resp *fuse.GetattrResponse) error {
-	logger.Infof("entering", "req.ID", req.ID, slog.Group("args", "req", req))
+func (d *Dir) Getattr(ctx context.Context, req *fuse.GetattrRequest, resp *fuse.GetattrResponse) (err error) {
+	ctx, end := d.fs.startOp(ctx, "Getattr", d.path)
+	defer end(&err)
+	ctx = d.logContext(ctx)
+	logger.DebugContext(ctx, "entering", slog.Group("args", "req", req))
 	resp.Attr = d.Attributes
 	return nil
 }
@@ -398,8 +817,37 @@ type File struct {
 	Type       fuse.DirentType
 	Attributes fuse.Attr
 	fs         *FS
+	path       string
 	fileID     uint64
+	hash       uint64 // of the content, 0 when it is unknown
 	file       *sdk.File
+	xattrs     xattrCache
+
+	// metaLock protects fileID, hash and Attributes, which the upload queue and the
+	// refreshes of the folder change in the background.
+	metaLock sync.RWMutex
+
+	cached    *os.File      // the content in the content cache, once it is read from there
+	fill      *cache.Writer // the content being added to the content cache, as it is read
+	cacheLock sync.Mutex    // protects cached and fill
+
+	downloads    map[fuse.HandleID]*downloader // of the handles that read the file sequentially over HTTP
+	downloadLock sync.Mutex                    // protects downloads
+
+	upload     *upload    // nil unless the content of the file is replaced by a chunked upload
+	uploadLock sync.Mutex // protects upload
+
+	writeBufs map[fuse.HandleID]*writeBuffer // of the handles that write through the file descriptor
+	writeLock sync.Mutex                     // protects writeBufs
+
+	// fdLock protects file and the fields below: the handles of the file share its descriptor,
+	// which is closed as the last of them is released.
+	fdLock     sync.Mutex
+	writable   bool     // whether file is open for writing
+	stale      []uint64 // the descriptors replaced by a writable one, which reads may still use
+	handles    int      // the open handles of the file
+	positionFD uint64   // the descriptor whose position is known
+	position   int64
 }
 
 // ensure interfaces conpliance
@@ -412,33 +860,117 @@ var (
 	_ = (fs.HandleReader)((*File)(nil))
 	_ = (fs.HandleFlusher)((*File)(nil))
 	_ = (fs.HandleReleaser)((*File)(nil))
+	_ = (fs.NodeFsyncer)((*File)(nil))
 	// _ = (fs.HandleReadAller)((*File)(nil)) // NOTE: it's best avoiding to implement this method to avoid costly memory operations with large files.
 )
 
+// logContext returns a copy of ctx that carries the file details for logging, including
+// the pCloud file descriptor when the file is open.
+func (f *File) logContext(ctx context.Context) context.Context {
+	attrs := []slog.Attr{slog.String("path", f.path)}
+	if fd, ok := f.fd(); ok {
+		attrs = append(attrs, slog.Uint64("fd", fd))
+	}
+	return logger.WithAttrs(ctx, attrs...)
+}
+
 func (f *File) Attr(ctx context.Context, a *fuse.Attr) error {
-	logger.Infof("entering", slog.Uint64("fileID", f.fileID))
-	*a = f.Attributes
+	ctx = f.logContext(ctx)
+	logger.DebugContext(ctx, "entering", slog.Uint64("fileID", f.id()))
+	*a = f.attrs()
 	return nil
 }
 
+// id returns the ID of the file on pCloud, which changes when an upload replaces it.
+func (f *File) id() uint64 {
+	f.metaLock.RLock()
+	defer f.metaLock.RUnlock()
+
+	return f.fileID
+}
+
+// attrs returns a copy of the attributes of the file.
+func (f *File) attrs() fuse.Attr {
+	f.metaLock.RLock()
+	defer f.metaLock.RUnlock()
+
+	return f.Attributes
+}
+
+// refresh updates the file with its attributes and content hash on pCloud, as its folder is
+// listed again. The size and content of a file being written are left as they are written.
+func (f *File) refresh(attrs fuse.Attr, hash uint64) {
+	writing := f.writing()
+
+	f.metaLock.Lock()
+	if writing {
+		attrs.Size, attrs.Blocks = f.Attributes.Size, f.Attributes.Blocks
+		hash = f.hash
+	}
+	changed := hash != f.hash
+	f.Attributes = attrs
+	f.hash = hash
+	f.metaLock.Unlock()
+
+	if changed {
+		// the content was changed elsewhere.
+		f.closeCache()
+		f.closeDownloaders()
+		f.xattrs.resetContent()
+		f.fs.links.drop(f.id())
+	}
+}
+
+// setSize sets the size of the file in its attributes.
+func (f *File) setSize(size uint64) {
+	f.metaLock.Lock()
+	defer f.metaLock.Unlock()
+
+	f.Attributes.Size = size
+}
+
 // TODO: we could return fileHandle from Open and implement all the goodies against it.
 // type fileHandle struct {
 // 	file *sdk.File
 // }
 
-func (f *File) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenResponse) (fs.Handle, error) {
-	logger.Infof("entering", "req.ID", req.ID, slog.Group("args", "req", req, "f.fileID", f.fileID))
-
-	openFlags := fuseToPcloudFlags(req.Flags)
-
-	file, err := f.fs.pcClient.FileOpen(ctx, openFlags, sdk.T4FileByID(f.fileID))
-	if err != nil {
-		logger.Errorf("FileOpen", "req.ID", req.ID, "file", file, "error", err)
-		return nil, err
+func (f *File) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenResponse) (_ fs.Handle, err error) {
+	ctx, end := f.fs.startOp(ctx, "Open", f.path)
+	defer end(&err)
+	ctx = f.logContext(ctx)
+	logger.DebugContext(ctx, "entering", slog.Group("args", "req", req, "f.fileID", f.id()))
+
+	uploading := f.hasUpload()
+	if req.Flags.IsReadOnly() && (f.fs.readMode == ReadModeHTTP || !f.fs.online() || uploading) {
+		// the file is read from the content servers, from the content cache or from the
+		// content of its upload: no file descriptor is needed.
+		logger.DebugContext(ctx, "file opened without file descriptor")
+	} else if f.fs.uploads != nil && !req.Flags.IsReadOnly() && (req.Flags&fuse.OpenTruncate != 0 || uploading) {
+		// the content of the file is replaced: it is uploaded in chunks. So is the content of
+		// a file whose upload is pending, which pCloud does not have yet.
+		if u := f.lockedUpload(); u != nil {
+			err = f.reopenUpload(ctx, u, req.Flags&fuse.OpenTruncate != 0)
+			u.lock.Unlock()
+		} else {
+			err = f.startUpload(ctx)
+		}
+		if err != nil {
+			return nil, err
+		}
+	} else if !f.fs.online() {
+		return nil, errOffline
+	} else {
+		// the descriptor is shared with the other handles of the file, if open already.
+		if _, err := f.openFD(ctx, fuseToPcloudFlags(req.Flags)); err != nil {
+			return nil, err
+		}
+		ctx = f.logContext(ctx)
+		logger.DebugContext(ctx, "file opened")
 	}
-	logger.Infof("file opened", "req.ID", req.ID, "file.FD", file.FD)
+	f.openHandle()
+	metrics.HandleOpened()
+	f.fs.activity.opened(f.path, req.Flags)
 
-	f.file = file
 	resp.Flags |= fuse.OpenKeepCache
 
 	// TODO: is this thread safe? Should we add a lock?
@@ -446,33 +978,97 @@ func (f *File) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenR
 }
 
 // TODO: translate req.LockOwner >> pCloud::sdk.FileLock() (not yet implemented by the SDK)?
-func (f *File) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
-	logger.Infof("entering", "req.ID", req.ID, slog.Group("args", "req", req))
+func (f *File) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) (err error) {
+	ctx, end := f.fs.startOp(ctx, "Read", f.path)
+	defer end(&err)
+	ctx = f.logContext(ctx)
+	logger.DebugContext(ctx, "entering", slog.Group("args", "req", req))
 
 	if req.FileFlags.IsWriteOnly() {
-		logger.Errorf("IsWriteOnly", "req.ID", req.ID)
+		logger.ErrorContext(ctx, "IsWriteOnly")
 		return fuse.Errno(syscall.EACCES)
 	}
 
-	if f.file == nil {
-		logger.Infof("opening file", "req.ID", req.ID)
-		openFlags := fuseToPcloudFlags(req.FileFlags)
-		file, err := f.fs.pcClient.FileOpen(ctx, openFlags, sdk.T4FileByID(f.fileID))
+	if u := f.lockedUpload(); u != nil {
+		// the content being uploaded is read back from where it is staged.
+		defer u.lock.Unlock()
+
+		data := make([]byte, req.Size)
+		n, err := u.staging.ReadAt(data, req.Offset)
+		if err != nil && !errors.Is(err, io.EOF) {
+			logger.ErrorContext(ctx, "staging read failed", "error", err)
+			return err
+		}
+		resp.Data = data[:n]
+
+		return nil
+	}
+
+	if data, ok, err := f.readCache(req.Offset, int64(req.Size)); ok {
 		if err != nil {
-			logger.Errorf("FileOpen failed", "req.ID", req.ID, "error", err)
+			logger.ErrorContext(ctx, "content cache read failed", "error", err)
 			return err
 		}
-		f.file = file
+		resp.Data = data
+		metrics.CacheLookup("content", true)
+
+		return nil
+	}
+	if f.fs.cache != nil {
+		metrics.CacheLookup("content", false)
+	}
+
+	if !f.fs.online() {
+		return errOffline
+	}
+
+	fd, open := f.fd()
+	if !open && f.fs.readMode == ReadModeHTTP && req.FileFlags.IsReadOnly() {
+		fileLink := func(refresh bool) (*sdk.FileLink, error) {
+			return f.fs.links.get(ctx, f.fs.pcClient, f.id(), refresh)
+		}
+
+		var data []byte
+		ok := false
+		if f.fs.downloadParallelism > 1 {
+			data, ok, err = f.downloader(req.Handle).read(ctx, req.Offset, int64(req.Size))
+		}
+		if !ok {
+			data, err = f.fs.readLink(ctx, fileLink, req.Offset, int64(req.Size))
+		}
+		if err != nil {
+			return err
+		}
+		resp.Data = data
+		metrics.AddBytes(metrics.DirectionRead, len(data))
+		f.fillCache(ctx, req.Offset, data)
+
+		return nil
+	}
+
+	if !open {
+		// the descriptor is shared with the other handles of the file: it is opened without
+		// the flags that would change its content, such as O_TRUNC.
+		if fd, err = f.openFD(ctx, fuseToPcloudFlags(req.FileFlags)&sdk.O_WRITE); err != nil {
+			return err
+		}
+		ctx = f.logContext(ctx)
+	}
+
+	// the data written to the file is read back.
+	if err := f.flushWrites(ctx); err != nil {
+		return err
 	}
-	logger.Infof("file handle", "req.ID", req.ID, "file", f.file)
 
 	// TODO: is the offset always relative to the beginning of the file??
-	data, err := f.fs.pcClient.FilePRead(ctx, f.file.FD, uint64(req.Size), uint64(req.Offset))
+	data, err := f.fs.pcClient.FilePRead(ctx, fd, uint64(req.Size), uint64(req.Offset))
 	if err != nil {
-		logger.Errorf("FilePRead failed", "req.ID", req.ID, "error", err)
+		logger.ErrorContext(ctx, "FilePRead failed", "error", err)
 		return err
 	}
 	resp.Data = data
+	metrics.AddBytes(metrics.DirectionRead, len(data))
+	f.fillCache(ctx, req.Offset, data)
 
 	return nil
 }
@@ -485,100 +1081,99 @@ func fuseToPcloudFlags(openFlags fuse.OpenFlags) uint64 {
 	}
 
 	if openFlags&fuse.OpenAppend != 0 {
-		logger.Infof(">>>>>> fuse.OpenAppend")
+		logger.Debugf(">>>>>> fuse.OpenAppend")
 		pcFlags |= sdk.O_APPEND
 	}
 	if openFlags&fuse.OpenCreate != 0 {
-		logger.Infof(">>>>>> fuse.OpenCreate")
+		logger.Debugf(">>>>>> fuse.OpenCreate")
 		pcFlags |= sdk.O_CREAT
 	}
 	if openFlags&fuse.OpenExclusive != 0 {
-		logger.Infof(">>>>>> fuse.OpenExclusive")
+		logger.Debugf(">>>>>> fuse.OpenExclusive")
 		pcFlags |= sdk.O_EXCL
 	}
 	if openFlags&fuse.OpenTruncate != 0 {
-		logger.Infof(">>>>>> fuse.OpenTruncate")
+		logger.Debugf(">>>>>> fuse.OpenTruncate")
 		pcFlags |= sdk.O_TRUNC
 	}
 
-	logger.Infof("fuseToPcloudFlags", "pcFlags", pcFlags, slog.Uint64("openFlags", uint64(openFlags)), "openFlags.String", openFlags.String())
+	logger.Debugf("fuseToPcloudFlags", "pcFlags", pcFlags, slog.Uint64("openFlags", uint64(openFlags)), "openFlags.String", openFlags.String())
 
 	return pcFlags
 }
 
 // TODO: process the req.WriteFlags
 // TODO: translate req.LockOwner >> pCloud::sdk.FileLock() (not yet implemented by the SDK)?
-func (f *File) Write(ctx context.Context, req *fuse.WriteRequest, resp *fuse.WriteResponse) error {
-	logger.Infof("entering", "req.ID", req.ID, slog.Group("args", "req.Header", req.Header, "req.FileFlags", req.FileFlags.String(), "req.Flags", req.Flags.String(), "req.Offset", req.Offset, "req.Pid", req.Pid, "req", req.String()))
+func (f *File) Write(ctx context.Context, req *fuse.WriteRequest, resp *fuse.WriteResponse) (err error) {
+	ctx, end := f.fs.startOp(ctx, "Write", f.path)
+	defer end(&err)
+	ctx = f.logContext(ctx)
+	logger.DebugContext(ctx, "entering", slog.Group("args", "req.Header", req.Header, "req.FileFlags", req.FileFlags.String(), "req.Flags", req.Flags.String(), "req.Offset", req.Offset, "req.Pid", req.Pid, "req", req.String()))
 
 	// TODO: this gets set at unexpected times :\ Need more understanding
 	// TODO: It may have something to do with the flags passed to File.Open
 	// if req.FileFlags.IsReadOnly() {
-	// 	logger.Errorf("write precluded: ReadOnly", "req.ID", req.ID, "error", syscall.EACCES)
+	// 	logger.ErrorContext(ctx, "write precluded: ReadOnly", "error", syscall.EACCES)
 	// 	return fuse.Errno(syscall.EACCES)
 	// }
 
-	openFlags := fuseToPcloudFlags(req.FileFlags)
-	logger.Infof("fuseToPcloudFlags", "req.ID", req.ID, "openFlags", openFlags)
+	if u := f.lockedUpload(); u != nil {
+		defer u.lock.Unlock()
 
-	if f.file == nil {
-		logger.Infof("opening file for writing", "req.ID", req.ID)
-		file, err := f.fs.pcClient.FileOpen(ctx, sdk.O_WRITE|openFlags, sdk.T4FileByID(f.fileID))
-		if err != nil {
-			logger.Errorf("FileOpen failed", "req.ID", req.ID, "error", err)
+		if err = f.writeUpload(ctx, u, req.Offset, req.Data); err != nil {
 			return err
 		}
-		f.file = file
-	}
-	logger.Infof("file handle", "req.ID", req.ID, "file", f.file)
+		f.setSize(uint64(u.size))
+		resp.Size = len(req.Data)
 
-	if req.Offset != 0 {
-		// TODO: is the offset always relative to the beginning of the file??
-		_, err := f.fs.pcClient.FileSeek(ctx, f.file.FD, uint64(req.Offset), 0)
-		if err != nil {
-			logger.Errorf("FileSeek failed", "req.ID", req.ID, "error", err)
-			return err
-		}
+		return nil
 	}
 
-	fdt, err := f.fs.pcClient.FileWrite(ctx, f.file.FD, req.Data)
-	if err != nil {
-		logger.Errorf("FileWrite failed", "req.ID", req.ID, "error", err)
+	// the buffered data is written through the descriptor of the file, which is opened then.
+	w := f.writes(req.Handle)
+	if err := w.add(ctx, req.Offset, req.Data); err != nil {
 		return err
 	}
+	f.xattrs.resetContent()
+	f.fs.links.drop(f.id())
+	f.closeDownloaders()
+	f.contentChanged(0)
 
-	if req.Offset == 0 {
-		f.Attributes.Size = fdt.Bytes
-		logger.Infof("file size set", "size", f.Attributes.Size)
-	} else {
-		// the safest size evaluation is to ask pCloud because the Seek point could be
-		// in the middle of the file and the bytes written may or may not be in excess of
-		// the file end
-		// TODO/NOTE: pCloud's SDK also has a FileSize() operation.
-		fr, err := f.fs.pcClient.Stat(ctx, sdk.T3FileByID(f.fileID))
-		if err != nil {
-			logger.Errorf("Stat failed", "req.ID", req.ID, "error", err)
-			// NOTE: this is tricky - the file was successfully written to, but we don't know
-			// its size anymore. Without an error, we may report the wrong file size, with an
-			// error we may cause the caller to retry and not achieve the correct outcome...
-			logger.Warnf("file handle", "req.ID", req.ID, "file", f.file)
-			if err = f.fs.conn.InvalidateNode(req.Node, 0, 0); err != nil {
-				// Well, we tried our best...
-				logger.Errorf("InvalidateNode failed - abandoning all efforts", "req.ID", req.ID, "file", f.file)
-			}
-		} else {
-			f.Attributes.Size = fr.Metadata.Size
-			logger.Infof("file size cloud refreshed", "size", f.Attributes.Size)
-		}
-	}
-
-	resp.Size = int(f.Attributes.Size)
+	f.growSize(uint64(w.size()))
+	resp.Size = len(req.Data)
 
 	return nil
 }
 
-func (f *File) Setattr(ctx context.Context, req *fuse.SetattrRequest, resp *fuse.SetattrResponse) error {
-	logger.Infof("entering", "req.ID", req.ID, slog.Group("args", "req", req), "valid", req.Valid.String())
+func (f *File) Setattr(ctx context.Context, req *fuse.SetattrRequest, resp *fuse.SetattrResponse) (err error) {
+	ctx, end := f.fs.startOp(ctx, "Setattr", f.path)
+	defer end(&err)
+	ctx = f.logContext(ctx)
+	logger.DebugContext(ctx, "entering", slog.Group("args", "req", req), "valid", req.Valid.String())
+
+	if req.Valid.Size() {
+		// the buffered data may lie beyond the new size.
+		if err := f.truncateWrites(ctx, int64(req.Size)); err != nil {
+			return err
+		}
+		if u := f.lockedUpload(); u != nil {
+			err := f.truncateUpload(ctx, u, int64(req.Size))
+			u.lock.Unlock()
+			if err != nil {
+				return err
+			}
+		} else if err := f.truncateFD(ctx, int64(req.Size)); err != nil {
+			return err
+		}
+		if req.Size != f.attrs().Size {
+			f.contentChanged(0)
+		}
+		f.setSize(req.Size)
+		f.xattrs.resetContent()
+	}
+
+	f.metaLock.Lock()
+	defer f.metaLock.Unlock()
 
 	if req.Valid.Atime() {
 		f.Attributes.Atime = req.Atime
@@ -586,28 +1181,22 @@ func (f *File) Setattr(ctx context.Context, req *fuse.SetattrRequest, resp *fuse
 	if req.Valid.Mtime() {
 		f.Attributes.Mtime = req.Mtime
 	}
-	if req.Valid.Size() {
-		f.Attributes.Size = req.Size
-	}
-	if req.Valid.Gid() {
-		f.Attributes.Gid = req.Gid
-	}
-	if req.Valid.Uid() {
-		f.Attributes.Uid = req.Uid
-	}
-	if req.Valid.Mode() {
-		f.Attributes.Mode = req.Mode
+	if err := f.fs.setOwnership(ctx, metastore.FileKey(f.fileID), &f.Attributes, req); err != nil {
+		return err
 	}
 
 	resp.Attr = f.Attributes
-	logger.Infof("response", "resp", resp)
+	logger.DebugContext(ctx, "response", "resp", resp)
 
 	return nil
 }
 
-func (f *File) Getattr(ctx context.Context, req *fuse.GetattrRequest, resp *fuse.GetattrResponse) error {
-	logger.Infof("entering", "req.ID", req.ID, slog.Group("args", "req", req))
-	resp.Attr = f.Attributes
+func (f *File) Getattr(ctx context.Context, req *fuse.GetattrRequest, resp *fuse.GetattrResponse) (err error) {
+	ctx, end := f.fs.startOp(ctx, "Getattr", f.path)
+	defer end(&err)
+	ctx = f.logContext(ctx)
+	logger.DebugContext(ctx, "entering", slog.Group("args", "req", req))
+	resp.Attr = f.attrs()
 	return nil
 }
 
@@ -615,44 +1204,63 @@ func (f *File) Getattr(ctx context.Context, req *fuse.GetattrRequest, resp *fuse
 // Because there can be multiple file descriptors referring to a
 // single opened file, Flush can be called multiple times.
 // TODO: consider req.LockOwner??
-func (f *File) Flush(ctx context.Context, req *fuse.FlushRequest) error {
-	log.Printf("File.Flush - (%s) - called with req: %+#v", req.ID, req)
-	if f.file == nil {
-		log.Printf("File.Flush - (%s) - FD: nil", req.ID)
-	}
-
-	if f.file != nil {
-		log.Printf("File.Flush - (%s) - FD: %d", req.ID, f.file.FD)
-		err := f.fs.pcClient.FileClose(ctx, f.file.FD)
+func (f *File) Flush(ctx context.Context, req *fuse.FlushRequest) (err error) {
+	ctx, end := f.fs.startOp(ctx, "Flush", f.path)
+	defer end(&err)
+	ctx = f.logContext(ctx)
+	logger.DebugContext(ctx, "entering", slog.Group("args", "req", req))
+
+	if u := f.lockedUpload(); u != nil {
+		// the content is complete once the file is closed.
+		err := f.closeUpload(ctx, u)
+		u.lock.Unlock()
 		if err != nil {
-			logger.Errorf("FileClose failed", "req.ID", req.ID, "error", err)
+			return err
 		}
-		f.file = nil
-		return err
 	}
 
-	return nil
+	// the file descriptor, which the other handles share, stays open until the last of them
+	// is released.
+	return f.closeWrites(ctx, req.Handle, false)
+}
+
+// Fsync writes the buffered data of the file to pCloud.
+func (f *File) Fsync(ctx context.Context, req *fuse.FsyncRequest) (err error) {
+	ctx, end := f.fs.startOp(ctx, "Fsync", f.path)
+	defer end(&err)
+	ctx = f.logContext(ctx)
+	logger.DebugContext(ctx, "entering", slog.Group("args", "req", req))
+
+	return f.flushWrites(ctx)
 }
 
 // A ReleaseRequest asks to release (close) an open file handle.
 // TODO: consider req.LockOwner??
 // TODO: consider req.ReleaseFlags??
 // TODO: consider req.OpenFlags??
-func (f *File) Release(ctx context.Context, req *fuse.ReleaseRequest) error {
-	log.Printf("File.Release - (%s) - called with req: %+#v", req.ID, req)
-	if f.file == nil {
-		log.Printf("File.Release - (%s) - FD: nil", req.ID)
-	}
-
-	if f.file != nil {
-		log.Printf("File.Release - (%s) - FD: %d", req.ID, f.file.FD)
-		err := f.fs.pcClient.FileClose(ctx, f.file.FD)
+func (f *File) Release(ctx context.Context, req *fuse.ReleaseRequest) (err error) {
+	ctx, end := f.fs.startOp(ctx, "Release", f.path)
+	defer end(&err)
+	defer metrics.HandleReleased()
+	defer f.fs.activity.released(f.path, req.Flags)
+	ctx = f.logContext(ctx)
+	logger.DebugContext(ctx, "entering", slog.Group("args", "req", req))
+	f.closeCache()
+	f.closeDownloader(req.Handle)
+
+	if u := f.lockedUpload(); u != nil {
+		err := f.closeUpload(ctx, u)
+		u.lock.Unlock()
 		if err != nil {
-			logger.Errorf("FileClose failed", "req.ID", req.ID, "error", err)
+			return err
 		}
-		f.file = nil
+	}
+
+	// the buffered data is written before the file descriptor is closed.
+	writeErr := f.closeWrites(ctx, req.Handle, true)
+	if err := f.releaseFD(ctx); writeErr == nil {
 		return err
 	}
 
-	return nil
+	return writeErr
 }
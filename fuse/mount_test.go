@@ -9,6 +9,7 @@ import (
 	"time"
 
 	pfuse "github.com/seborama/pcloud-drive/v1/fuse"
+	"github.com/seborama/pcloud-drive/v1/pcloud"
 	"github.com/seborama/pcloud-sdk/sdk"
 	"github.com/stretchr/testify/require"
 )
@@ -35,7 +36,7 @@ func Test(t *testing.T) {
 	}
 }
 
-func newPCloudClient(t *testing.T) *sdk.Client {
+func newPCloudClient(t *testing.T) *pcloud.Client {
 	t.Helper()
 
 	username := os.Getenv("GO_PCLOUD_USERNAME")
@@ -59,7 +60,7 @@ func newPCloudClient(t *testing.T) *sdk.Client {
 		Timeout: 0,
 	}
 
-	pcc := sdk.NewClient(c)
+	pcc := pcloud.NewClient(c)
 
 	err := pcc.Login(
 		context.Background(),
@@ -0,0 +1,121 @@
+package fuse
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"bazil.org/fuse/fs"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+
+	"github.com/seborama/pcloud-drive/v1/logger"
+)
+
+// NameForm is the Unicode normalisation form in which the names of the entries of the drive
+// are presented.
+type NameForm string
+
+const (
+	// NameFormNone presents the names as pCloud stores them.
+	NameFormNone NameForm = "none"
+	// NameFormNFC presents the names in composed form, as Linux and Windows mostly write them.
+	NameFormNFC NameForm = "nfc"
+	// NameFormNFD presents the names in decomposed form, as macOS writes them.
+	NameFormNFD NameForm = "nfd"
+)
+
+// ParseNameForm returns the NameForm called s.
+func ParseNameForm(s string) (NameForm, error) {
+	switch form := NameForm(s); form {
+	case NameFormNone, NameFormNFC, NameFormNFD:
+		return form, nil
+	default:
+		return "", fmt.Errorf("unknown name normalisation form '%s': expected none, nfc or nfd", s)
+	}
+}
+
+// names sets how the names of the entries of the drive are presented and looked up.
+type names struct {
+	form            NameForm
+	caseInsensitive bool
+}
+
+// normalise returns name in the normalisation form of the drive.
+func (n *names) normalise(name string) string {
+	switch n.form {
+	case NameFormNFC:
+		return norm.NFC.String(name)
+	case NameFormNFD:
+		return norm.NFD.String(name)
+	default:
+		return name
+	}
+}
+
+// fold returns the key under which name is looked up when an exact match fails.
+func (n *names) fold(name string) string {
+	if n.caseInsensitive {
+		// a Caser cannot be shared between goroutines.
+		name = cases.Fold().String(norm.NFC.String(name))
+	}
+	return name
+}
+
+// normaliseEntries returns entries keyed by their normalised name.
+// When two names normalise alike, the one already in normal form wins and the other keeps
+// its name as pCloud stores it.
+func (n *names) normaliseEntries(ctx context.Context, entries map[string]fs.Node) map[string]fs.Node {
+	if n.form == NameFormNone {
+		return entries
+	}
+
+	normalised := make(map[string]fs.Node, len(entries))
+	var renamed []string
+
+	for name, node := range entries {
+		if n.normalise(name) == name {
+			normalised[name] = node
+		} else {
+			renamed = append(renamed, name)
+		}
+	}
+
+	sort.Strings(renamed) // deterministic outcome of the collisions between renamed entries
+	for _, name := range renamed {
+		key := n.normalise(name)
+		if _, ok := normalised[key]; ok {
+			logger.WarnContext(ctx, "name collision after Unicode normalisation: entry left unnormalised", "name", name, "form", n.form)
+			key = name
+		}
+		normalised[key] = entries[name]
+	}
+
+	return normalised
+}
+
+// index returns the names of entries keyed by their folded form, for case-insensitive
+// look-ups. Folded forms that several names share are left out.
+func (n *names) index(ctx context.Context, entries map[string]fs.Node) map[string]string {
+	if !n.caseInsensitive {
+		return nil
+	}
+
+	idx := make(map[string]string, len(entries))
+	ambiguous := map[string]bool{}
+
+	for name := range entries {
+		key := n.fold(name)
+		if other, ok := idx[key]; ok || ambiguous[key] {
+			if ok {
+				logger.WarnContext(ctx, "names differ only by case: case-insensitive look-up disabled for them", "name", name, "other", other)
+			}
+			delete(idx, key)
+			ambiguous[key] = true
+			continue
+		}
+		idx[key] = name
+	}
+
+	return idx
+}
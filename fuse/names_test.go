@@ -0,0 +1,132 @@
+package fuse
+
+import (
+	"context"
+	"net/http"
+	"syscall"
+	"testing"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNames(t *testing.T) {
+	const (
+		nfc = "café.txt"  // é composed
+		nfd = "café.txt" // e followed by a combining acute accent
+	)
+
+	ctx := context.Background()
+	file1, file2, file3 := &File{path: "1"}, &File{path: "2"}, &File{path: "3"}
+
+	n := &names{form: NameFormNFC}
+
+	entries := n.normaliseEntries(ctx, map[string]fs.Node{nfd: file1, "Readme": file2})
+	require.Equal(t, map[string]fs.Node{nfc: file1, "Readme": file2}, entries)
+
+	// the name already in normal form wins the collision.
+	entries = n.normaliseEntries(ctx, map[string]fs.Node{nfd: file1, nfc: file2})
+	require.Equal(t, map[string]fs.Node{nfd: file1, nfc: file2}, entries)
+
+	n = &names{form: NameFormNone, caseInsensitive: true}
+
+	idx := n.index(ctx, map[string]fs.Node{nfd: file1, "Readme": file2})
+	require.Equal(t, nfd, idx[n.fold("CAFÉ.TXT")])
+	require.Equal(t, "Readme", idx[n.fold("README")])
+
+	// names that only differ by case are ambiguous.
+	idx = n.index(ctx, map[string]fs.Node{"Readme": file1, "README": file2, "readme": file3})
+	require.Empty(t, idx)
+}
+
+func TestDir_Entries(t *testing.T) {
+	const (
+		nfc = "caf\u00e9.txt"  // é composed
+		nfd = "cafe\u0301.txt" // e followed by a combining acute accent
+	)
+
+	ctx := context.Background()
+	file1, file2, file3 := &File{path: "1"}, &File{path: "2"}, &File{path: "3"}
+
+	pfs := &FS{names: names{form: NameFormNFC, caseInsensitive: true}}
+	d := &Dir{fs: pfs}
+	d.Entries = pfs.names.normaliseEntries(ctx, map[string]fs.Node{nfd: file1, "Readme": file2})
+	d.folded = pfs.names.index(ctx, d.Entries)
+
+	// the entry removed is the one matched, whichever form and case the name is in.
+	d.removeEntry("README")
+	d.removeEntry(nfd)
+	require.Empty(t, d.Entries)
+	require.Empty(t, d.folded)
+
+	// the entries added are normalised and indexed.
+	d.addEntry(nfd, file1)
+	require.Equal(t, map[string]fs.Node{nfc: file1}, d.Entries)
+	node, ok := d.entry("CAFÉ.TXT")
+	require.True(t, ok)
+	require.Same(t, file1, node)
+
+	// names that only differ by case are ambiguous.
+	d.addEntry("Readme", file2)
+	d.addEntry("README", file3)
+	_, ok = d.entry("readme")
+	require.False(t, ok)
+	node, ok = d.entry("README")
+	require.True(t, ok)
+	require.Same(t, file3, node)
+}
+
+func TestDir_CreateExisting(t *testing.T) {
+	pcClient := newFakePCloud(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/file_open":
+			// the existing file is opened, rather than a second one created by name.
+			require.Equal(t, "11", r.URL.Query().Get("fileid"))
+			require.Empty(t, r.URL.Query().Get("name"))
+			_, _ = w.Write([]byte(`{"result": 0, "fd": 1, "fileid": 11}`))
+
+		default:
+			t.Errorf("unexpected method %s", r.URL.Path)
+		}
+	})
+
+	const (
+		nfc = "caf\u00e9.txt"
+		nfd = "cafe\u0301.txt"
+	)
+
+	pfs := &FS{pcClient: pcClient, names: names{form: NameFormNFD}, activity: newActivity()}
+	d := &Dir{fs: pfs, path: "/", folderID: 5}
+	existing := &File{fs: pfs, path: "/" + nfd, fileID: 11}
+	d.addEntry(nfd, existing)
+
+	ctx := context.Background()
+	node, handle, err := d.Create(ctx, &fuse.CreateRequest{Name: nfc, Flags: fuse.OpenReadWrite | fuse.OpenCreate}, &fuse.CreateResponse{})
+	require.NoError(t, err)
+	require.Same(t, existing, node)
+	require.Same(t, existing, handle)
+
+	_, _, err = d.Create(ctx, &fuse.CreateRequest{Name: nfc, Flags: fuse.OpenReadWrite | fuse.OpenCreate | fuse.OpenExclusive}, &fuse.CreateResponse{})
+	require.ErrorIs(t, err, syscall.EEXIST)
+}
+
+func TestDir_LookupHidden(t *testing.T) {
+	filter, err := NewFilter(nil, []string{"*.tmp"})
+	require.NoError(t, err)
+
+	// pCloud is not asked for hidden entries: a look-up would fail without a client.
+	pfs := &FS{filter: filter, activity: newActivity()}
+	d := &Dir{fs: pfs, path: "/", folderID: 5}
+
+	ctx := context.Background()
+	_, err = d.Lookup(ctx, "draft.tmp")
+	require.ErrorIs(t, err, syscall.ENOENT)
+
+	// a hidden file created through the drive is found.
+	created := &File{fs: pfs, path: "/draft.tmp", fileID: 11}
+	d.addEntry("draft.tmp", created)
+	node, err := d.Lookup(ctx, "draft.tmp")
+	require.NoError(t, err)
+	require.Same(t, created, node)
+}
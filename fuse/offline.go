@@ -0,0 +1,228 @@
+package fuse
+
+import (
+	"context"
+	"errors"
+	"io"
+	"syscall"
+	"time"
+
+	"bazil.org/fuse"
+
+	"github.com/seborama/pcloud-drive/v1/logger"
+	"github.com/seborama/pcloud-drive/v1/scheduler"
+)
+
+// XattrState is the extended attribute of the root of the drive that tells whether pCloud
+// is reachable: "online" or "offline".
+const XattrState = "user.pcloud.state"
+
+// offlineProbeInterval is how often pCloud is probed while it is unreachable.
+const offlineProbeInterval = 15 * time.Second
+
+// offlineRetryInterval is how often the operations that users wait for try pCloud while it is
+// unreachable, ahead of the probes.
+const offlineRetryInterval = 2 * time.Second
+
+// errOffline is returned by the operations that need pCloud while it is unreachable.
+var errOffline = fuse.Errno(syscall.ENETDOWN)
+
+// online reports whether pCloud is reachable.
+func (fs *FS) online() bool {
+	return fs.pcClient.Online()
+}
+
+// reachable reports whether the operation of ctx should try pCloud. While pCloud is
+// unreachable, an interactive operation still tries it every offlineRetryInterval, so that
+// the drive is back online as soon as the user browses it again.
+func (fs *FS) reachable(ctx context.Context) bool {
+	if fs.online() {
+		return true
+	}
+	if scheduler.PriorityFrom(ctx) != scheduler.Interactive {
+		return false
+	}
+
+	now := time.Now().UnixNano()
+	last := fs.offlineRetry.Load()
+	if now-last < int64(offlineRetryInterval) {
+		return false
+	}
+	return fs.offlineRetry.CompareAndSwap(last, now)
+}
+
+// state returns the value of XattrState.
+func (fs *FS) state() []byte {
+	if fs.online() {
+		return []byte("online")
+	}
+	return []byte("offline")
+}
+
+// watchConnectivity probes pCloud while it is unreachable, until ctx is done, so that the
+// drive resumes its online operation once pCloud is reachable again.
+func (fs *FS) watchConnectivity(ctx context.Context) {
+	ticker := time.NewTicker(offlineProbeInterval)
+	defer ticker.Stop()
+
+	wasOnline := true
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if fs.online() {
+			continue
+		}
+
+		if wasOnline {
+			logger.Warnf("pCloud is unreachable: serving the last-known entries and the cached content", "since", fs.pcClient.OfflineSince())
+			wasOnline = false
+		}
+
+		probeCtx, cancel := context.WithTimeout(ctx, offlineProbeInterval)
+		err := fs.pcClient.Probe(probeCtx)
+		cancel()
+		if err != nil || !fs.online() {
+			logger.Debugf("pCloud is still unreachable", "error", err)
+			continue
+		}
+
+		wasOnline = true
+		logger.Infof("pCloud is reachable again: back online")
+		if fs.queue != nil {
+			fs.queue.retryNow()
+		}
+	}
+}
+
+// cached reports whether the entries of the directory were fetched at least once.
+func (d *Dir) cached() bool {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+
+	return d.Entries != nil
+}
+
+// isStale reports whether the entries of the directory are to be fetched anew from pCloud,
+// since the cache was flushed.
+func (d *Dir) isStale() bool {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+
+	return d.stale
+}
+
+// readCache reads size bytes at offset from the content of the file in the content cache.
+// It reports false when the cache does not hold the content.
+func (f *File) readCache(offset, size int64) ([]byte, bool, error) {
+	fileID, hash := f.content()
+	if f.fs.cache == nil || hash == 0 {
+		return nil, false, nil
+	}
+
+	f.cacheLock.Lock()
+	defer f.cacheLock.Unlock()
+
+	if f.cached == nil {
+		cached, ok := f.fs.cache.Open(fileID, hash)
+		if !ok {
+			return nil, false, nil
+		}
+		f.cached = cached
+	}
+
+	data := make([]byte, size)
+	n, err := f.cached.ReadAt(data, offset)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, true, err
+	}
+
+	return data[:n], true, nil
+}
+
+// fillCache adds data, read from pCloud at offset, to the content of the file being written
+// to the content cache. Only the files read from start to end in order are cached.
+func (f *File) fillCache(ctx context.Context, offset int64, data []byte) {
+	fileID, hash := f.content()
+	if f.fs.cache == nil || hash == 0 {
+		return
+	}
+
+	f.cacheLock.Lock()
+	defer f.cacheLock.Unlock()
+
+	if f.fill == nil {
+		if offset != 0 {
+			return
+		}
+		fill, err := f.fs.cache.Create(fileID, hash)
+		if err != nil {
+			logger.WarnContext(ctx, "content cache Create failed", "error", err)
+			return
+		}
+		f.fill = fill
+	}
+
+	if offset != f.fill.Size() {
+		// not read in order.
+		f.fill.Abort()
+		f.fill = nil
+		return
+	}
+
+	if _, err := f.fill.Write(data); err != nil {
+		logger.WarnContext(ctx, "content cache write failed", "error", err)
+		f.fill.Abort()
+		f.fill = nil
+		return
+	}
+
+	if f.fill.Size() >= int64(f.attrs().Size) {
+		if err := f.fill.Commit(); err != nil {
+			logger.WarnContext(ctx, "content cache Commit failed", "error", err)
+		}
+		f.fill = nil
+	}
+}
+
+// closeCache closes the cached content of the file, and abandons filling it.
+func (f *File) closeCache() {
+	f.cacheLock.Lock()
+	defer f.cacheLock.Unlock()
+
+	if f.cached != nil {
+		_ = f.cached.Close()
+		f.cached = nil
+	}
+	if f.fill != nil {
+		f.fill.Abort()
+		f.fill = nil
+	}
+}
+
+// contentChanged forgets the cached content of the file, whose content changed to that of
+// hash, or to an unknown content if hash is 0.
+func (f *File) contentChanged(hash uint64) {
+	f.closeCache()
+
+	f.metaLock.Lock()
+	f.hash = hash
+	fileID := f.fileID
+	f.metaLock.Unlock()
+
+	if f.fs.cache != nil {
+		f.fs.cache.Remove(fileID)
+	}
+}
+
+// content returns the ID of the file and the hash of its content, 0 when it is unknown.
+func (f *File) content() (fileID, hash uint64) {
+	f.metaLock.RLock()
+	defer f.metaLock.RUnlock()
+
+	return f.fileID, f.hash
+}
@@ -0,0 +1,70 @@
+package fuse
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"bazil.org/fuse/fs"
+	"github.com/stretchr/testify/require"
+
+	"github.com/seborama/pcloud-drive/v1/scheduler"
+)
+
+func TestFS_Reachable(t *testing.T) {
+	var lock sync.Mutex
+	down := true
+	pcClient := newFakePCloud(t, func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		if down {
+			// no response reaches the drive.
+			conn, _, err := w.(http.Hijacker).Hijack()
+			require.NoError(t, err)
+			_ = conn.Close()
+			return
+		}
+
+		switch r.URL.Path {
+		case "/listfolder":
+			_, _ = w.Write([]byte(`{"result": 0, "metadata": {"folderid": 5, "isfolder": true, "name": "dir", "modified": "Sat, 24 Jul 2021 10:00:00 +0000", "contents": []}}`))
+
+		default:
+			t.Errorf("unexpected method %s", r.URL.Path)
+		}
+	})
+
+	pfs := &FS{pcClient: pcClient, activity: newActivity()}
+	dir := &Dir{fs: pfs, path: "/dir", folderID: 5, Entries: map[string]fs.Node{}}
+
+	ctx := context.Background()
+	interactive := scheduler.WithPriority(ctx, scheduler.Interactive)
+	require.True(t, pfs.reachable(ctx))
+	require.Eventually(t, func() bool {
+		_ = pcClient.Probe(ctx)
+		return !pcClient.Online()
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// while pCloud is unreachable, only the interactive operations try it, now and then.
+	require.False(t, pfs.reachable(ctx))
+	require.True(t, pfs.reachable(interactive))
+	require.False(t, pfs.reachable(interactive))
+
+	// the folder is served from its last-known entries when the attempt fails.
+	pfs.offlineRetry.Store(0)
+	require.NoError(t, dir.materialiseFolder(interactive))
+	require.False(t, pcClient.Online())
+
+	// an attempt that succeeds brings the drive back online.
+	lock.Lock()
+	down = false
+	lock.Unlock()
+	require.NoError(t, dir.materialiseFolder(ctx))
+	require.False(t, pcClient.Online(), "not tried by the other operations")
+	pfs.offlineRetry.Store(0)
+	require.NoError(t, dir.materialiseFolder(interactive))
+	require.True(t, pcClient.Online())
+}
@@ -0,0 +1,47 @@
+package fuse
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/seborama/pcloud-drive/v1/logger"
+	"github.com/seborama/pcloud-drive/v1/metrics"
+	"github.com/seborama/pcloud-drive/v1/scheduler"
+	"github.com/seborama/pcloud-drive/v1/tracing"
+)
+
+// interactiveOps are the FUSE operations that users wait for when browsing the drive: their
+// pCloud requests go ahead of the others.
+var interactiveOps = map[string]bool{
+	"Lookup":     true,
+	"Getattr":    true,
+	"ReadDirAll": true,
+}
+
+// startOp marks the beginning of the FUSE operation op on the node at path.
+// It starts the operation's trace span and returns the context to use for the duration of
+// the operation, along with a function that must be deferred with the operation's named
+// error return value to record its outcome.
+func (fs *FS) startOp(ctx context.Context, op, path string) (context.Context, func(*error)) {
+	start := time.Now()
+
+	if interactiveOps[op] {
+		ctx = scheduler.WithPriority(ctx, scheduler.Interactive)
+	}
+
+	ctx, span := tracing.Start(ctx, "fuse."+op, attribute.String("fuse.path", path))
+	if sc := span.SpanContext(); sc.IsSampled() {
+		ctx = logger.WithAttrs(ctx, slog.String("trace_id", sc.TraceID().String()))
+	}
+
+	return ctx, func(errp *error) {
+		metrics.ObserveFuseOp(op, start, errp)
+		tracing.End(span, errp)
+		if errp != nil && *errp != nil {
+			fs.activity.failed(op, path, *errp)
+		}
+	}
+}
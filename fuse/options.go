@@ -0,0 +1,156 @@
+package fuse
+
+import (
+	"bazil.org/fuse"
+
+	"github.com/seborama/pcloud-drive/v1/bandwidth"
+	"github.com/seborama/pcloud-drive/v1/cache"
+	"github.com/seborama/pcloud-drive/v1/metastore"
+	"github.com/seborama/pcloud-drive/v1/pcloud"
+	"github.com/seborama/pcloud-drive/v1/scheduler"
+	"github.com/seborama/pcloud-drive/v1/uploads"
+)
+
+// Option configures an optional feature of the drive.
+type Option func(*FS)
+
+// WithTrash exposes pCloud's trash as a virtual directory named TrashDirName at the root of
+// the drive.
+func WithTrash() Option {
+	return func(fs *FS) {
+		fs.trash = &TrashDir{
+			Attributes: fuse.Attr{
+				Inode: trashInode,
+				Mtime: fs.startTime,
+				Ctime: fs.startTime,
+				Atime: fs.startTime,
+			},
+			fs:       fs,
+			path:     "/" + TrashDirName,
+			folderID: pcloud.TrashRootFolderID,
+		}
+	}
+}
+
+// WithRevisions exposes the past revisions of the files of the drive in a virtual directory
+// named RevisionsDirName at the root of the drive.
+// It mirrors the tree of the drive: each file is a directory that holds its revisions.
+func WithRevisions() Option {
+	return func(fs *FS) {
+		fs.revisions = &RevisionsDir{
+			Attributes: fuse.Attr{
+				Inode: revisionsInode,
+				Mtime: fs.startTime,
+				Ctime: fs.startTime,
+				Atime: fs.startTime,
+			},
+			fs:   fs,
+			path: "/" + RevisionsDirName,
+		}
+	}
+}
+
+// WithMetadataStore keeps the user extended attributes of files and folders in store.
+// Without it, user extended attributes cannot be set.
+func WithMetadataStore(store *metastore.Store) Option {
+	return func(fs *FS) {
+		fs.metadata = store
+	}
+}
+
+// WithAttrMode sets what becomes of the changes of mode and ownership of files and folders.
+// AttrModePersist requires WithMetadataStore.
+func WithAttrMode(mode AttrMode) Option {
+	return func(fs *FS) {
+		fs.attrMode = mode
+	}
+}
+
+// WithNameForm presents the names of the entries of the drive in the Unicode normalisation
+// form. Names are also looked up in that form.
+func WithNameForm(form NameForm) Option {
+	return func(fs *FS) {
+		fs.names.form = form
+	}
+}
+
+// WithCaseInsensitiveLookup looks up the names that do not match exactly without regard to
+// case, nor to Unicode normalisation.
+func WithCaseInsensitiveLookup() Option {
+	return func(fs *FS) {
+		fs.names.caseInsensitive = true
+	}
+}
+
+// WithFilter hides the entries of the drive that filter hides. With blockCreate, such
+// entries cannot be created either, so that they never reach pCloud.
+func WithFilter(filter *Filter, blockCreate bool) Option {
+	return func(fs *FS) {
+		fs.filter = filter
+		fs.filterCreate = blockCreate
+	}
+}
+
+// WithRootFolderID places the pCloud folder folderID at the root of the drive, instead of
+// the root of the pCloud account.
+func WithRootFolderID(folderID uint64) Option {
+	return func(fs *FS) {
+		fs.rootFolderID = folderID
+	}
+}
+
+// WithDownloadParallelism sets how many chunks of a file are fetched concurrently when the
+// file is read sequentially in ReadModeHTTP. A value of 1 or less disables the parallel
+// fetches.
+func WithDownloadParallelism(n int) Option {
+	return func(fs *FS) {
+		fs.downloadParallelism = n
+	}
+}
+
+// WithChunkedUploads has the files that are created or truncated uploaded in chunks of
+// chunkSize bytes, through pCloud's upload sessions. Their content is staged locally and
+// the uploads are recorded in journal, so that an interrupted upload resumes where pCloud
+// left it, including after a restart of the drive.
+// Once a file is closed, its upload is completed in the background by workers uploads
+// at a time, which attempt it again until it succeeds.
+func WithChunkedUploads(journal *uploads.Journal, chunkSize int64, workers int) Option {
+	return func(fs *FS) {
+		fs.uploads = journal
+		fs.uploadChunkSize = chunkSize
+		fs.uploadWorkers = workers
+	}
+}
+
+// WithContentCache keeps the content of the files read from start to end in c, from where
+// they are read again while their content is unchanged, including when pCloud is
+// unreachable.
+func WithContentCache(c *cache.Cache) Option {
+	return func(fs *FS) {
+		fs.cache = c
+	}
+}
+
+// WithBandwidthLimits exposes the limits of the transfers to (upload) and from (download)
+// pCloud, which the HTTP transport of the pCloud client applies, so that they can be
+// changed at runtime.
+func WithBandwidthLimits(upload, download *bandwidth.Limiter) Option {
+	return func(fs *FS) {
+		fs.uploadLimit, fs.downloadLimit = upload, download
+	}
+}
+
+// WithScheduler exposes the scheduler of the pCloud requests, which the HTTP transport of
+// the pCloud client applies, in the status of the drive.
+func WithScheduler(s *scheduler.Scheduler) Option {
+	return func(fs *FS) {
+		fs.scheduler = s
+	}
+}
+
+// WithReadMode sets how the content of the files opened for reading only is read.
+func WithReadMode(mode ReadMode) Option {
+	return func(fs *FS) {
+		fs.readMode = mode
+	}
+}
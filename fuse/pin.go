@@ -0,0 +1,339 @@
+package fuse
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path"
+	"syscall"
+	"time"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"github.com/seborama/pcloud-sdk/sdk"
+
+	"github.com/seborama/pcloud-drive/v1/cache"
+	"github.com/seborama/pcloud-drive/v1/logger"
+	"github.com/seborama/pcloud-drive/v1/metrics"
+	"github.com/seborama/pcloud-drive/v1/pcloud"
+)
+
+// XattrPinned is the extended attribute of the files and folders that are pinned: "pinned"
+// for those pinned themselves, "inherited" for those within a pinned folder. Setting it pins
+// the file or folder, removing it unpins it.
+const XattrPinned = "user.pcloud.pinned"
+
+// pinPollInterval is how often the changes to the pinned files and folders are looked for
+// in the pCloud diff feed.
+const pinPollInterval = 30 * time.Second
+
+// errNoContentCache is returned when pinning while the content cache is disabled.
+var errNoContentCache = errors.New("pinning requires the content cache: see --cache-size")
+
+// pinner keeps the content of the pinned files and folders in the content cache.
+type pinner struct {
+	fs     *FS
+	wake   chan struct{}
+	diffID uint64 // the last event of the pCloud diff feed that was looked at
+}
+
+func newPinner(fs *FS) *pinner {
+	return &pinner{fs: fs, wake: make(chan struct{}, 1)}
+}
+
+// signal asks the pinner to synchronise the pinned content.
+func (p *pinner) signal() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run synchronises the pinned content with pCloud, and again when it changes, until ctx is
+// done. A failed synchronisation is attempted again on the next poll of the changes.
+func (p *pinner) run(ctx context.Context) {
+	ticker := time.NewTicker(pinPollInterval)
+	defer ticker.Stop()
+
+	dirty := true
+
+	for {
+		if dirty && p.fs.online() {
+			if err := p.sync(ctx); err != nil {
+				logger.WarnContext(ctx, "pinned content synchronisation failed: will be attempted again", "error", err)
+			} else {
+				dirty = false
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-p.wake:
+			dirty = true
+		case <-ticker.C:
+			changed, err := p.changed(ctx)
+			if err != nil {
+				logger.DebugContext(ctx, "pCloud diff failed", "error", err)
+				continue
+			}
+			dirty = dirty || changed
+		}
+	}
+}
+
+// changed reports whether the pCloud diff feed has events about the pinned files and
+// folders since it was last looked at.
+func (p *pinner) changed(ctx context.Context) (bool, error) {
+	if len(p.fs.cache.Pins()) == 0 || !p.fs.online() {
+		return false, nil
+	}
+
+	after := time.Time{}
+	if p.diffID == 0 {
+		// the pinned content is synchronised from scratch first: only the later events matter.
+		after = time.Now().Add(-pinPollInterval)
+	}
+
+	res, err := p.fs.pcClient.Diff(ctx, p.diffID, after, 0, false, 0)
+	if err != nil {
+		return false, err
+	}
+	p.diffID = res.DiffID
+
+	for _, e := range res.Entries {
+		md := e.Metadata
+		if e.Event == sdk.Reset ||
+			p.fs.cache.PinnedFolder(md.ParentFolderID) ||
+			md.IsFolder && p.fs.cache.PinnedFolder(md.FolderID) ||
+			!md.IsFolder && p.fs.cache.Pinned(md.FileID) {
+			logger.DebugContext(ctx, "pinned content changed", "event", e.Event, "name", md.Name)
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// sync finds the files and folders that the pins cover, and downloads the content of the
+// files that the content cache does not hold yet. The directories of the pinned folders are
+// listed so that they can be browsed offline.
+func (p *pinner) sync(ctx context.Context) error {
+	c := p.fs.cache
+	files := map[uint64]*sdk.Metadata{}
+	folderIDs := []uint64{}
+
+	for _, pin := range c.Pins() {
+		var err error
+		if pin.Folder {
+			var tree *sdk.Metadata
+			if tree, err = p.fs.pcClient.ListTree(ctx, pin.ID); err == nil {
+				collectTree(tree, files, &folderIDs)
+			}
+		} else {
+			var md *sdk.Metadata
+			if md, err = p.fs.pcClient.StatFile(ctx, pin.ID); err == nil {
+				files[md.FileID] = md
+			}
+		}
+		if pcloud.IsNotFound(err) {
+			logger.WarnContext(ctx, "pinned file or folder no longer exists: unpinned", "path", pin.Path)
+			if _, err = c.RemovePin(pin.Folder, pin.ID); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		p.listPinned(ctx, pin)
+	}
+
+	fileIDs := make([]uint64, 0, len(files))
+	for fileID := range files {
+		fileIDs = append(fileIDs, fileID)
+	}
+	if err := c.SetPinned(fileIDs, folderIDs); err != nil {
+		return err
+	}
+
+	downloaded := 0
+	for _, md := range files {
+		if c.Has(md.FileID, md.Hash) {
+			continue
+		}
+		if err := p.download(ctx, md); err != nil {
+			return err
+		}
+		downloaded++
+	}
+
+	logger.InfoContext(ctx, "pinned content synchronised", "files", len(files), "downloaded", downloaded)
+
+	return nil
+}
+
+// collectTree adds the files and folders of the folder tree to files and folderIDs.
+func collectTree(tree *sdk.Metadata, files map[uint64]*sdk.Metadata, folderIDs *[]uint64) {
+	*folderIDs = append(*folderIDs, tree.FolderID)
+
+	for _, md := range tree.Contents {
+		if md.IsFolder {
+			collectTree(md, files, folderIDs)
+		} else {
+			files[md.FileID] = md
+		}
+	}
+}
+
+// listPinned lists the directory of the drive at the path of pin, and all of its
+// subdirectories, so that their entries are known offline.
+func (p *pinner) listPinned(ctx context.Context, pin cache.Pin) {
+	if p.fs.root == nil || !pin.Folder {
+		return
+	}
+
+	_, node, err := p.fs.resolve(ctx, path.Clean("/"+pin.Path))
+	dir, ok := node.(*Dir)
+	if err != nil || !ok || dir.folderID != pin.ID {
+		// moved since it was pinned: its entries are listed when it is browsed.
+		logger.DebugContext(ctx, "pinned folder not found at its path", "path", pin.Path, "error", err)
+		return
+	}
+
+	var list func(d *Dir)
+	list = func(d *Dir) {
+		if err := d.materialiseFolder(ctx); err != nil {
+			logger.WarnContext(ctx, "listing of pinned folder failed", "path", d.path, "error", err)
+			return
+		}
+
+		d.lock.RLock()
+		subDirs := make([]*Dir, 0, len(d.Entries))
+		for _, node := range d.Entries {
+			if sub, ok := node.(*Dir); ok {
+				subDirs = append(subDirs, sub)
+			}
+		}
+		d.lock.RUnlock()
+
+		for _, sub := range subDirs {
+			list(sub)
+		}
+	}
+	list(dir)
+}
+
+// download adds the content of the file md to the content cache.
+func (p *pinner) download(ctx context.Context, md *sdk.Metadata) error {
+	w, err := p.fs.cache.Create(md.FileID, md.Hash)
+	if err != nil {
+		return err
+	}
+
+	fileLink := func(refresh bool) (*sdk.FileLink, error) {
+		return p.fs.links.get(ctx, p.fs.pcClient, md.FileID, refresh)
+	}
+
+	for w.Size() < int64(md.Size) {
+		data, err := p.fs.readLink(ctx, fileLink, w.Size(), downloadChunkSize)
+		if err == nil && len(data) == 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			w.Abort()
+			return err
+		}
+		if _, err = w.Write(data); err != nil {
+			w.Abort()
+			return err
+		}
+		metrics.AddBytes(metrics.DirectionRead, len(data))
+	}
+
+	if err = w.Commit(); err != nil {
+		return err
+	}
+	logger.DebugContext(ctx, "pinned content downloaded", "fileID", md.FileID, "size", md.Size)
+
+	return nil
+}
+
+// pin pins node, a file or folder of the drive.
+func (fs *FS) pin(ctx context.Context, node fs.Node) error {
+	if fs.pins == nil {
+		return fuse.Errno(syscall.ENOTSUP)
+	}
+
+	p := cache.Pin{}
+	switch n := node.(type) {
+	case *Dir:
+		p.Path, p.Folder, p.ID = n.path, true, n.folderID
+	case *File:
+		p.Path, p.ID = n.path, n.id()
+	default:
+		// the virtual files and folders are not in pCloud's tree.
+		return fuse.Errno(syscall.ENOTSUP)
+	}
+
+	if err := fs.cache.AddPin(p); err != nil {
+		logger.ErrorContext(ctx, "pin recording failed", "path", p.Path, "error", err)
+		return err
+	}
+	logger.InfoContext(ctx, "pinned", "path", p.Path)
+	fs.pins.signal()
+
+	return nil
+}
+
+// unpin removes the pin of node, a file or folder of the drive. It reports whether node was
+// pinned itself.
+func (fs *FS) unpin(ctx context.Context, node fs.Node) (bool, error) {
+	if fs.pins == nil {
+		return false, fuse.Errno(syscall.ENOTSUP)
+	}
+
+	var folder bool
+	var id uint64
+	switch n := node.(type) {
+	case *Dir:
+		folder, id = true, n.folderID
+	case *File:
+		id = n.id()
+	default:
+		return false, nil
+	}
+
+	removed, err := fs.cache.RemovePin(folder, id)
+	if err != nil {
+		logger.ErrorContext(ctx, "pin removal failed", "error", err)
+		return false, err
+	}
+	if removed {
+		logger.InfoContext(ctx, "unpinned")
+		fs.pins.signal()
+	}
+
+	return removed, nil
+}
+
+// pinnedXattr returns the value of XattrPinned of the file, or of the folder if folder is
+// set, whose ID is id.
+func (fs *FS) pinnedXattr(folder bool, id uint64) ([]byte, error) {
+	if fs.pins == nil {
+		return nil, fuse.ErrNoXattr
+	}
+
+	for _, pin := range fs.cache.Pins() {
+		if pin.Folder == folder && pin.ID == id {
+			return []byte("pinned"), nil
+		}
+	}
+
+	if folder && fs.cache.PinnedFolder(id) || !folder && fs.cache.Pinned(id) {
+		return []byte("inherited"), nil
+	}
+
+	return nil, fuse.ErrNoXattr
+}
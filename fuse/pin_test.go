@@ -0,0 +1,128 @@
+package fuse
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/seborama/pcloud-drive/v1/cache"
+)
+
+func TestPinner(t *testing.T) {
+	var (
+		lock     sync.Mutex
+		docsHash = 121
+		events   = `[]`
+	)
+	content := map[string]string{"11": "aaa", "12": "bbbbb", "20": "cc"}
+
+	pcClient := newFakePCloud(t, func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		q := r.URL.Query()
+		switch {
+		case r.URL.Path == "/listfolder":
+			require.Equal(t, "5", q.Get("folderid"))
+			require.Equal(t, "1", q.Get("recursive"))
+			_, _ = fmt.Fprintf(w, `{"result": 0, "metadata": {"folderid": 5, "isfolder": true, "name": "docs", "contents": [
+				{"fileid": 11, "parentfolderid": 5, "name": "a.txt", "size": 3, "hash": 111},
+				{"folderid": 6, "parentfolderid": 5, "isfolder": true, "name": "sub", "contents": [
+					{"fileid": 12, "parentfolderid": 6, "name": "b.txt", "size": %d, "hash": %d}
+				]}
+			]}}`, len(content["12"]), docsHash)
+
+		case r.URL.Path == "/stat":
+			require.Equal(t, "20", q.Get("fileid"))
+			_, _ = w.Write([]byte(`{"result": 0, "metadata": {"fileid": 20, "parentfolderid": 0, "name": "c.txt", "size": 2, "hash": 201}}`))
+
+		case r.URL.Path == "/getfilelink":
+			_, _ = fmt.Fprintf(w, `{"result": 0, "path": "/content/%s", "hosts": ["c1.pcloud.com"], "expires": "Sat, 24 Jul 2100 10:00:00 +0000"}`, q.Get("fileid"))
+
+		case strings.HasPrefix(r.URL.Path, "/content/"):
+			http.ServeContent(w, r, "", time.Time{}, bytes.NewReader([]byte(content[strings.TrimPrefix(r.URL.Path, "/content/")])))
+
+		case r.URL.Path == "/diff":
+			_, _ = fmt.Fprintf(w, `{"result": 0, "diffid": 100, "entries": %s}`, events)
+
+		default:
+			t.Errorf("unexpected method %s", r.URL.Path)
+		}
+	})
+
+	c, err := cache.Open(filepath.Join(t.TempDir(), "content"), 4)
+	require.NoError(t, err)
+
+	fs := &FS{pcClient: pcClient, cache: c, links: newLinkCache()}
+	fs.pins = newPinner(fs)
+	ctx := context.Background()
+
+	docs := &Dir{fs: fs, path: "/docs", folderID: 5}
+	file := &File{fs: fs, path: "/c.txt", fileID: 20}
+	require.NoError(t, fs.pin(ctx, docs))
+	require.NoError(t, fs.pin(ctx, file))
+
+	// the content of the pinned subtree is downloaded, even beyond the maximum size of the
+	// cache.
+	require.NoError(t, fs.pins.sync(ctx))
+	require.True(t, c.Has(11, 111))
+	require.True(t, c.Has(12, 121))
+	require.True(t, c.Has(20, 201))
+
+	value, err := fs.pinnedXattr(true, 5)
+	require.NoError(t, err)
+	require.Equal(t, "pinned", string(value))
+	value, err = fs.pinnedXattr(true, 6)
+	require.NoError(t, err)
+	require.Equal(t, "inherited", string(value))
+	value, err = fs.pinnedXattr(false, 12)
+	require.NoError(t, err)
+	require.Equal(t, "inherited", string(value))
+	_, err = fs.pinnedXattr(false, 99)
+	require.Error(t, err)
+
+	// the changes to the pinned content are found in the diff feed.
+	changed, err := fs.pins.changed(ctx)
+	require.NoError(t, err)
+	require.False(t, changed)
+
+	lock.Lock()
+	events = `[{"event": "modifyfile", "diffid": 100, "metadata": {"fileid": 99, "parentfolderid": 42, "name": "other.txt"}}]`
+	lock.Unlock()
+	changed, err = fs.pins.changed(ctx)
+	require.NoError(t, err)
+	require.False(t, changed)
+
+	lock.Lock()
+	events = `[{"event": "modifyfile", "diffid": 100, "metadata": {"fileid": 12, "parentfolderid": 6, "name": "b.txt"}}]`
+	content["12"], docsHash = "dddd", 122
+	lock.Unlock()
+	changed, err = fs.pins.changed(ctx)
+	require.NoError(t, err)
+	require.True(t, changed)
+
+	require.NoError(t, fs.pins.sync(ctx))
+	require.False(t, c.Has(12, 121))
+	require.True(t, c.Has(12, 122))
+
+	// the content that is no longer pinned becomes subject to eviction.
+	removed, err := fs.unpin(ctx, docs)
+	require.NoError(t, err)
+	require.True(t, removed)
+	require.NoError(t, fs.pins.sync(ctx))
+	require.False(t, c.Pinned(11))
+	require.False(t, c.Has(11, 111))
+	require.True(t, c.Has(20, 201))
+
+	removed, err = fs.unpin(ctx, docs)
+	require.NoError(t, err)
+	require.False(t, removed)
+}
@@ -0,0 +1,383 @@
+package fuse
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"sync"
+	"syscall"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"github.com/samber/lo"
+	"github.com/seborama/pcloud-sdk/sdk"
+
+	"github.com/seborama/pcloud-drive/v1/logger"
+	"github.com/seborama/pcloud-drive/v1/metrics"
+	"github.com/seborama/pcloud-drive/v1/pcloud"
+)
+
+// RevisionsDirName is the name of the virtual directory that exposes the past revisions of
+// the files at the root of the drive.
+const RevisionsDirName = ".revisions"
+
+// revisionsInode is the inode number of the root of the virtual revisions directory.
+const revisionsInode = trashInode + 1
+
+// revisionTimeFormat is the layout of the timestamp that prefixes the name of a revision.
+const revisionTimeFormat = "20060102T150405Z"
+
+// RevisionsDir mirrors a directory of the drive. Its files appear as directories that hold
+// their past revisions.
+type RevisionsDir struct {
+	Attributes fuse.Attr
+
+	Entries map[string]fs.Node
+	lock    sync.RWMutex // protects Entries
+
+	fs   *FS
+	path string
+	dir  *Dir // the mirrored directory
+}
+
+// FileRevisions is a directory that holds the past revisions of a file of the drive.
+type FileRevisions struct {
+	Attributes fuse.Attr
+
+	Entries map[string]fs.Node
+	lock    sync.RWMutex // protects Entries
+
+	fs     *FS
+	path   string
+	fileID uint64
+}
+
+// RevisionFile is a read-only past revision of a file.
+type RevisionFile struct {
+	Attributes fuse.Attr
+
+	fs         *FS
+	path       string
+	fileID     uint64
+	revisionID uint64
+
+	link     *sdk.FileLink
+	linkLock sync.Mutex // protects link
+}
+
+// ensure interfaces conpliance
+var (
+	_ fs.Node               = (*RevisionsDir)(nil)
+	_ fs.NodeStringLookuper = (*RevisionsDir)(nil)
+	_ fs.HandleReadDirAller = (*RevisionsDir)(nil)
+	_ fs.Node               = (*FileRevisions)(nil)
+	_ fs.NodeStringLookuper = (*FileRevisions)(nil)
+	_ fs.HandleReadDirAller = (*FileRevisions)(nil)
+	_ fs.Node               = (*RevisionFile)(nil)
+	_ fs.NodeOpener         = (*RevisionFile)(nil)
+	_ fs.HandleReader       = (*RevisionFile)(nil)
+)
+
+// RevisionName returns the name under which the revision r appears in the revisions view.
+func RevisionName(r pcloud.Revision) string {
+	return fmt.Sprintf("%s-%d", r.Created.UTC().Format(revisionTimeFormat), r.RevisionID)
+}
+
+func (r *RevisionsDir) logContext(ctx context.Context) context.Context {
+	return logger.WithAttrs(ctx, slog.String("path", r.path))
+}
+
+func (r *RevisionsDir) Attr(ctx context.Context, a *fuse.Attr) error {
+	*a = r.Attributes
+	a.Mode = os.ModeDir | (r.fs.dirPerms & 0o555)
+	a.Uid = r.fs.uid
+	a.Gid = r.fs.gid
+	a.Valid = r.fs.dirValid
+	return nil
+}
+
+// child returns the node of the revisions view that mirrors node, the entry called name of
+// the mirrored directory. Virtual entries, such as the trash, are not mirrored.
+func (r *RevisionsDir) child(name string, node fs.Node) (fs.Node, bool) {
+	attrs := fuse.Attr{
+		Inode: fs.GenerateDynamicInode(r.Attributes.Inode, name),
+		Nlink: 1,
+	}
+
+	switch n := node.(type) {
+	case *Dir:
+		attrs.Atime, attrs.Mtime, attrs.Ctime = n.Attributes.Atime, n.Attributes.Mtime, n.Attributes.Ctime
+		return &RevisionsDir{
+			Attributes: attrs,
+			fs:         r.fs,
+			path:       path.Join(r.path, name),
+			dir:        n,
+		}, true
+
+	case *File:
+		fileAttrs := n.attrs()
+		attrs.Atime, attrs.Mtime, attrs.Ctime = fileAttrs.Atime, fileAttrs.Mtime, fileAttrs.Ctime
+		return &FileRevisions{
+			Attributes: attrs,
+			fs:         r.fs,
+			path:       path.Join(r.path, name),
+			fileID:     n.id(),
+		}, true
+
+	default:
+		return nil, false
+	}
+}
+
+// entry returns the node called name, which mirrors the entry of the same name in the
+// mirrored directory.
+func (r *RevisionsDir) entry(ctx context.Context, name string) (fs.Node, error) {
+	r.lock.RLock()
+	node, ok := r.Entries[name]
+	r.lock.RUnlock()
+	if ok {
+		return node, nil
+	}
+
+	mirrored, err := r.dir.lookup(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	node, ok = r.child(name, mirrored)
+	if !ok {
+		return nil, syscall.ENOENT
+	}
+
+	r.lock.Lock()
+	if r.Entries == nil {
+		r.Entries = map[string]fs.Node{}
+	}
+	if cached, ok := r.Entries[name]; ok {
+		node = cached
+	} else {
+		r.Entries[name] = node
+	}
+	r.lock.Unlock()
+
+	return node, nil
+}
+
+func (r *RevisionsDir) Lookup(ctx context.Context, name string) (_ fs.Node, err error) {
+	ctx, end := r.fs.startOp(ctx, "Lookup", r.path)
+	defer end(&err)
+	ctx = r.logContext(ctx)
+
+	return r.entry(ctx, name)
+}
+
+func (r *RevisionsDir) ReadDirAll(ctx context.Context) (_ []fuse.Dirent, err error) {
+	ctx, end := r.fs.startOp(ctx, "ReadDirAll", r.path)
+	defer end(&err)
+	ctx = r.logContext(ctx)
+
+	if err := r.dir.materialiseFolder(ctx); err != nil {
+		logger.ErrorContext(ctx, "materialiseFolder failed", "folderID", r.dir.folderID, "error", err)
+		return nil, err
+	}
+
+	r.dir.lock.RLock()
+	mirrored := lo.Assign(r.dir.Entries)
+	r.dir.lock.RUnlock()
+
+	entries := map[string]fs.Node{}
+	for name, node := range mirrored {
+		if child, ok := r.child(name, node); ok {
+			entries[name] = child
+		}
+	}
+
+	r.lock.Lock()
+	r.Entries = entries
+	r.lock.Unlock()
+
+	// files are listed as directories of their revisions.
+	return lo.MapToSlice(entries, func(name string, node fs.Node) fuse.Dirent {
+		return fuse.Dirent{Inode: nodeInode(node), Type: fuse.DT_Dir, Name: name}
+	}), nil
+}
+
+func (f *FileRevisions) logContext(ctx context.Context) context.Context {
+	return logger.WithAttrs(ctx, slog.String("path", f.path))
+}
+
+func (f *FileRevisions) Attr(ctx context.Context, a *fuse.Attr) error {
+	*a = f.Attributes
+	a.Mode = os.ModeDir | (f.fs.dirPerms & 0o555)
+	a.Uid = f.fs.uid
+	a.Gid = f.fs.gid
+	a.Valid = f.fs.dirValid
+	return nil
+}
+
+func (f *FileRevisions) materialiseFolder(ctx context.Context) error {
+	logger.DebugContext(ctx, "entering", slog.Uint64("fileID", f.fileID))
+
+	revisions, err := f.fs.pcClient.ListRevisions(ctx, f.fileID)
+	if err != nil {
+		logger.ErrorContext(ctx, "ListRevisions failed", "fileID", f.fileID, "error", err)
+		return err
+	}
+
+	entries := lo.SliceToMap(revisions, func(rev pcloud.Revision) (string, fs.Node) {
+		name := RevisionName(rev)
+		return name, &RevisionFile{
+			Attributes: fuse.Attr{
+				Valid:     f.fs.fileValid,
+				Inode:     fs.GenerateDynamicInode(f.Attributes.Inode, name),
+				Size:      rev.Size,
+				Blocks:    rev.Size / 512,
+				Atime:     rev.Created.Time,
+				Mtime:     rev.Created.Time,
+				Ctime:     rev.Created.Time,
+				Mode:      f.fs.filePerms & 0o444,
+				Nlink:     1,
+				Uid:       f.fs.uid,
+				Gid:       f.fs.gid,
+				BlockSize: 1_048_576,
+			},
+			fs:         f.fs,
+			path:       path.Join(f.path, name),
+			fileID:     f.fileID,
+			revisionID: rev.RevisionID,
+		}
+	})
+
+	f.lock.Lock()
+	f.Entries = entries
+	f.lock.Unlock()
+
+	return nil
+}
+
+func (f *FileRevisions) Lookup(ctx context.Context, name string) (_ fs.Node, err error) {
+	ctx, end := f.fs.startOp(ctx, "Lookup", f.path)
+	defer end(&err)
+	ctx = f.logContext(ctx)
+
+	f.lock.RLock()
+	node, ok := f.Entries[name]
+	f.lock.RUnlock()
+	metrics.CacheLookup("revisions", ok)
+	if ok {
+		return node, nil
+	}
+
+	if err := f.materialiseFolder(ctx); err != nil {
+		return nil, err
+	}
+
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	if node, ok = f.Entries[name]; ok {
+		return node, nil
+	}
+
+	return nil, syscall.ENOENT
+}
+
+func (f *FileRevisions) ReadDirAll(ctx context.Context) (_ []fuse.Dirent, err error) {
+	ctx, end := f.fs.startOp(ctx, "ReadDirAll", f.path)
+	defer end(&err)
+	ctx = f.logContext(ctx)
+
+	if err := f.materialiseFolder(ctx); err != nil {
+		return nil, err
+	}
+
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	return lo.MapToSlice(f.Entries, func(name string, node fs.Node) fuse.Dirent {
+		return fuse.Dirent{Inode: node.(*RevisionFile).Attributes.Inode, Type: fuse.DT_File, Name: name}
+	}), nil
+}
+
+func (f *RevisionFile) logContext(ctx context.Context) context.Context {
+	return logger.WithAttrs(ctx, slog.String("path", f.path), slog.Uint64("revisionID", f.revisionID))
+}
+
+func (f *RevisionFile) Attr(ctx context.Context, a *fuse.Attr) error {
+	*a = f.Attributes
+	return nil
+}
+
+// Open obtains a download link for the revision. Revisions cannot be written to.
+func (f *RevisionFile) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenResponse) (_ fs.Handle, err error) {
+	ctx, end := f.fs.startOp(ctx, "Open", f.path)
+	defer end(&err)
+	ctx = f.logContext(ctx)
+	logger.DebugContext(ctx, "entering", slog.Group("args", "req", req))
+
+	if !req.Flags.IsReadOnly() {
+		return nil, fuse.Errno(syscall.EACCES)
+	}
+
+	if _, err = f.fileLink(ctx, false); err != nil {
+		return nil, err
+	}
+
+	// a revision never changes.
+	resp.Flags |= fuse.OpenKeepCache
+
+	return f, nil
+}
+
+func (f *RevisionFile) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) (err error) {
+	ctx, end := f.fs.startOp(ctx, "Read", f.path)
+	defer end(&err)
+	ctx = f.logContext(ctx)
+	logger.DebugContext(ctx, "entering", slog.Group("args", "req", req))
+
+	fileLink := func(refresh bool) (*sdk.FileLink, error) { return f.fileLink(ctx, refresh) }
+
+	data, err := f.fs.readLink(ctx, fileLink, req.Offset, int64(req.Size))
+	if err != nil {
+		return err
+	}
+
+	resp.Data = data
+	metrics.AddBytes(metrics.DirectionRead, len(data))
+
+	return nil
+}
+
+// fileLink returns the download link of the revision, obtaining it from pCloud when there
+// is none yet or when refresh is set.
+func (f *RevisionFile) fileLink(ctx context.Context, refresh bool) (*sdk.FileLink, error) {
+	f.linkLock.Lock()
+	defer f.linkLock.Unlock()
+
+	if f.link != nil && !refresh {
+		return f.link, nil
+	}
+
+	link, err := f.fs.pcClient.GetRevisionLink(ctx, f.fileID, f.revisionID)
+	if err != nil {
+		logger.ErrorContext(ctx, "GetRevisionLink failed", "fileID", f.fileID, "error", err)
+		return nil, err
+	}
+	f.link = link
+
+	return link, nil
+}
+
+// nodeInode returns the inode number of node.
+func nodeInode(node fs.Node) uint64 {
+	switch n := node.(type) {
+	case *RevisionsDir:
+		return n.Attributes.Inode
+	case *FileRevisions:
+		return n.Attributes.Inode
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,152 @@
+package fuse
+
+import (
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"bazil.org/fuse"
+
+	"github.com/seborama/pcloud-drive/v1/bandwidth"
+	"github.com/seborama/pcloud-drive/v1/cache"
+	"github.com/seborama/pcloud-drive/v1/scheduler"
+)
+
+// Status describes the state of a running drive.
+type Status struct {
+	Mountpoint     string
+	ReadWrite      bool
+	StartTime      time.Time
+	Online         bool
+	OfflineSince   *time.Time        `json:",omitempty"`
+	Pins           []cache.Pin       `json:",omitempty"`
+	Bandwidth      *Bandwidth        `json:",omitempty"`
+	APIRequests    *scheduler.Status `json:",omitempty"`
+	OpenFiles      []OpenFile
+	OpenForWriting int             // files with open writers
+	PendingUploads []PendingUpload `json:",omitempty"`
+	Cache          CacheUsage
+	LastError      *LastError `json:",omitempty"`
+}
+
+// Bandwidth describes the limits of the transfers of file content to and from pCloud.
+type Bandwidth struct {
+	Upload   bandwidth.Status
+	Download bandwidth.Status
+}
+
+// OpenFile describes a file that currently has open handles.
+type OpenFile struct {
+	Path    string
+	Readers int
+	Writers int
+}
+
+// CacheUsage describes the content of the in-memory directory cache, and of the content
+// cache.
+type CacheUsage struct {
+	Folders      int
+	Entries      int
+	ContentFiles int
+	ContentSize  int64
+	PinnedFiles  int
+	PinnedSize   int64
+}
+
+// LastError describes the most recent failed FUSE operation.
+type LastError struct {
+	Time  time.Time
+	Op    string
+	Path  string
+	Error string
+}
+
+// activity keeps track of the open file handles and of the last error of the drive.
+type activity struct {
+	lock      sync.Mutex
+	openFiles map[string]*OpenFile
+	lastError *LastError
+}
+
+func newActivity() *activity {
+	return &activity{
+		openFiles: map[string]*OpenFile{},
+	}
+}
+
+func (a *activity) opened(path string, flags fuse.OpenFlags) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	of, ok := a.openFiles[path]
+	if !ok {
+		of = &OpenFile{Path: path}
+		a.openFiles[path] = of
+	}
+
+	if flags.IsReadOnly() {
+		of.Readers++
+	} else {
+		of.Writers++
+	}
+}
+
+func (a *activity) released(path string, flags fuse.OpenFlags) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	of, ok := a.openFiles[path]
+	if !ok {
+		return
+	}
+
+	if flags.IsReadOnly() {
+		of.Readers--
+	} else {
+		of.Writers--
+	}
+
+	if of.Readers <= 0 && of.Writers <= 0 {
+		delete(a.openFiles, path)
+	}
+}
+
+// failed records the failure of the FUSE operation op on path.
+// Errors that are part of the normal course of operations, such as looking up a name that
+// does not exist, are not recorded.
+func (a *activity) failed(op, path string, err error) {
+	switch fuse.ToErrno(err) {
+	case fuse.Errno(syscall.ENOENT), fuse.ErrNoXattr:
+		return
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	a.lastError = &LastError{
+		Time:  time.Now(),
+		Op:    op,
+		Path:  path,
+		Error: err.Error(),
+	}
+}
+
+func (a *activity) status(s *Status) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	s.OpenFiles = make([]OpenFile, 0, len(a.openFiles))
+	for _, of := range a.openFiles {
+		s.OpenFiles = append(s.OpenFiles, *of)
+		if of.Writers > 0 {
+			s.OpenForWriting++
+		}
+	}
+	sort.Slice(s.OpenFiles, func(i, j int) bool { return s.OpenFiles[i].Path < s.OpenFiles[j].Path })
+
+	if a.lastError != nil {
+		le := *a.lastError
+		s.LastError = &le
+	}
+}
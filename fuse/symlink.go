@@ -0,0 +1,177 @@
+package fuse
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"github.com/seborama/pcloud-sdk/sdk"
+
+	"github.com/seborama/pcloud-drive/v1/logger"
+)
+
+// SymlinkSuffix is the suffix of the name of the pCloud files that hold symbolic links.
+// pCloud has no notion of symbolic links: each one is stored as a small file whose content
+// is the target of the link, named after the link followed by SymlinkSuffix.
+const SymlinkSuffix = ".pcloud-symlink"
+
+// Link is a symbolic link, stored on pCloud as a marker file.
+type Link struct {
+	Attributes fuse.Attr
+
+	fs     *FS
+	path   string
+	fileID uint64
+
+	target     string
+	targetLock sync.Mutex // protects target
+}
+
+// ensure interfaces conpliance
+var (
+	_ fs.Node           = (*Link)(nil)
+	_ fs.NodeReadlinker = (*Link)(nil)
+	_ fs.NodeSymlinker  = (*Dir)(nil)
+)
+
+// symlinkName returns the name of the link stored in the pCloud file name, and whether
+// name is the name of a marker file.
+func symlinkName(name string) (string, bool) {
+	linkName, ok := strings.CutSuffix(name, SymlinkSuffix)
+	return linkName, ok && linkName != ""
+}
+
+// presentedName returns the name under which the pCloud metadata item is presented: the
+// name of the link for the marker files of links.
+func presentedName(item *sdk.Metadata) string {
+	if !item.IsFolder {
+		if name, ok := symlinkName(item.Name); ok {
+			return name
+		}
+	}
+	return item.Name
+}
+
+// newLink returns the node of the link described by the pCloud metadata item.
+func (d *Dir) newLink(name string, item *sdk.Metadata) *Link {
+	return &Link{
+		Attributes: fuse.Attr{
+			Valid: d.fs.fileValid,
+			Inode: item.FileID,
+			Size:  item.Size, // the length of the target
+			Atime: item.Modified.Time,
+			Mtime: item.Modified.Time,
+			Ctime: item.Modified.Time,
+			Mode:  os.ModeSymlink | 0o777,
+			Nlink: 1,
+			Uid:   d.fs.uid,
+			Gid:   d.fs.gid,
+		},
+		fs:     d.fs,
+		path:   path.Join(d.path, name),
+		fileID: item.FileID,
+	}
+}
+
+// Symlink creates a symbolic link in the receiver.
+func (d *Dir) Symlink(ctx context.Context, req *fuse.SymlinkRequest) (_ fs.Node, err error) {
+	ctx, end := d.fs.startOp(ctx, "Symlink", d.path)
+	defer end(&err)
+	ctx = d.logContext(ctx)
+	logger.DebugContext(ctx, "entering", slog.Uint64("folderID", d.folderID), slog.Group("args", "req", req))
+
+	if !d.fs.readWrite {
+		return nil, syscall.EROFS
+	}
+
+	if d.fs.filterCreate && d.fs.filter.Hidden(path.Join(d.path, req.NewName)) {
+		return nil, syscall.EPERM
+	}
+
+	// the entries are refreshed from pCloud when the name is not among them.
+	if _, err := d.lookup(ctx, req.NewName); err == nil {
+		return nil, syscall.EEXIST
+	} else if !errors.Is(err, syscall.ENOENT) {
+		return nil, err
+	}
+
+	pcFile, err := d.fs.pcClient.FileOpen(ctx, sdk.O_WRITE|sdk.O_CREAT|sdk.O_EXCL, sdk.T4FileByFolderIDName(d.folderID, req.NewName+SymlinkSuffix))
+	if err != nil {
+		logger.ErrorContext(ctx, "FileOpen failed", "folderID", d.folderID, "name", req.NewName, "error", err)
+		return nil, err
+	}
+
+	_, err = d.fs.pcClient.FileWrite(ctx, pcFile.FD, []byte(req.Target))
+	if closeErr := d.fs.pcClient.FileClose(ctx, pcFile.FD); closeErr != nil {
+		logger.WarnContext(ctx, "FileClose failed", "FD", pcFile.FD, "FileID", pcFile.FileID, "error", closeErr)
+	}
+	if err != nil {
+		logger.ErrorContext(ctx, "FileWrite failed", "fileID", pcFile.FileID, "error", err)
+		return nil, err
+	}
+
+	now := time.Now()
+	link := d.newLink(req.NewName, &sdk.Metadata{
+		FileID:   pcFile.FileID,
+		Size:     uint64(len(req.Target)),
+		Modified: &sdk.APITime{Time: now},
+	})
+	link.target = req.Target
+
+	d.addEntry(req.NewName, link)
+
+	return link, nil
+}
+
+func (l *Link) logContext(ctx context.Context) context.Context {
+	return logger.WithAttrs(ctx, slog.String("path", l.path))
+}
+
+func (l *Link) Attr(ctx context.Context, a *fuse.Attr) error {
+	*a = l.Attributes
+	return nil
+}
+
+// Readlink returns the target of the link, reading it from its marker file upon first
+// access.
+func (l *Link) Readlink(ctx context.Context, req *fuse.ReadlinkRequest) (_ string, err error) {
+	ctx, end := l.fs.startOp(ctx, "Readlink", l.path)
+	defer end(&err)
+	ctx = l.logContext(ctx)
+	logger.DebugContext(ctx, "entering", slog.Uint64("fileID", l.fileID))
+
+	l.targetLock.Lock()
+	defer l.targetLock.Unlock()
+
+	if l.target != "" {
+		return l.target, nil
+	}
+
+	pcFile, err := l.fs.pcClient.FileOpen(ctx, 0, sdk.T4FileByID(l.fileID))
+	if err != nil {
+		logger.ErrorContext(ctx, "FileOpen failed", "fileID", l.fileID, "error", err)
+		return "", err
+	}
+	defer func() {
+		if err := l.fs.pcClient.FileClose(ctx, pcFile.FD); err != nil {
+			logger.WarnContext(ctx, "FileClose failed", "FD", pcFile.FD, "error", err)
+		}
+	}()
+
+	data, err := l.fs.pcClient.FilePRead(ctx, pcFile.FD, l.Attributes.Size, 0)
+	if err != nil {
+		logger.ErrorContext(ctx, "FilePRead failed", "fileID", l.fileID, "error", err)
+		return "", err
+	}
+	l.target = string(data)
+
+	return l.target, nil
+}
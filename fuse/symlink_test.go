@@ -0,0 +1,121 @@
+package fuse
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+
+	"bazil.org/fuse"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSymlink(t *testing.T) {
+	type remoteFile struct {
+		fileID  uint64
+		content string
+	}
+
+	var lock sync.Mutex
+	// the files of the folder on pCloud, by name.
+	files := map[string]remoteFile{
+		"taken":                  {fileID: 10, content: "data"},
+		"remote" + SymlinkSuffix: {fileID: 11, content: "../elsewhere"},
+	}
+	fds := map[string]string{} // names of the open files, by descriptor
+	const modified = "Sat, 24 Jul 2021 10:00:00 +0000"
+
+	pcClient := newFakePCloud(t, func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		q := r.URL.Query()
+		switch r.URL.Path {
+		case "/listfolder":
+			items := []string{}
+			for name, f := range files {
+				items = append(items, fmt.Sprintf(`{"fileid": %d, "parentfolderid": 5, "name": %q, "size": %d, "modified": %q}`, f.fileID, name, len(f.content), modified))
+			}
+			_, _ = fmt.Fprintf(w, `{"result": 0, "metadata": {"folderid": 5, "isfolder": true, "name": "dir", "modified": %q, "contents": [%s]}}`, modified, strings.Join(items, ","))
+
+		case "/file_open":
+			name := q.Get("name")
+			if fileID := q.Get("fileid"); fileID != "" {
+				for n, f := range files {
+					if strconv.FormatUint(f.fileID, 10) == fileID {
+						name = n
+					}
+				}
+			} else if _, ok := files[name]; ok {
+				_, _ = w.Write([]byte(`{"result": 2004, "error": "File or folder already exists."}`))
+				return
+			} else {
+				files[name] = remoteFile{fileID: uint64(100 + len(files))}
+			}
+			fd := strconv.Itoa(len(fds) + 1)
+			fds[fd] = name
+			_, _ = fmt.Fprintf(w, `{"result": 0, "fd": %s, "fileid": %d}`, fd, files[name].fileID)
+
+		case "/file_write":
+			data, err := io.ReadAll(r.Body)
+			require.NoError(t, err)
+			f := files[fds[q.Get("fd")]]
+			f.content += string(data)
+			files[fds[q.Get("fd")]] = f
+			_, _ = fmt.Fprintf(w, `{"result": 0, "bytes": %d}`, len(data))
+
+		case "/file_pread":
+			w.Header().Set("Content-Type", "application/octet-stream")
+			_, _ = w.Write([]byte(files[fds[q.Get("fd")]].content))
+
+		case "/file_close":
+			_, _ = w.Write([]byte(`{"result": 0}`))
+
+		default:
+			t.Errorf("unexpected method %s", r.URL.Path)
+		}
+	})
+
+	fs := &FS{pcClient: pcClient, readWrite: true, activity: newActivity()}
+	dir := &Dir{fs: fs, path: "/dir", folderID: 5}
+	ctx := context.Background()
+	require.NoError(t, dir.materialiseFolder(ctx))
+
+	// a link is stored as a marker file, whose content is its target.
+	node, err := dir.Symlink(ctx, &fuse.SymlinkRequest{NewName: "link", Target: "/some/target"})
+	require.NoError(t, err)
+	require.Equal(t, "/some/target", files["link"+SymlinkSuffix].content)
+
+	target, err := node.(*Link).Readlink(ctx, &fuse.ReadlinkRequest{})
+	require.NoError(t, err)
+	require.Equal(t, "/some/target", target)
+
+	// the links found on pCloud are read from their marker file.
+	node, err = dir.Lookup(ctx, "remote")
+	require.NoError(t, err)
+	target, err = node.(*Link).Readlink(ctx, &fuse.ReadlinkRequest{})
+	require.NoError(t, err)
+	require.Equal(t, "../elsewhere", target)
+
+	// the names taken, locally or only on pCloud, are refused.
+	_, err = dir.Symlink(ctx, &fuse.SymlinkRequest{NewName: "taken", Target: "t"})
+	require.ErrorIs(t, err, syscall.EEXIST)
+	_, err = dir.Symlink(ctx, &fuse.SymlinkRequest{NewName: "link", Target: "t"})
+	require.ErrorIs(t, err, syscall.EEXIST)
+
+	lock.Lock()
+	files["other"] = remoteFile{fileID: 13}
+	lock.Unlock()
+	_, err = dir.Symlink(ctx, &fuse.SymlinkRequest{NewName: "other", Target: "t"})
+	require.ErrorIs(t, err, syscall.EEXIST)
+	require.NotContains(t, files, "other"+SymlinkSuffix)
+
+	// so are the names of marker files, which would be taken for links.
+	_, _, err = dir.Create(ctx, &fuse.CreateRequest{Name: "x" + SymlinkSuffix}, &fuse.CreateResponse{})
+	require.ErrorIs(t, err, syscall.EINVAL)
+}
@@ -0,0 +1,289 @@
+package fuse
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path"
+	"strconv"
+	"sync"
+	"syscall"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"github.com/samber/lo"
+	"github.com/seborama/pcloud-sdk/sdk"
+
+	"github.com/seborama/pcloud-drive/v1/logger"
+	"github.com/seborama/pcloud-drive/v1/metastore"
+)
+
+// TrashDirName is the name of the virtual directory that exposes pCloud's trash at the root
+// of the drive.
+const TrashDirName = ".trash"
+
+// trashInode is the inode number of the root of the virtual trash directory.
+// It lies well beyond the range of pCloud's file and folder IDs.
+const trashInode = uint64(1) << 62
+
+// TrashDir is a read-only view of a folder of pCloud's trash.
+// Removing one of its entries deletes it permanently, while moving it out of the trash
+// restores it.
+type TrashDir struct {
+	Attributes fuse.Attr
+
+	Entries map[string]fs.Node
+	lock    sync.RWMutex // protects Entries
+
+	fs       *FS
+	path     string
+	folderID uint64
+}
+
+// TrashFile is a file in pCloud's trash. Its content cannot be read until it is restored.
+type TrashFile struct {
+	Attributes fuse.Attr
+
+	fs     *FS
+	path   string
+	fileID uint64
+}
+
+// ensure interfaces conpliance
+var (
+	_ fs.Node               = (*TrashDir)(nil)
+	_ fs.NodeStringLookuper = (*TrashDir)(nil)
+	_ fs.HandleReadDirAller = (*TrashDir)(nil)
+	_ fs.NodeRemover        = (*TrashDir)(nil)
+	_ fs.NodeRenamer        = (*TrashDir)(nil)
+	_ fs.Node               = (*TrashFile)(nil)
+	_ fs.NodeOpener         = (*TrashFile)(nil)
+)
+
+func (t *TrashDir) logContext(ctx context.Context) context.Context {
+	return logger.WithAttrs(ctx, slog.String("path", t.path))
+}
+
+func (t *TrashDir) Attr(ctx context.Context, a *fuse.Attr) error {
+	*a = t.Attributes
+	a.Mode = os.ModeDir | (t.fs.dirPerms & 0o555)
+	a.Uid = t.fs.uid
+	a.Gid = t.fs.gid
+	a.Valid = t.fs.dirValid
+	return nil
+}
+
+func (t *TrashDir) materialiseFolder(ctx context.Context) error {
+	logger.DebugContext(ctx, "entering", slog.Uint64("trashFolderID", t.folderID))
+
+	md, err := t.fs.pcClient.ListTrash(ctx, t.folderID)
+	if err != nil {
+		logger.ErrorContext(ctx, "ListTrash failed", "trashFolderID", t.folderID, "error", err)
+		return err
+	}
+
+	names, duplicates := uniqueNames(md.Contents, func(item *sdk.Metadata) string { return item.Name })
+	t.fs.duplicates.report(ctx, "trash:"+strconv.FormatUint(t.folderID, 10), duplicates)
+
+	entries := lo.SliceToMap(md.Contents, func(item *sdk.Metadata) (string, fs.Node) {
+		name := names[item]
+
+		attrs := fuse.Attr{
+			Valid: t.fs.dirValid,
+			Atime: item.Modified.Time,
+			Mtime: item.Modified.Time,
+			Ctime: item.Modified.Time,
+			Nlink: 1,
+			Uid:   t.fs.uid,
+			Gid:   t.fs.gid,
+		}
+
+		if item.IsFolder {
+			attrs.Inode = item.FolderID
+			attrs.Mode = os.ModeDir | (t.fs.dirPerms & 0o555)
+			return name, &TrashDir{
+				Attributes: attrs,
+				fs:         t.fs,
+				path:       path.Join(t.path, name),
+				folderID:   item.FolderID,
+			}
+		}
+
+		attrs.Inode = item.FileID
+		attrs.Size = item.Size
+		attrs.Blocks = item.Size / 512
+		attrs.Mode = t.fs.filePerms & 0o444
+		return name, &TrashFile{
+			Attributes: attrs,
+			fs:         t.fs,
+			path:       path.Join(t.path, name),
+			fileID:     item.FileID,
+		}
+	})
+
+	t.lock.Lock()
+	t.Entries = entries
+	t.lock.Unlock()
+
+	return nil
+}
+
+// entry returns the node called name in the receiver's entries, refreshing them from
+// pCloud if name is not found.
+func (t *TrashDir) entry(ctx context.Context, name string) (fs.Node, error) {
+	t.lock.RLock()
+	node, ok := t.Entries[name]
+	t.lock.RUnlock()
+	if ok {
+		return node, nil
+	}
+
+	if err := t.materialiseFolder(ctx); err != nil {
+		return nil, err
+	}
+
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	if node, ok = t.Entries[name]; ok {
+		return node, nil
+	}
+
+	return nil, syscall.ENOENT
+}
+
+func (t *TrashDir) Lookup(ctx context.Context, name string) (_ fs.Node, err error) {
+	ctx, end := t.fs.startOp(ctx, "Lookup", t.path)
+	defer end(&err)
+	ctx = t.logContext(ctx)
+
+	return t.entry(ctx, name)
+}
+
+func (t *TrashDir) ReadDirAll(ctx context.Context) (_ []fuse.Dirent, err error) {
+	ctx, end := t.fs.startOp(ctx, "ReadDirAll", t.path)
+	defer end(&err)
+	ctx = t.logContext(ctx)
+
+	if err := t.materialiseFolder(ctx); err != nil {
+		return nil, err
+	}
+
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	return lo.MapToSlice(t.Entries, func(name string, node fs.Node) fuse.Dirent {
+		if dir, ok := node.(*TrashDir); ok {
+			return fuse.Dirent{Inode: dir.Attributes.Inode, Type: fuse.DT_Dir, Name: name}
+		}
+		return fuse.Dirent{Inode: node.(*TrashFile).Attributes.Inode, Type: fuse.DT_File, Name: name}
+	}), nil
+}
+
+// Remove deletes the entry permanently from pCloud's trash.
+func (t *TrashDir) Remove(ctx context.Context, req *fuse.RemoveRequest) (err error) {
+	ctx, end := t.fs.startOp(ctx, "Remove", t.path)
+	defer end(&err)
+	ctx = t.logContext(ctx)
+	logger.DebugContext(ctx, "entering", slog.Group("args", "req", req))
+
+	if !t.fs.readWrite {
+		return syscall.EROFS
+	}
+
+	node, err := t.entry(ctx, req.Name)
+	if err != nil {
+		return err
+	}
+
+	fileID, folderID := trashIDs(node)
+	if err = t.fs.pcClient.PurgeFromTrash(ctx, fileID, folderID); err != nil {
+		logger.ErrorContext(ctx, "PurgeFromTrash failed", "name", req.Name, "error", err)
+		return err
+	}
+	logger.InfoContext(ctx, "purged from trash", "name", req.Name)
+
+	// deleted entries keep their IDs in the trash, until they are purged.
+	if fileID != 0 {
+		t.fs.forget(ctx, metastore.FileKey(fileID))
+	} else {
+		t.fs.forget(ctx, metastore.FolderKey(folderID))
+	}
+
+	t.lock.Lock()
+	delete(t.Entries, req.Name)
+	t.lock.Unlock()
+
+	return nil
+}
+
+// Rename restores the entry when it is moved out of the trash.
+func (t *TrashDir) Rename(ctx context.Context, req *fuse.RenameRequest, newDir fs.Node) (err error) {
+	ctx, end := t.fs.startOp(ctx, "Rename", t.path)
+	defer end(&err)
+	ctx = t.logContext(ctx)
+	logger.DebugContext(ctx, "entering", slog.Group("args", "req", req))
+
+	if !t.fs.readWrite {
+		return syscall.EROFS
+	}
+
+	target, ok := newDir.(*Dir)
+	if !ok {
+		// entries can only be moved out of the trash.
+		return syscall.EPERM
+	}
+
+	node, err := t.entry(ctx, req.OldName)
+	if err != nil {
+		return err
+	}
+
+	fileID, folderID := trashIDs(node)
+	if err = t.fs.pcClient.RestoreFromTrash(ctx, fileID, folderID, target.folderID); err != nil {
+		logger.ErrorContext(ctx, "RestoreFromTrash failed", "name", req.OldName, "error", err)
+		return err
+	}
+	logger.InfoContext(ctx, "restored from trash", "name", req.OldName, "to", target.path)
+
+	if req.NewName != req.OldName {
+		if fileID != 0 {
+			_, err = t.fs.pcClient.RenameFile(ctx, sdk.T3FileByID(fileID), sdk.ToT3ByIDName(target.folderID, req.NewName))
+		} else {
+			_, err = t.fs.pcClient.RenameFolder(ctx, sdk.T1FolderByID(folderID), sdk.ToT2FolderByIDName(target.folderID, req.NewName))
+		}
+		if err != nil {
+			logger.ErrorContext(ctx, "rename of restored entry failed", "name", req.OldName, "newName", req.NewName, "error", err)
+			return err
+		}
+	}
+
+	t.lock.Lock()
+	delete(t.Entries, req.OldName)
+	t.lock.Unlock()
+
+	if err = target.materialiseFolder(ctx); err != nil {
+		return err
+	}
+	t.fs.invalidateEntry(target, req.NewName)
+
+	return nil
+}
+
+func (f *TrashFile) Attr(ctx context.Context, a *fuse.Attr) error {
+	*a = f.Attributes
+	return nil
+}
+
+// Open refuses to open trashed files: they must be restored first.
+func (f *TrashFile) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenResponse) (fs.Handle, error) {
+	return nil, fuse.Errno(syscall.EACCES)
+}
+
+// trashIDs returns the pCloud fileID or folderID of a node of the trash.
+func trashIDs(node fs.Node) (fileID, folderID uint64) {
+	if dir, ok := node.(*TrashDir); ok {
+		return 0, dir.folderID
+	}
+	return node.(*TrashFile).fileID, 0
+}
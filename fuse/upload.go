@@ -0,0 +1,382 @@
+package fuse
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+
+	"github.com/seborama/pcloud-sdk/sdk"
+
+	"github.com/seborama/pcloud-drive/v1/logger"
+	"github.com/seborama/pcloud-drive/v1/metrics"
+	"github.com/seborama/pcloud-drive/v1/pcloud"
+	"github.com/seborama/pcloud-drive/v1/uploads"
+)
+
+// defaultUploadChunkSize is the size of the chunks written to pCloud by chunked uploads.
+const defaultUploadChunkSize = 8 * mb
+
+// upload is a chunked upload of the content of a file. The content is staged in a local
+// file and written to pCloud in chunks, from the acknowledged offset onwards.
+type upload struct {
+	lock     sync.Mutex // serialises the changes to the content and the attempts to upload it
+	entry    uploads.Upload
+	staging  *os.File
+	size     int64 // the size of the staged content
+	deferred bool  // whether the chunks are left to the upload queue, after a failure
+	done     bool  // whether the upload is saved, or abandoned
+
+	// saved is called once the upload is saved, if set. It changes when another node of the
+	// file adopts the upload.
+	saved func(md *sdk.Metadata)
+}
+
+// lockedUpload returns the upload of the file, locked, or nil when it has none.
+func (f *File) lockedUpload() *upload {
+	f.uploadLock.Lock()
+	u := f.upload
+	f.uploadLock.Unlock()
+
+	if u == nil {
+		return nil
+	}
+
+	u.lock.Lock()
+	if u.done {
+		u.lock.Unlock()
+		f.clearUpload(u)
+		return nil
+	}
+
+	return u
+}
+
+// hasUpload reports whether the file has a pending upload.
+func (f *File) hasUpload() bool {
+	u := f.lockedUpload()
+	if u == nil {
+		return false
+	}
+	u.lock.Unlock()
+
+	return true
+}
+
+// writing reports whether the file is being written, through a chunked upload or its file
+// descriptor.
+func (f *File) writing() bool {
+	f.uploadLock.Lock()
+	uploading := f.upload != nil
+	f.uploadLock.Unlock()
+
+	f.writeLock.Lock()
+	defer f.writeLock.Unlock()
+
+	return uploading || len(f.writeBufs) > 0
+}
+
+// adoptUpload makes the pending upload of previous, a former node of the file, that of the
+// file, so that the file is not uploaded twice.
+func (f *File) adoptUpload(previous *File) {
+	u := previous.lockedUpload()
+	if u == nil {
+		return
+	}
+	defer u.lock.Unlock()
+
+	u.saved = func(md *sdk.Metadata) { f.uploadSaved(u, md) }
+	f.setSize(uint64(u.size))
+
+	f.uploadLock.Lock()
+	f.upload = u
+	f.uploadLock.Unlock()
+}
+
+// abandonUpload abandons the pending upload of the file, if any, such as when a file that
+// pCloud does not have yet is removed.
+func (f *File) abandonUpload(ctx context.Context) {
+	u := f.lockedUpload()
+	if u == nil {
+		return
+	}
+	f.fs.discardUpload(ctx, u)
+	u.lock.Unlock()
+	f.clearUpload(u)
+}
+
+// clearUpload detaches u from the file, if it still is its upload.
+func (f *File) clearUpload(u *upload) {
+	f.uploadLock.Lock()
+	defer f.uploadLock.Unlock()
+
+	if f.upload == u {
+		f.upload = nil
+	}
+}
+
+// startUpload starts a chunked upload that replaces the content of the file.
+// The upload is created on pCloud along with its first chunk, so that the file can be
+// written without a connection to pCloud.
+func (f *File) startUpload(ctx context.Context) error {
+	return f.beginUpload(ctx, f.fs.uploads.NewUpload(f.id(), f.path))
+}
+
+// createUpload starts a chunked upload that creates the file, called name in the folder
+// folderID, once saved. The file is not on pCloud until then.
+func (f *File) createUpload(ctx context.Context, folderID uint64, name string) error {
+	entry := f.fs.uploads.NewUpload(0, f.path)
+	entry.FolderID, entry.Name = folderID, name
+
+	return f.beginUpload(ctx, entry)
+}
+
+// beginUpload starts the chunked upload entry of the file.
+func (f *File) beginUpload(ctx context.Context, entry uploads.Upload) error {
+	staging, err := os.OpenFile(entry.Staging, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		logger.ErrorContext(ctx, "staging file creation failed", "error", err)
+		return err
+	}
+
+	if err = f.fs.uploads.Put(entry); err != nil {
+		_ = staging.Close()
+		_ = os.Remove(entry.Staging)
+		logger.ErrorContext(ctx, "upload journal update failed", "error", err)
+		return err
+	}
+
+	u := &upload{entry: entry, staging: staging}
+	u.saved = func(md *sdk.Metadata) { f.uploadSaved(u, md) }
+
+	f.uploadLock.Lock()
+	f.upload = u
+	f.uploadLock.Unlock()
+	logger.DebugContext(ctx, "chunked upload started", slog.Uint64("id", entry.ID))
+
+	return nil
+}
+
+// reopenUpload prepares the upload u of the file to be written to again, with its content
+// truncated when truncate is set. The caller must hold the lock of u.
+func (f *File) reopenUpload(ctx context.Context, u *upload, truncate bool) error {
+	u.entry.Closed = false // the queue leaves it until it is closed again
+	if truncate {
+		return f.truncateUpload(ctx, u, 0)
+	}
+
+	return f.fs.uploads.Put(u.entry)
+}
+
+// writeUpload stages data at offset in u and writes the chunks of the content that are
+// complete to pCloud. Should that fail, the chunks are left to the upload queue. The caller
+// must hold the lock of u.
+func (f *File) writeUpload(ctx context.Context, u *upload, offset int64, data []byte) error {
+	if _, err := u.staging.WriteAt(data, offset); err != nil {
+		logger.ErrorContext(ctx, "staging write failed", "error", err)
+		return err
+	}
+	u.size = max(u.size, offset+int64(len(data)))
+
+	if offset < u.entry.Offset || u.entry.Closed {
+		// the content that pCloud acknowledged changed: it is written again.
+		u.entry.Offset = min(u.entry.Offset, offset)
+		u.entry.Closed = false // written to after it was closed
+		if err := f.fs.uploads.Put(u.entry); err != nil {
+			logger.ErrorContext(ctx, "upload journal update failed", "error", err)
+			return err
+		}
+	}
+
+	if !u.deferred && u.size-u.entry.Offset >= f.fs.uploadChunkSize {
+		if err := f.fs.pushUpload(ctx, u, false); err != nil {
+			logger.WarnContext(ctx, "chunked upload write failed: left to the upload queue", "id", u.entry.ID, "error", err)
+			u.deferred = true
+		}
+	}
+
+	return nil
+}
+
+// truncateUpload sets the size of the content staged in u. The caller must hold the lock
+// of u.
+func (f *File) truncateUpload(ctx context.Context, u *upload, size int64) error {
+	if err := u.staging.Truncate(size); err != nil {
+		logger.ErrorContext(ctx, "staging truncate failed", "error", err)
+		return err
+	}
+	u.size = size
+	u.entry.Offset = min(u.entry.Offset, size)
+
+	return f.fs.uploads.Put(u.entry)
+}
+
+// closeUpload marks the content of u complete and hands it to the upload queue, which saves
+// it over the file. The caller must hold the lock of u.
+func (f *File) closeUpload(ctx context.Context, u *upload) error {
+	if !u.entry.Closed {
+		u.entry.Closed = true
+		if err := f.fs.uploads.Put(u.entry); err != nil {
+			logger.ErrorContext(ctx, "upload journal update failed", "error", err)
+			return err
+		}
+	}
+
+	f.fs.queue.add(u)
+
+	return nil
+}
+
+// uploadSaved updates the file once the queue has saved its upload u.
+func (f *File) uploadSaved(u *upload, md *sdk.Metadata) {
+	f.clearUpload(u)
+
+	f.metaLock.Lock()
+	previous := f.fileID
+	f.fileID = md.FileID
+	f.Attributes.Size = md.Size
+	f.metaLock.Unlock()
+
+	if previous != 0 && md.FileID != previous {
+		logger.Warnf("file ID changed by the upload", "path", f.path, "previous", previous, "fileID", md.FileID)
+		f.xattrs.reset()
+		f.fs.links.drop(previous)
+	}
+	f.contentChanged(md.Hash)
+	f.xattrs.resetContent()
+	f.fs.links.drop(md.FileID)
+}
+
+// pushUpload writes the staged content of u to pCloud, chunk by chunk, from the
+// acknowledged offset. Unless all is set, an incomplete last chunk is left to later.
+// The caller must hold the lock of u.
+func (fs *FS) pushUpload(ctx context.Context, u *upload, all bool) error {
+	if u.entry.UploadID == 0 {
+		uploadID, err := fs.pcClient.CreateUpload(ctx)
+		if err != nil {
+			return err
+		}
+		u.entry.UploadID, u.entry.Offset = uploadID, 0
+		if err = fs.uploads.Put(u.entry); err != nil {
+			return err
+		}
+	}
+
+	buf := make([]byte, fs.uploadChunkSize)
+
+	for u.size-u.entry.Offset >= fs.uploadChunkSize || all && u.entry.Offset < u.size {
+		n, err := u.staging.ReadAt(buf[:min(fs.uploadChunkSize, u.size-u.entry.Offset)], u.entry.Offset)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+
+		if err = fs.pcClient.WriteUpload(ctx, u.entry.UploadID, u.entry.Offset, buf[:n]); err != nil {
+			if pcloud.IsUploadNotFound(err) {
+				// pCloud no longer has the upload: it starts afresh on the next attempt.
+				u.entry.UploadID, u.entry.Offset = 0, 0
+				_ = fs.uploads.Put(u.entry)
+			}
+			return err
+		}
+		metrics.AddBytes(metrics.DirectionWrite, n)
+
+		u.entry.Offset += int64(n)
+		if err = fs.uploads.Put(u.entry); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// completeUpload writes the rest of the staged content of u to pCloud, saves the upload
+// over its file and forgets it. The caller must hold the lock of u.
+func (fs *FS) completeUpload(ctx context.Context, u *upload) (*sdk.Metadata, error) {
+	if err := fs.pushUpload(ctx, u, true); err != nil {
+		return nil, err
+	}
+
+	folderID, name := u.entry.FolderID, u.entry.Name
+	if u.entry.FileID != 0 {
+		// the file may have been moved or renamed since the upload started.
+		file, err := fs.pcClient.StatFile(ctx, u.entry.FileID)
+		if err != nil {
+			return nil, err
+		}
+		folderID, name = file.ParentFolderID, file.Name
+	}
+
+	md, err := fs.pcClient.SaveUpload(ctx, u.entry.UploadID, folderID, name)
+	if err != nil {
+		return nil, err
+	}
+
+	fs.forgetUpload(ctx, u)
+	logger.InfoContext(ctx, "chunked upload saved", "fileID", md.FileID, "size", md.Size)
+
+	return md, nil
+}
+
+// discardUpload abandons u, such as when its file no longer exists. The caller must hold
+// the lock of u.
+func (fs *FS) discardUpload(ctx context.Context, u *upload) {
+	if u.entry.UploadID != 0 {
+		if err := fs.pcClient.DeleteUpload(ctx, u.entry.UploadID); err != nil && !pcloud.IsUploadNotFound(err) {
+			logger.WarnContext(ctx, "DeleteUpload failed", "error", err)
+		}
+	}
+	fs.forgetUpload(ctx, u)
+}
+
+// forgetUpload removes u from the journal, along with its staged content. The caller must
+// hold the lock of u.
+func (fs *FS) forgetUpload(ctx context.Context, u *upload) {
+	u.done = true
+
+	_ = u.staging.Close()
+	if err := os.Remove(u.entry.Staging); err != nil && !errors.Is(err, os.ErrNotExist) {
+		logger.WarnContext(ctx, "staging file removal failed", "error", err)
+	}
+	if err := fs.uploads.Remove(u.entry.ID); err != nil {
+		logger.WarnContext(ctx, "upload journal update failed", "error", err)
+	}
+}
+
+// replayUploads hands the uploads that the journal holds from a previous run of the drive
+// to the upload queue. Those whose file was still being written when the drive stopped are
+// closed with what was written: their staged content is the only copy of it.
+func (fs *FS) replayUploads(ctx context.Context) {
+	for _, entry := range fs.uploads.Uploads() {
+		ctx := logger.WithAttrs(ctx, slog.String("path", entry.Path), slog.Uint64("id", entry.ID))
+
+		staging, err := os.OpenFile(entry.Staging, os.O_RDWR, 0)
+		if err != nil {
+			logger.ErrorContext(ctx, "staged content of upload lost: upload abandoned", "error", err)
+			_ = fs.uploads.Remove(entry.ID)
+			continue
+		}
+
+		info, err := staging.Stat()
+		if err != nil {
+			_ = staging.Close()
+			logger.ErrorContext(ctx, "staged content of upload unreadable", "error", err)
+			continue
+		}
+
+		u := &upload{entry: entry, staging: staging, size: info.Size()}
+
+		if !entry.Closed {
+			logger.WarnContext(ctx, "chunked upload interrupted while the file was written: the content written is uploaded", "size", u.size)
+			u.entry.Closed = true
+			if err := fs.uploads.Put(u.entry); err != nil {
+				// the upload is closed again by the next replay.
+				logger.ErrorContext(ctx, "upload journal update failed", "error", err)
+			}
+		}
+
+		logger.InfoContext(ctx, "chunked upload queued again", "offset", entry.Offset, "size", u.size)
+		fs.queue.add(u)
+	}
+}
@@ -0,0 +1,403 @@
+package fuse
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"bazil.org/fuse"
+	"github.com/seborama/pcloud-sdk/sdk"
+	"github.com/stretchr/testify/require"
+
+	"github.com/seborama/pcloud-drive/v1/uploads"
+)
+
+func TestPushUpload(t *testing.T) {
+	var (
+		lock     sync.Mutex
+		lastID   uint64 = 41
+		lost     bool
+		contents = map[uint64][]byte{} // of the uploads on pCloud, by upload ID
+	)
+
+	pcClient := newFakePCloud(t, func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		q := r.URL.Query()
+		switch r.URL.Path {
+		case "/upload_create":
+			lastID++
+			_, _ = fmt.Fprintf(w, `{"result": 0, "uploadid": %d}`, lastID)
+
+		case "/upload_write":
+			if lost {
+				// pCloud dropped the upload.
+				lost = false
+				_, _ = w.Write([]byte(`{"result": 1900, "error": "Upload not found."}`))
+				return
+			}
+			uploadID, _ := strconv.ParseUint(q.Get("uploadid"), 10, 64)
+			offset, _ := strconv.Atoi(q.Get("uploadoffset"))
+			data, err := io.ReadAll(r.Body)
+			require.NoError(t, err)
+
+			content := contents[uploadID]
+			if end := offset + len(data); end > len(content) {
+				content = append(content, make([]byte, end-len(content))...)
+			}
+			copy(content[offset:], data)
+			contents[uploadID] = content
+			_, _ = w.Write([]byte(`{"result": 0}`))
+
+		default:
+			t.Errorf("unexpected method %s", r.URL.Path)
+		}
+	})
+
+	journal, err := uploads.Open(t.TempDir())
+	require.NoError(t, err)
+
+	pfs := &FS{pcClient: pcClient, uploads: journal, uploadChunkSize: 4}
+	file := &File{fs: pfs, path: "/big.bin", fileID: 7}
+
+	ctx := context.Background()
+	require.NoError(t, file.startUpload(ctx))
+	u := file.lockedUpload()
+	defer u.lock.Unlock()
+
+	// the complete chunks are written as the content is, and their progress is recorded.
+	require.NoError(t, file.writeUpload(ctx, u, 0, []byte("abcdefghij")))
+	require.Equal(t, "abcdefgh", string(contents[42]))
+	require.EqualValues(t, 8, journal.Uploads()[0].Offset)
+	require.EqualValues(t, 42, journal.Uploads()[0].UploadID)
+
+	// the incomplete last chunk is written once the content is complete.
+	require.NoError(t, pfs.pushUpload(ctx, u, false))
+	require.Equal(t, "abcdefgh", string(contents[42]))
+	require.NoError(t, pfs.pushUpload(ctx, u, true))
+	require.Equal(t, "abcdefghij", string(contents[42]))
+
+	// the content that pCloud acknowledged is written again once it changes.
+	require.NoError(t, file.writeUpload(ctx, u, 2, []byte("XY")))
+	require.Equal(t, "abXYefghij", string(contents[42]))
+	require.EqualValues(t, 10, u.entry.Offset)
+
+	// an upload that pCloud lost starts afresh: its chunks are left to the upload queue.
+	lock.Lock()
+	lost = true
+	lock.Unlock()
+	require.NoError(t, file.writeUpload(ctx, u, 10, []byte("klmn")))
+	require.True(t, u.deferred)
+	require.Zero(t, journal.Uploads()[0].UploadID)
+	require.Zero(t, journal.Uploads()[0].Offset)
+
+	require.NoError(t, pfs.pushUpload(ctx, u, true))
+	require.Equal(t, "abXYefghijklmn", string(contents[43]))
+	require.EqualValues(t, 14, journal.Uploads()[0].Offset)
+
+	// closing the content hands the upload to the queue, and records it complete.
+	pfs.queue = newUploadQueue(pfs, 1)
+	require.NoError(t, file.closeUpload(ctx, u))
+	require.True(t, journal.Uploads()[0].Closed)
+	require.Len(t, pfs.queue.status(), 1)
+	require.EqualValues(t, 14, pfs.queue.status()[0].Uploaded)
+}
+
+func TestReplayUploads(t *testing.T) {
+	pcClient := newFakePCloud(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected method %s", r.URL.Path)
+	})
+
+	journal, err := uploads.Open(t.TempDir())
+	require.NoError(t, err)
+
+	// the drive stopped while the file was being written.
+	interrupted := journal.NewUpload(7, "/big.bin")
+	interrupted.UploadID = 42
+	interrupted.Offset = 2
+	require.NoError(t, os.WriteFile(interrupted.Staging, []byte("hello"), 0o600))
+	require.NoError(t, journal.Put(interrupted))
+
+	// the staged content was lost.
+	lost := journal.NewUpload(8, "/other.bin")
+	lost.Closed = true
+	require.NoError(t, journal.Put(lost))
+
+	pfs := &FS{pcClient: pcClient, uploads: journal}
+	pfs.queue = newUploadQueue(pfs, 1)
+	pfs.replayUploads(context.Background())
+
+	// what was written is kept, and uploaded from the acknowledged offset.
+	require.FileExists(t, interrupted.Staging)
+	entries := journal.Uploads()
+	require.Len(t, entries, 1)
+	require.True(t, entries[0].Closed)
+	status := pfs.queue.status()
+	require.Len(t, status, 1)
+	require.Equal(t, "/big.bin", status[0].Path)
+	require.EqualValues(t, 5, status[0].Size)
+	require.EqualValues(t, 2, status[0].Uploaded)
+}
+
+func TestFile_UploadSaved(t *testing.T) {
+	pfs := &FS{links: newLinkCache()}
+	file := &File{fs: pfs, path: "/big.bin", fileID: 7, hash: 70}
+	u := &upload{}
+	file.upload = u
+
+	// the file is updated by the upload queue while it is in use.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		file.uploadSaved(u, &sdk.Metadata{FileID: 8, Size: 12, Hash: 80})
+	}()
+	for range 100 {
+		var a fuse.Attr
+		require.NoError(t, file.Attr(context.Background(), &a))
+		_ = file.id()
+	}
+	<-done
+
+	require.EqualValues(t, 8, file.id())
+	require.EqualValues(t, 12, file.attrs().Size)
+	fileID, hash := file.content()
+	require.EqualValues(t, 8, fileID)
+	require.EqualValues(t, 80, hash)
+	require.Nil(t, file.lockedUpload())
+}
+
+func TestDir_RefreshKeepsFiles(t *testing.T) {
+	var lock sync.Mutex
+	listing := `{"fileid": 10, "parentfolderid": 5, "name": "a.bin", "size": 3, "hash": 100, "modified": %[1]q},
+		{"fileid": 11, "parentfolderid": 5, "name": "b.bin", "size": 4, "hash": 110, "modified": %[1]q}`
+	pcClient := newFakePCloud(t, func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		switch r.URL.Path {
+		case "/listfolder":
+			const modified = "Sat, 24 Jul 2021 10:00:00 +0000"
+			_, _ = fmt.Fprintf(w, `{"result": 0, "metadata": {"folderid": 5, "isfolder": true, "name": "dir", "modified": %q, "contents": [%s]}}`,
+				modified, fmt.Sprintf(listing, modified))
+
+		default:
+			t.Errorf("unexpected method %s", r.URL.Path)
+		}
+	})
+
+	journal, err := uploads.Open(t.TempDir())
+	require.NoError(t, err)
+
+	pfs := &FS{pcClient: pcClient, uploads: journal, uploadChunkSize: mb, readWrite: true, activity: newActivity(), links: newLinkCache()}
+	dir := &Dir{fs: pfs, path: "/dir", folderID: 5}
+	ctx := context.Background()
+	require.NoError(t, dir.materialiseFolder(ctx))
+
+	node, ok := dir.entry("a.bin")
+	require.True(t, ok)
+	a := node.(*File)
+	truncate := fuse.OpenReadWrite | fuse.OpenTruncate
+	_, err = a.Open(ctx, &fuse.OpenRequest{Flags: truncate}, &fuse.OpenResponse{})
+	require.NoError(t, err)
+	require.NoError(t, a.Write(ctx, &fuse.WriteRequest{Data: []byte("hello"), FileFlags: fuse.OpenReadWrite}, &fuse.WriteResponse{}))
+	node, _ = dir.entry("b.bin")
+	b := node.(*File)
+
+	// the files are kept as the folder is listed again, along with their pending upload.
+	lock.Lock()
+	listing = `{"fileid": 10, "parentfolderid": 5, "name": "a.bin", "size": 3, "hash": 100, "modified": %[1]q},
+		{"fileid": 11, "parentfolderid": 5, "name": "b.bin", "size": 6, "hash": 111, "modified": %[1]q}`
+	lock.Unlock()
+	require.NoError(t, dir.materialiseFolder(ctx))
+
+	node, _ = dir.entry("a.bin")
+	require.Same(t, a, node)
+	require.EqualValues(t, 5, a.attrs().Size) // as written
+	node, _ = dir.entry("b.bin")
+	require.Same(t, b, node)
+	require.EqualValues(t, 6, b.attrs().Size) // as changed elsewhere
+	_, hash := b.content()
+	require.EqualValues(t, 111, hash)
+
+	// the file is opened again through the same upload.
+	_, err = a.Open(ctx, &fuse.OpenRequest{Flags: truncate}, &fuse.OpenResponse{})
+	require.NoError(t, err)
+	require.Len(t, journal.Uploads(), 1)
+
+	// a file renamed on pCloud takes over the pending upload of its former node.
+	lock.Lock()
+	listing = `{"fileid": 10, "parentfolderid": 5, "name": "c.bin", "size": 3, "hash": 100, "modified": %[1]q}`
+	lock.Unlock()
+	require.NoError(t, dir.materialiseFolder(ctx))
+
+	node, _ = dir.entry("c.bin")
+	c := node.(*File)
+	require.NotSame(t, a, c)
+	u := c.lockedUpload()
+	require.NotNil(t, u)
+	u.lock.Unlock()
+	require.Same(t, a.upload, u)
+	_, err = c.Open(ctx, &fuse.OpenRequest{Flags: truncate}, &fuse.OpenResponse{})
+	require.NoError(t, err)
+	require.Len(t, journal.Uploads(), 1)
+}
+
+func TestDrive_FlushCache(t *testing.T) {
+	var lock sync.Mutex
+	listing := `{"fileid": 10, "parentfolderid": 5, "name": "a.bin", "size": 3, "hash": 100, "modified": %[1]q}`
+	pcClient := newFakePCloud(t, func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		switch r.URL.Path {
+		case "/listfolder":
+			const modified = "Sat, 24 Jul 2021 10:00:00 +0000"
+			_, _ = fmt.Fprintf(w, `{"result": 0, "metadata": {"folderid": 5, "isfolder": true, "name": "dir", "modified": %q, "contents": [%s]}}`,
+				modified, fmt.Sprintf(listing, modified))
+
+		default:
+			t.Errorf("unexpected method %s", r.URL.Path)
+		}
+	})
+
+	journal, err := uploads.Open(t.TempDir())
+	require.NoError(t, err)
+
+	pfs := &FS{pcClient: pcClient, uploads: journal, uploadChunkSize: mb, readWrite: true, activity: newActivity(), links: newLinkCache()}
+	dir := &Dir{fs: pfs, path: "/", folderID: 5}
+	pfs.root = dir
+	ctx := context.Background()
+	require.NoError(t, dir.materialiseFolder(ctx))
+
+	node, err := dir.lookup(ctx, "a.bin")
+	require.NoError(t, err)
+	a := node.(*File)
+	_, err = a.Open(ctx, &fuse.OpenRequest{Flags: fuse.OpenReadWrite | fuse.OpenTruncate}, &fuse.OpenResponse{})
+	require.NoError(t, err)
+	require.NoError(t, a.Write(ctx, &fuse.WriteRequest{Data: []byte("hello"), FileFlags: fuse.OpenReadWrite}, &fuse.WriteResponse{}))
+
+	// the entries are fetched anew upon next look-up, and the files are kept along with
+	// their pending upload.
+	lock.Lock()
+	listing = `{"fileid": 10, "parentfolderid": 5, "name": "a.bin", "size": 3, "hash": 100, "modified": %[1]q},
+		{"fileid": 11, "parentfolderid": 5, "name": "b.bin", "size": 4, "hash": 110, "modified": %[1]q}`
+	lock.Unlock()
+	(&Drive{fs: pfs}).FlushCache()
+
+	node, err = dir.lookup(ctx, "a.bin")
+	require.NoError(t, err)
+	require.Same(t, a, node)
+	require.True(t, a.hasUpload())
+	require.EqualValues(t, 5, a.attrs().Size)
+	_, ok := dir.entry("b.bin")
+	require.True(t, ok)
+}
+
+func TestDir_CreateOffline(t *testing.T) {
+	var (
+		lock    sync.Mutex
+		down    = true
+		content []byte
+		listing string
+	)
+	pcClient := newFakePCloud(t, func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		q := r.URL.Query()
+		switch r.URL.Path {
+		case "/currentserver":
+			if down {
+				// no response reaches the drive.
+				conn, _, err := w.(http.Hijacker).Hijack()
+				require.NoError(t, err)
+				_ = conn.Close()
+				return
+			}
+			_, _ = w.Write([]byte(`{"result": 0}`))
+
+		case "/listfolder":
+			const modified = "Sat, 24 Jul 2021 10:00:00 +0000"
+			_, _ = fmt.Fprintf(w, `{"result": 0, "metadata": {"folderid": 5, "isfolder": true, "name": "dir", "modified": %q, "contents": [%s]}}`, modified, listing)
+
+		case "/upload_create":
+			_, _ = w.Write([]byte(`{"result": 0, "uploadid": 42}`))
+
+		case "/upload_write":
+			data, err := io.ReadAll(r.Body)
+			require.NoError(t, err)
+			content = append(content, data...)
+			_, _ = w.Write([]byte(`{"result": 0}`))
+
+		case "/upload_save":
+			// the file is created by the upload.
+			require.Equal(t, "5", q.Get("folderid"))
+			require.Equal(t, "new.txt", q.Get("name"))
+			listing = fmt.Sprintf(`{"fileid": 20, "parentfolderid": 5, "name": "new.txt", "size": %d, "modified": "Sat, 24 Jul 2021 10:00:00 +0000"}`, len(content))
+			_, _ = fmt.Fprintf(w, `{"result": 0, "metadata": {"fileid": 20, "name": "new.txt", "size": %d}}`, len(content))
+
+		default:
+			t.Errorf("unexpected method %s", r.URL.Path)
+		}
+	})
+
+	journal, err := uploads.Open(t.TempDir())
+	require.NoError(t, err)
+
+	pfs := &FS{pcClient: pcClient, uploads: journal, uploadChunkSize: mb, readWrite: true, activity: newActivity(), links: newLinkCache()}
+	pfs.queue = newUploadQueue(pfs, 1)
+	dir := &Dir{fs: pfs, path: "/dir", folderID: 5}
+	ctx := context.Background()
+	require.NoError(t, dir.materialiseFolder(ctx))
+
+	require.Eventually(t, func() bool {
+		_ = pcClient.Probe(ctx)
+		return !pcClient.Online()
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// the file is created without pCloud, and written to its upload.
+	node, _, err := dir.Create(ctx, &fuse.CreateRequest{Name: "new.txt", Flags: fuse.OpenReadWrite | fuse.OpenCreate}, &fuse.CreateResponse{})
+	require.NoError(t, err)
+	file := node.(*File)
+	require.Zero(t, file.id())
+	require.NotZero(t, file.attrs().Inode)
+	require.NoError(t, file.Write(ctx, &fuse.WriteRequest{Data: []byte("hello"), FileFlags: fuse.OpenReadWrite}, &fuse.WriteResponse{}))
+	require.NoError(t, file.Flush(ctx, &fuse.FlushRequest{}))
+
+	entries := journal.Uploads()
+	require.Len(t, entries, 1)
+	require.Zero(t, entries[0].FileID)
+	require.EqualValues(t, 5, entries[0].FolderID)
+	require.Equal(t, "new.txt", entries[0].Name)
+
+	// the file remains in its folder until pCloud has it.
+	lock.Lock()
+	down = false
+	lock.Unlock()
+	require.NoError(t, pcClient.Probe(ctx))
+	require.NoError(t, dir.materialiseFolder(ctx))
+	node, err = dir.Lookup(ctx, "new.txt")
+	require.NoError(t, err)
+	require.Same(t, file, node)
+
+	// the upload creates the file.
+	queueCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	pfs.queue.run(queueCtx)
+	require.Eventually(t, func() bool { return file.id() == 20 }, 5*time.Second, 10*time.Millisecond)
+
+	lock.Lock()
+	require.Equal(t, "hello", string(content))
+	lock.Unlock()
+	require.NoError(t, dir.materialiseFolder(ctx))
+	node, err = dir.Lookup(ctx, "new.txt")
+	require.NoError(t, err)
+	require.Same(t, file, node)
+}
@@ -0,0 +1,231 @@
+package fuse
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/seborama/pcloud-drive/v1/logger"
+	"github.com/seborama/pcloud-drive/v1/pcloud"
+)
+
+// bounds of the delay before a failed upload is attempted again. The delay doubles with
+// each failure.
+const (
+	uploadRetryMinDelay = time.Second
+	uploadRetryMaxDelay = 10 * time.Minute
+)
+
+// PendingUpload describes a file whose content is complete and awaits upload to pCloud.
+type PendingUpload struct {
+	Path        string
+	Size        int64
+	Uploaded    int64 // how much of the content pCloud has acknowledged
+	Attempts    int
+	LastError   string `json:",omitempty"`
+	NextAttempt time.Time
+}
+
+// uploadQueue saves the uploads whose content is complete, in the background, attempting
+// again those that fail until they succeed.
+type uploadQueue struct {
+	fs      *FS
+	workers int
+	wake    chan struct{} // signals the workers that an upload may be due
+
+	lock  sync.Mutex // protects items
+	items map[uint64]*queuedUpload
+}
+
+// queuedUpload is an upload in the queue. Its details are a copy of those of the upload,
+// which the status reads without waiting for an attempt in progress.
+type queuedUpload struct {
+	u       *upload
+	pending PendingUpload
+	running bool
+}
+
+func newUploadQueue(fs *FS, workers int) *uploadQueue {
+	return &uploadQueue{
+		fs:      fs,
+		workers: max(workers, 1),
+		wake:    make(chan struct{}, 1),
+		items:   map[uint64]*queuedUpload{},
+	}
+}
+
+// add queues u, for an attempt straight away. The caller must hold the lock of u, which is
+// taken before that of the queue.
+func (q *uploadQueue) add(u *upload) {
+	q.lock.Lock()
+	item, ok := q.items[u.entry.ID]
+	if !ok {
+		item = &queuedUpload{u: u}
+		q.items[u.entry.ID] = item
+	}
+	item.pending.Path = u.entry.Path
+	item.pending.Size = u.size
+	item.pending.Uploaded = u.entry.Offset
+	item.pending.NextAttempt = time.Now()
+	q.lock.Unlock()
+
+	q.signal()
+}
+
+func (q *uploadQueue) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run starts the workers of the queue, which stop when ctx is done.
+func (q *uploadQueue) run(ctx context.Context) {
+	for i := 0; i < q.workers; i++ {
+		go q.work(ctx)
+	}
+}
+
+func (q *uploadQueue) work(ctx context.Context) {
+	for {
+		item, wait := q.take()
+		if item != nil {
+			q.attempt(ctx, item)
+			continue
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-q.wake:
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+		timer.Stop()
+	}
+}
+
+// take returns the upload due for an attempt that has waited the longest, or how long to
+// wait for one to be due.
+func (q *uploadQueue) take() (*queuedUpload, time.Duration) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	now := time.Now()
+	var due []*queuedUpload
+	wait := time.Hour
+
+	for _, item := range q.items {
+		switch {
+		case item.running:
+		case !item.pending.NextAttempt.After(now):
+			due = append(due, item)
+		default:
+			wait = min(wait, item.pending.NextAttempt.Sub(now))
+		}
+	}
+
+	if len(due) == 0 {
+		return nil, wait
+	}
+
+	sort.Slice(due, func(i, j int) bool { return due[i].pending.NextAttempt.Before(due[j].pending.NextAttempt) })
+	due[0].running = true
+	if len(due) > 1 {
+		q.signal() // for another worker
+	}
+
+	return due[0], 0
+}
+
+// attempt writes the rest of the content of the upload of item to pCloud and saves it.
+func (q *uploadQueue) attempt(ctx context.Context, item *queuedUpload) {
+	u := item.u
+	ctx = logger.WithAttrs(ctx, slog.String("path", item.pending.Path), slog.Uint64("id", u.entry.ID))
+
+	u.lock.Lock()
+	if u.done || !u.entry.Closed {
+		// the upload was abandoned, or its file is written to again: it is queued anew
+		// once closed.
+		q.remove(u.entry.ID)
+		u.lock.Unlock()
+		return
+	}
+
+	md, err := q.fs.completeUpload(ctx, u)
+	if pcloud.IsNotFound(err) {
+		logger.WarnContext(ctx, "file of upload no longer exists: upload abandoned")
+		q.fs.discardUpload(ctx, u)
+	}
+	if err != nil && !u.done {
+		uploaded := u.entry.Offset
+		u.lock.Unlock()
+		q.failed(ctx, item, uploaded, err)
+		return
+	}
+	q.remove(u.entry.ID)
+	saved := u.saved
+	u.lock.Unlock()
+
+	if err == nil && saved != nil {
+		saved(md)
+	}
+}
+
+// failed schedules the next attempt of the upload of item, after a delay that doubles with
+// each failure.
+func (q *uploadQueue) failed(ctx context.Context, item *queuedUpload, uploaded int64, err error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	item.running = false
+	item.pending.Attempts++
+	item.pending.Uploaded = uploaded
+	item.pending.LastError = err.Error()
+
+	delay := uploadRetryMaxDelay
+	if item.pending.Attempts <= 10 {
+		delay = min(uploadRetryMinDelay<<(item.pending.Attempts-1), uploadRetryMaxDelay)
+	}
+	item.pending.NextAttempt = time.Now().Add(delay)
+
+	logger.WarnContext(ctx, "chunked upload failed: will be attempted again", "attempts", item.pending.Attempts, "delay", delay, "error", err)
+}
+
+// retryNow brings forward the next attempt of the uploads that failed, such as once pCloud
+// is reachable again.
+func (q *uploadQueue) retryNow() {
+	q.lock.Lock()
+	now := time.Now()
+	for _, item := range q.items {
+		if !item.running {
+			item.pending.NextAttempt = now
+		}
+	}
+	q.lock.Unlock()
+
+	q.signal()
+}
+
+func (q *uploadQueue) remove(id uint64) {
+	q.lock.Lock()
+	delete(q.items, id)
+	q.lock.Unlock()
+}
+
+// status returns the uploads in the queue, by path.
+func (q *uploadQueue) status() []PendingUpload {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	pending := make([]PendingUpload, 0, len(q.items))
+	for _, item := range q.items {
+		pending = append(pending, item.pending)
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].Path < pending[j].Path })
+
+	return pending
+}
@@ -0,0 +1,141 @@
+package fuse
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/seborama/pcloud-sdk/sdk"
+	"github.com/stretchr/testify/require"
+
+	"github.com/seborama/pcloud-drive/v1/pcloud"
+	"github.com/seborama/pcloud-drive/v1/uploads"
+)
+
+// newFakePCloud returns a pCloud client logged in to a fake pCloud API served by handler,
+// which resolves every host.
+func newFakePCloud(t *testing.T, handler http.HandlerFunc) *pcloud.Client {
+	t.Helper()
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login", "/userinfo":
+			_, _ = w.Write([]byte(`{"result": 0, "auth": "test-auth"}`))
+		default:
+			handler(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	hc := srv.Client()
+	transport := hc.Transport.(*http.Transport).Clone()
+	transport.TLSClientConfig.InsecureSkipVerify = true
+	transport.DialContext = func(ctx context.Context, network, _ string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, network, srv.Listener.Addr().String())
+	}
+	hc.Transport = transport
+
+	c := pcloud.NewClient(hc)
+	require.NoError(t, c.Login(context.Background(), ""))
+
+	return c
+}
+
+func TestUploadQueue(t *testing.T) {
+	var (
+		lock    sync.Mutex
+		content []byte
+		failed  bool
+		saved   = make(chan string, 1)
+	)
+
+	pcClient := newFakePCloud(t, func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		switch r.URL.Path {
+		case "/upload_create":
+			_, _ = w.Write([]byte(`{"result": 0, "uploadid": 42}`))
+
+		case "/upload_write":
+			if !failed {
+				// the network fails once.
+				failed = true
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			data, _ := io.ReadAll(r.Body)
+			content = append(content, data...)
+			_, _ = w.Write([]byte(`{"result": 0}`))
+
+		case "/stat":
+			_, _ = w.Write([]byte(`{"result": 0, "metadata": {"fileid": 7, "parentfolderid": 3, "name": "big.bin"}}`))
+
+		case "/upload_save":
+			require.Equal(t, "3", r.URL.Query().Get("folderid"))
+			require.Equal(t, "big.bin", r.URL.Query().Get("name"))
+			_, _ = fmt.Fprintf(w, `{"result": 0, "metadata": {"fileid": 7, "name": "big.bin", "size": %d}}`, len(content))
+
+		default:
+			t.Errorf("unexpected method %s", r.URL.Path)
+		}
+	})
+
+	journal, err := uploads.Open(t.TempDir())
+	require.NoError(t, err)
+
+	fs := &FS{pcClient: pcClient, uploads: journal, uploadChunkSize: 4}
+	fs.queue = newUploadQueue(fs, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fs.queue.run(ctx)
+
+	entry := journal.NewUpload(7, "docs/big.bin")
+	entry.Closed = true
+	require.NoError(t, os.WriteFile(entry.Staging, []byte("hello, world"), 0o600))
+	require.NoError(t, journal.Put(entry))
+
+	// the upload of a previous run is queued again.
+	fs.replayUploads(ctx)
+
+	require.Eventually(t, func() bool {
+		pending := fs.queue.status()
+		return len(pending) == 1 && pending[0].Attempts == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, "docs/big.bin", fs.queue.status()[0].Path)
+	require.EqualValues(t, 12, fs.queue.status()[0].Size)
+
+	// the failed upload is attempted again.
+	require.Eventually(t, func() bool { return len(fs.queue.status()) == 0 }, 5*time.Second, 10*time.Millisecond)
+
+	lock.Lock()
+	require.Equal(t, "hello, world", string(content))
+	lock.Unlock()
+	require.Empty(t, journal.Uploads())
+	require.NoFileExists(t, entry.Staging)
+
+	// the uploads that the drive started are saved over their file.
+	file := &File{fs: fs, path: "docs/big.bin", fileID: 7}
+	require.NoError(t, file.startUpload(ctx))
+	u := file.lockedUpload()
+	u.saved = func(md *sdk.Metadata) { saved <- md.Name }
+	require.NoError(t, file.writeUpload(ctx, u, 0, []byte("more")))
+	require.NoError(t, file.closeUpload(ctx, u))
+	u.lock.Unlock()
+
+	select {
+	case name := <-saved:
+		require.Equal(t, "big.bin", name)
+	case <-time.After(5 * time.Second):
+		t.Fatal("upload not saved")
+	}
+	require.Nil(t, file.lockedUpload())
+}
@@ -0,0 +1,399 @@
+package fuse
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+
+	"bazil.org/fuse"
+	"github.com/seborama/pcloud-sdk/sdk"
+
+	"github.com/seborama/pcloud-drive/v1/logger"
+	"github.com/seborama/pcloud-drive/v1/metrics"
+)
+
+// writeBufferSize is how much data the write buffer of a handle holds before it is written to
+// pCloud.
+const writeBufferSize = 4 * mb
+
+// writeFunc writes data to a file at offset.
+type writeFunc func(ctx context.Context, offset int64, data []byte) error
+
+// writeBuffer coalesces the writes to a file through a handle, which the kernel sends in
+// small requests, into larger writes to pCloud. The buffered data is written once the buffer
+// is full, or when it is flushed.
+// Data that fails to be written stays in the buffer, and is written again by the next flush:
+// the failure is reported by the flush, such as when the file is closed or synced, unless a
+// later attempt succeeds.
+// It also keeps the size of the file as it is written, so that pCloud is not asked for it
+// after each write.
+type writeBuffer struct {
+	write writeFunc
+	limit int
+
+	// lock protects the fields below. It is held while the data is written, so that the
+	// writes to pCloud happen in order.
+	lock     sync.Mutex
+	segments []segment // the buffered data, in the order it was written
+	buffered int       // bytes in segments
+	fileSize int64
+	written  bool  // whether data was written to pCloud
+	err      error // the failure to write the buffered data, until it is written
+}
+
+// segment is contiguous data buffered at offset.
+type segment struct {
+	offset int64
+	data   []byte
+}
+
+func newWriteBuffer(write writeFunc, limit int, fileSize int64) *writeBuffer {
+	return &writeBuffer{write: write, limit: limit, fileSize: fileSize}
+}
+
+// add buffers data written at offset. The file grows to the end of data when data ends beyond
+// it: the gap, if any, reads as zeros.
+// Should the buffered data keep failing to be written, the writes are refused once twice the
+// limit is buffered, so that the buffer does not grow without bounds.
+func (b *writeBuffer) add(ctx context.Context, offset int64, data []byte) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.err != nil && b.buffered+len(data) > 2*b.limit {
+		if err := b.lockedFlush(ctx); err != nil {
+			return err
+		}
+	}
+
+	// data belongs to the request, which is reused once it is answered: it is copied.
+	if n := len(b.segments); n > 0 && offset == b.segments[n-1].offset+int64(len(b.segments[n-1].data)) {
+		b.segments[n-1].data = append(b.segments[n-1].data, data...)
+	} else {
+		b.segments = append(b.segments, segment{offset: offset, data: append([]byte(nil), data...)})
+	}
+	b.buffered += len(data)
+	b.fileSize = max(b.fileSize, offset+int64(len(data)))
+
+	if b.buffered >= b.limit {
+		// should this fail, the data is written again by the next flush, which reports it.
+		_ = b.lockedFlush(ctx)
+	}
+
+	return nil
+}
+
+// flush writes the buffered data, if any.
+func (b *writeBuffer) flush(ctx context.Context) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.lockedFlush(ctx)
+}
+
+// truncate sets the size of the file, once the buffered data is written. The file itself is
+// truncated on pCloud by its caller.
+func (b *writeBuffer) truncate(ctx context.Context, size int64) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if err := b.lockedFlush(ctx); err != nil {
+		return err
+	}
+	b.fileSize = size
+
+	return nil
+}
+
+// size returns the size of the file, including the buffered data.
+func (b *writeBuffer) size() int64 {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.fileSize
+}
+
+// lockedFlush writes the buffered data, if any, in the order it was written. The data that
+// fails to be written is kept, for the next flush. The caller must hold the lock.
+func (b *writeBuffer) lockedFlush(ctx context.Context) error {
+	for len(b.segments) > 0 {
+		s := b.segments[0]
+		if err := b.write(ctx, s.offset, s.data); err != nil {
+			b.err = err
+			return err
+		}
+		b.written = true
+		b.buffered -= len(s.data)
+		b.segments = b.segments[1:]
+	}
+	b.segments = nil
+	b.err = nil
+
+	return nil
+}
+
+// writes returns the write buffer of the handle of the file, creating it upon first use. Its
+// data is written through the file descriptor of the file, which is opened again if another
+// handle closed it.
+func (f *File) writes(handle fuse.HandleID) *writeBuffer {
+	f.writeLock.Lock()
+	defer f.writeLock.Unlock()
+
+	if b, ok := f.writeBufs[handle]; ok {
+		return b
+	}
+
+	b := newWriteBuffer(f.writeFD, writeBufferSize, int64(f.attrs().Size))
+	if f.writeBufs == nil {
+		f.writeBufs = map[fuse.HandleID]*writeBuffer{}
+	}
+	f.writeBufs[handle] = b
+
+	return b
+}
+
+// writeFD writes data at offset through the file descriptor of the file, which the write
+// buffers of its handles share.
+func (f *File) writeFD(ctx context.Context, offset int64, data []byte) error {
+	f.fdLock.Lock()
+	defer f.fdLock.Unlock()
+
+	if err := f.lockedOpenFD(ctx, sdk.O_WRITE); err != nil {
+		return err
+	}
+	ctx = logger.WithAttrs(ctx, slog.Uint64("fd", f.file.FD))
+
+	if f.file.FD != f.positionFD || offset != f.position {
+		// TODO: is the offset always relative to the beginning of the file??
+		if _, err := f.fs.pcClient.FileSeek(ctx, f.file.FD, uint64(offset), 0); err != nil {
+			logger.ErrorContext(ctx, "FileSeek failed", "error", err)
+			return err
+		}
+		f.positionFD, f.position = f.file.FD, offset
+	}
+
+	fdt, err := f.fs.pcClient.FileWrite(ctx, f.file.FD, data)
+	if err != nil {
+		logger.ErrorContext(ctx, "FileWrite failed", "error", err)
+		return err
+	}
+	f.position += int64(fdt.Bytes)
+	metrics.AddBytes(metrics.DirectionWrite, int(fdt.Bytes))
+	logger.DebugContext(ctx, "buffered writes sent", "offset", offset, "bytes", fdt.Bytes)
+
+	return nil
+}
+
+// buffers returns the write buffers of the handles of the file.
+func (f *File) buffers() []*writeBuffer {
+	f.writeLock.Lock()
+	defer f.writeLock.Unlock()
+
+	buffers := make([]*writeBuffer, 0, len(f.writeBufs))
+	for _, b := range f.writeBufs {
+		buffers = append(buffers, b)
+	}
+
+	return buffers
+}
+
+// flushWrites writes the buffered data of all the handles of the file to pCloud, if any.
+func (f *File) flushWrites(ctx context.Context) error {
+	for _, b := range f.buffers() {
+		if err := b.flush(ctx); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// truncateWrites sets the size of the file in the write buffers of its handles, if any, once
+// their buffered data is written.
+func (f *File) truncateWrites(ctx context.Context, size int64) error {
+	for _, b := range f.buffers() {
+		if err := b.truncate(ctx, size); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// closeWrites writes the buffered data of the handle of the file to pCloud, if any, and
+// discards its write buffer, before the file descriptor is closed. Should that fail, the
+// buffer is kept for the next attempt, unless release is set: the handle is gone then, and
+// so is its data. Once data was written, the size of the file is reconciled with pCloud's and
+// with the writes that the other handles buffer.
+func (f *File) closeWrites(ctx context.Context, handle fuse.HandleID, release bool) error {
+	f.writeLock.Lock()
+	b := f.writeBufs[handle]
+	f.writeLock.Unlock()
+
+	if b == nil {
+		return nil
+	}
+	if err := b.flush(ctx); err != nil {
+		if release {
+			logger.ErrorContext(ctx, "buffered writes lost", "error", err)
+			f.dropWrites(handle)
+		}
+		return err
+	}
+	f.dropWrites(handle)
+	if !b.written {
+		return nil
+	}
+
+	fr, err := f.fs.pcClient.Stat(ctx, sdk.T3FileByID(f.id()))
+	if err != nil {
+		logger.ErrorContext(ctx, "Stat failed", "error", err)
+		// NOTE: the file was successfully written to, but its size cannot be confirmed:
+		// the kernel is asked to drop what it knows of the file.
+		f.fs.invalidateNode(f)
+		return nil
+	}
+	// the data that other handles still buffer may lie beyond pCloud's size.
+	size := fr.Metadata.Size
+	for _, other := range f.buffers() {
+		size = max(size, uint64(other.size()))
+	}
+	if known := f.attrs().Size; size != known {
+		logger.WarnContext(ctx, "file size differs from pCloud's", "size", known, "pcloudSize", fr.Metadata.Size)
+	}
+	f.setSize(size)
+
+	return nil
+}
+
+// dropWrites discards the write buffer of the handle of the file.
+func (f *File) dropWrites(handle fuse.HandleID) {
+	f.writeLock.Lock()
+	delete(f.writeBufs, handle)
+	f.writeLock.Unlock()
+}
+
+// growSize sets the size of the file to size, when it is larger, such as when a handle
+// writes beyond its end.
+func (f *File) growSize(size uint64) {
+	f.metaLock.Lock()
+	defer f.metaLock.Unlock()
+
+	f.Attributes.Size = max(f.Attributes.Size, size)
+}
+
+// fd returns the file descriptor of the file, when it is open.
+func (f *File) fd() (uint64, bool) {
+	f.fdLock.Lock()
+	defer f.fdLock.Unlock()
+
+	if f.file == nil {
+		return 0, false
+	}
+
+	return f.file.FD, true
+}
+
+// openFD opens the file descriptor of the file with flags, unless it is open, and returns it.
+func (f *File) openFD(ctx context.Context, flags uint64) (uint64, error) {
+	f.fdLock.Lock()
+	defer f.fdLock.Unlock()
+
+	if err := f.lockedOpenFD(ctx, flags); err != nil {
+		return 0, err
+	}
+
+	return f.file.FD, nil
+}
+
+// lockedOpenFD opens the file descriptor of the file with flags, unless it is open: a
+// descriptor open for reading only is replaced when flags ask for writing. The caller must
+// hold the fdLock.
+func (f *File) lockedOpenFD(ctx context.Context, flags uint64) error {
+	writable := flags&sdk.O_WRITE != 0
+	if f.file != nil && (f.writable || !writable) {
+		return nil
+	}
+
+	logger.DebugContext(ctx, "opening file descriptor", "flags", flags)
+	file, err := f.fs.pcClient.FileOpen(ctx, flags, sdk.T4FileByID(f.id()))
+	if err != nil {
+		logger.ErrorContext(ctx, "FileOpen failed", "error", err)
+		return err
+	}
+	if f.file != nil {
+		// the reads in progress may still use it: it is closed with the last handle.
+		f.stale = append(f.stale, f.file.FD)
+	}
+	f.file, f.writable = file, writable
+
+	return nil
+}
+
+// truncateFD sets the size of the file on pCloud through its file descriptor, which is
+// opened for the purpose when no handle has it open, and closed again afterwards.
+func (f *File) truncateFD(ctx context.Context, size int64) error {
+	f.fdLock.Lock()
+	defer f.fdLock.Unlock()
+
+	if err := f.lockedOpenFD(ctx, sdk.O_WRITE); err != nil {
+		return err
+	}
+
+	err := f.fs.pcClient.FileTruncate(ctx, f.file.FD, size)
+	if err != nil {
+		logger.ErrorContext(ctx, "FileTruncate failed", "error", err)
+	}
+	if f.handles == 0 {
+		_ = f.lockedCloseFD(ctx)
+	}
+
+	return err
+}
+
+// openHandle counts a handle opened on the file, which shares its file descriptor.
+func (f *File) openHandle() {
+	f.fdLock.Lock()
+	defer f.fdLock.Unlock()
+
+	f.handles++
+}
+
+// releaseFD closes the file descriptor of the file, if open, as the last of its handles is
+// released.
+func (f *File) releaseFD(ctx context.Context) error {
+	f.fdLock.Lock()
+	defer f.fdLock.Unlock()
+
+	f.handles = max(f.handles-1, 0)
+	if f.handles > 0 {
+		return nil
+	}
+
+	return f.lockedCloseFD(ctx)
+}
+
+// lockedCloseFD closes the file descriptors of the file, if open. The caller must hold the
+// fdLock.
+func (f *File) lockedCloseFD(ctx context.Context) error {
+	fds := f.stale
+	if f.file != nil {
+		fds = append(fds, f.file.FD)
+	}
+	if len(fds) == 0 {
+		logger.DebugContext(ctx, "no file handle to close")
+		return nil
+	}
+
+	var closeErr error
+	for _, fd := range fds {
+		if err := f.fs.pcClient.FileClose(ctx, fd); err != nil {
+			logger.ErrorContext(ctx, "FileClose failed", "fd", fd, "error", err)
+			if closeErr == nil {
+				closeErr = err
+			}
+		}
+	}
+	f.file, f.writable, f.stale = nil, false, nil
+
+	return closeErr
+}
@@ -0,0 +1,293 @@
+package fuse
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+
+	"bazil.org/fuse"
+	"github.com/seborama/pcloud-sdk/sdk"
+	"github.com/stretchr/testify/require"
+
+	"github.com/seborama/pcloud-drive/v1/pcloud"
+)
+
+func TestWriteBuffer(t *testing.T) {
+	type write struct {
+		offset int64
+		data   string
+	}
+
+	var writes []write
+	var fail error
+	b := newWriteBuffer(func(_ context.Context, offset int64, data []byte) error {
+		if fail != nil {
+			return fail
+		}
+		writes = append(writes, write{offset, string(data)})
+		return nil
+	}, 8, 0)
+
+	ctx := context.Background()
+
+	// contiguous writes are coalesced.
+	data := []byte("abc")
+	require.NoError(t, b.add(ctx, 0, data))
+	copy(data, "xyz") // the buffer keeps its own copy
+	require.NoError(t, b.add(ctx, 3, []byte("def")))
+	require.Empty(t, writes)
+
+	// a full buffer is written.
+	require.NoError(t, b.add(ctx, 6, []byte("gh")))
+	require.Equal(t, []write{{0, "abcdefgh"}}, writes)
+
+	// the writes that do not follow each other are written in order.
+	require.NoError(t, b.add(ctx, 8, []byte("ij")))
+	require.NoError(t, b.add(ctx, 2, []byte("k")))
+	require.Equal(t, []write{{0, "abcdefgh"}}, writes)
+
+	require.NoError(t, b.flush(ctx))
+	require.NoError(t, b.flush(ctx)) // nothing left to write
+	require.Equal(t, []write{{0, "abcdefgh"}, {8, "ij"}, {2, "k"}}, writes)
+
+	// the data that fails to be written is kept, and written by the next flush, which reports
+	// the failure until then.
+	fail = errors.New("connection reset")
+	require.NoError(t, b.add(ctx, 20, []byte("lmnopqrs"))) // the buffer is full
+	require.NoError(t, b.add(ctx, 0, []byte("t")))
+	require.ErrorIs(t, b.flush(ctx), fail)
+
+	// the writes are refused once twice the limit is buffered.
+	require.NoError(t, b.add(ctx, 1, []byte("uvwxyz")))
+	require.ErrorIs(t, b.add(ctx, 7, []byte("0123")), fail)
+
+	fail = nil
+	require.NoError(t, b.flush(ctx))
+	require.Equal(t, []write{{0, "abcdefgh"}, {8, "ij"}, {2, "k"}, {20, "lmnopqrs"}, {0, "tuvwxyz"}}, writes)
+}
+
+func TestWriteBuffer_Size(t *testing.T) {
+	// remote is the content of the file on pCloud: a write beyond its end fills the gap
+	// with zeros.
+	remote := []byte("0123456789")
+	write := func(_ context.Context, offset int64, data []byte) error {
+		if end := offset + int64(len(data)); end > int64(len(remote)) {
+			remote = append(remote, make([]byte, end-int64(len(remote)))...)
+		}
+		copy(remote[offset:], data)
+		return nil
+	}
+
+	ctx := context.Background()
+	b := newWriteBuffer(write, 4096, int64(len(remote)))
+
+	// overlapping writes within the file leave its size unchanged.
+	require.NoError(t, b.add(ctx, 2, []byte("abc")))
+	require.NoError(t, b.add(ctx, 4, []byte("def")))
+	require.EqualValues(t, 10, b.size())
+
+	// an overlapping write that ends beyond the file grows it.
+	require.NoError(t, b.add(ctx, 8, []byte("ghij")))
+	require.EqualValues(t, 12, b.size())
+
+	// a sparse write grows the file to its end.
+	require.NoError(t, b.add(ctx, 20, []byte("kl")))
+	require.EqualValues(t, 22, b.size())
+
+	// a write within the gap does not.
+	require.NoError(t, b.add(ctx, 15, []byte("m")))
+	require.EqualValues(t, 22, b.size())
+
+	require.NoError(t, b.flush(ctx))
+	require.Equal(t, "01abdef7ghij\x00\x00\x00m\x00\x00\x00\x00kl", string(remote))
+	require.EqualValues(t, len(remote), b.size())
+
+	// a truncation sets the size, and a write beyond it grows the file again.
+	require.NoError(t, b.add(ctx, 22, []byte("no")))
+	require.NoError(t, b.truncate(ctx, 5))
+	require.Equal(t, "no", string(remote[22:])) // the buffered data is written first
+	require.EqualValues(t, 5, b.size())
+	require.NoError(t, b.add(ctx, 7, []byte("p")))
+	require.EqualValues(t, 8, b.size())
+	require.NoError(t, b.add(ctx, 0, []byte("q")))
+	require.EqualValues(t, 8, b.size())
+}
+
+// fakeFile is a file on a fake pCloud, written through a file descriptor.
+type fakeFile struct {
+	lock     sync.Mutex
+	content  []byte
+	position int64
+	failing  bool // whether the writes fail
+	opens    int  // the descriptors opened
+	closes   int  // the descriptors closed
+}
+
+// newFakeFile returns a pCloud client of a fake pCloud that holds f, as the file 7.
+func newFakeFile(t *testing.T, f *fakeFile) *pcloud.Client {
+	return newFakePCloud(t, func(w http.ResponseWriter, r *http.Request) {
+		f.lock.Lock()
+		defer f.lock.Unlock()
+
+		q := r.URL.Query()
+		switch r.URL.Path {
+		case "/file_open":
+			flags, _ := strconv.ParseUint(q.Get("flags"), 10, 64)
+			require.Zero(t, flags&sdk.O_TRUNC, "the content would be lost")
+			f.opens++
+			_, _ = w.Write([]byte(`{"result": 0, "fd": 1, "fileid": 7}`))
+
+		case "/file_seek":
+			f.position, _ = strconv.ParseInt(q.Get("offset"), 10, 64)
+			_, _ = fmt.Fprintf(w, `{"result": 0, "offset": %d}`, f.position)
+
+		case "/file_write":
+			if f.failing {
+				_, _ = w.Write([]byte(`{"result": 5000, "error": "Internal error. Try again later."}`))
+				return
+			}
+			data, err := io.ReadAll(r.Body)
+			require.NoError(t, err)
+			if end := f.position + int64(len(data)); end > int64(len(f.content)) {
+				f.content = append(f.content, make([]byte, end-int64(len(f.content)))...)
+			}
+			copy(f.content[f.position:], data)
+			f.position += int64(len(data))
+			_, _ = fmt.Fprintf(w, `{"result": 0, "bytes": %d}`, len(data))
+
+		case "/file_pread":
+			offset, _ := strconv.ParseInt(q.Get("offset"), 10, 64)
+			count, _ := strconv.ParseInt(q.Get("count"), 10, 64)
+			w.Header().Set("Content-Type", "application/octet-stream")
+			_, _ = w.Write(f.content[offset:min(offset+count, int64(len(f.content)))])
+
+		case "/file_truncate":
+			length, _ := strconv.ParseInt(q.Get("length"), 10, 64)
+			if length > int64(len(f.content)) {
+				f.content = append(f.content, make([]byte, length-int64(len(f.content)))...)
+			}
+			f.content = f.content[:length]
+			_, _ = w.Write([]byte(`{"result": 0}`))
+
+		case "/file_close":
+			f.closes++
+			_, _ = w.Write([]byte(`{"result": 0}`))
+
+		case "/stat":
+			_, _ = fmt.Fprintf(w, `{"result": 0, "metadata": {"fileid": 7, "name": "a.txt", "size": %d}}`, len(f.content))
+
+		default:
+			t.Errorf("unexpected method %s", r.URL.Path)
+		}
+	})
+}
+
+func TestFile_WriteHandles(t *testing.T) {
+	remote := &fakeFile{content: []byte("0123456789")}
+	pfs := &FS{pcClient: newFakeFile(t, remote), readWrite: true, activity: newActivity(), links: newLinkCache()}
+	file := &File{fs: pfs, path: "/a.txt", fileID: 7, Attributes: fuse.Attr{Size: 10}}
+
+	ctx := context.Background()
+	write := func(handle fuse.HandleID, offset int64, data string) {
+		t.Helper()
+		req := &fuse.WriteRequest{Handle: handle, Offset: offset, Data: []byte(data), FileFlags: fuse.OpenWriteOnly}
+		require.NoError(t, file.Write(ctx, req, &fuse.WriteResponse{}))
+	}
+
+	// each handle buffers its writes, and writes them as it is closed.
+	write(1, 0, "ab")
+	write(2, 12, "cd")
+	require.EqualValues(t, 14, file.attrs().Size)
+	require.NoError(t, file.Flush(ctx, &fuse.FlushRequest{Handle: 1}))
+	require.Equal(t, "ab23456789", string(remote.content))
+	require.EqualValues(t, 14, file.attrs().Size) // as the other handle buffers it
+
+	// the writes that fail are reported as the handle is closed, and written again then.
+	remote.lock.Lock()
+	remote.failing = true
+	remote.lock.Unlock()
+	write(2, 4, "ef")
+	require.Error(t, file.Flush(ctx, &fuse.FlushRequest{Handle: 2}))
+	require.Error(t, file.Fsync(ctx, &fuse.FsyncRequest{}))
+
+	remote.lock.Lock()
+	remote.failing = false
+	remote.lock.Unlock()
+	require.NoError(t, file.Flush(ctx, &fuse.FlushRequest{Handle: 2}))
+	require.Equal(t, "ab23ef6789\x00\x00cd", string(remote.content))
+	require.EqualValues(t, 14, file.attrs().Size)
+
+	// a handle released with writes that fail loses them, and reports it.
+	remote.lock.Lock()
+	remote.failing = true
+	remote.lock.Unlock()
+	write(3, 0, "gh")
+	require.Error(t, file.Release(ctx, &fuse.ReleaseRequest{Handle: 3, Flags: fuse.OpenWriteOnly}))
+	require.Empty(t, file.buffers())
+}
+
+func TestFile_Truncate(t *testing.T) {
+	remote := &fakeFile{content: []byte("0123456789")}
+	pfs := &FS{pcClient: newFakeFile(t, remote), readWrite: true, activity: newActivity(), links: newLinkCache()}
+	file := &File{fs: pfs, path: "/a.txt", fileID: 7, Attributes: fuse.Attr{Size: 10}}
+
+	ctx := context.Background()
+	truncate := func(size uint64) {
+		t.Helper()
+		req := &fuse.SetattrRequest{Valid: fuse.SetattrSize, Size: size}
+		require.NoError(t, file.Setattr(ctx, req, &fuse.SetattrResponse{}))
+	}
+
+	// a file that no handle has open is truncated on pCloud all the same.
+	truncate(8)
+	require.Equal(t, "01234567", string(remote.content))
+	require.EqualValues(t, 8, file.attrs().Size)
+
+	// the buffered writes are written before the file is truncated, and the size holds as
+	// the handle is closed.
+	req := &fuse.WriteRequest{Handle: 1, Offset: 6, Data: []byte("abcd"), FileFlags: fuse.OpenWriteOnly}
+	require.NoError(t, file.Write(ctx, req, &fuse.WriteResponse{}))
+	truncate(5)
+	require.Equal(t, "01234", string(remote.content))
+	require.NoError(t, file.Flush(ctx, &fuse.FlushRequest{Handle: 1}))
+	require.Equal(t, "01234", string(remote.content))
+	require.EqualValues(t, 5, file.attrs().Size)
+}
+
+func TestFile_SharedFD(t *testing.T) {
+	remote := &fakeFile{content: []byte("0123456789")}
+	pfs := &FS{pcClient: newFakeFile(t, remote), readWrite: true, activity: newActivity(), links: newLinkCache()}
+	file := &File{fs: pfs, path: "/a.txt", fileID: 7, Attributes: fuse.Attr{Size: 10}}
+
+	ctx := context.Background()
+	for range 2 {
+		_, err := file.Open(ctx, &fuse.OpenRequest{Flags: fuse.OpenReadWrite}, &fuse.OpenResponse{})
+		require.NoError(t, err)
+	}
+	require.Equal(t, 1, remote.opens)
+
+	// the handles share the descriptor, which stays open as one of them is closed.
+	write := &fuse.WriteRequest{Handle: 1, Offset: 0, Data: []byte("ab"), FileFlags: fuse.OpenReadWrite | fuse.OpenTruncate}
+	require.NoError(t, file.Write(ctx, write, &fuse.WriteResponse{}))
+	require.NoError(t, file.Flush(ctx, &fuse.FlushRequest{Handle: 1}))
+	require.NoError(t, file.Release(ctx, &fuse.ReleaseRequest{Handle: 1, Flags: fuse.OpenReadWrite}))
+	_, open := file.fd()
+	require.True(t, open)
+
+	read := &fuse.ReadRequest{Handle: 2, Offset: 0, Size: 4, FileFlags: fuse.OpenReadWrite}
+	resp := &fuse.ReadResponse{}
+	require.NoError(t, file.Read(ctx, read, resp))
+	require.Equal(t, "ab23", string(remote.content[:4]))
+	require.Equal(t, 1, remote.opens)
+
+	// it is closed with the last handle.
+	require.NoError(t, file.Release(ctx, &fuse.ReleaseRequest{Handle: 2, Flags: fuse.OpenReadWrite}))
+	_, open = file.fd()
+	require.False(t, open)
+	require.Equal(t, 1, remote.closes)
+}
@@ -0,0 +1,485 @@
+package fuse
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"github.com/seborama/pcloud-sdk/sdk"
+
+	"github.com/seborama/pcloud-drive/v1/logger"
+	"github.com/seborama/pcloud-drive/v1/metastore"
+	"github.com/seborama/pcloud-drive/v1/metrics"
+)
+
+// Read-only extended attributes that expose the pCloud metadata of files and folders.
+const (
+	XattrFileID      = "user.pcloud.fileid"
+	XattrFolderID    = "user.pcloud.folderid"
+	XattrHash        = "user.pcloud.hash"
+	XattrContentType = "user.pcloud.contenttype"
+	XattrCreated     = "user.pcloud.created"
+	XattrSHA256      = "user.pcloud.sha256"
+	XattrPublink     = "user.pcloud.publink"
+)
+
+// Namespaces of the extended attributes: users can set those of userXattrPrefix, except
+// those of xattrPCloudPrefix.
+const (
+	userXattrPrefix   = "user."
+	xattrPCloudPrefix = "user.pcloud."
+)
+
+// Flags of setxattr(2).
+const (
+	xattrCreate  = 0x1
+	xattrReplace = 0x2
+)
+
+var (
+	fileXattrs = []string{XattrFileID, XattrHash, XattrContentType, XattrCreated, XattrSHA256, XattrPublink, XattrPinned}
+	dirXattrs  = []string{XattrFolderID, XattrCreated, XattrPublink, XattrPinned, XattrState}
+
+	// contentXattrs are the extended attributes of files that change with their content.
+	contentXattrs = []string{XattrHash, XattrSHA256}
+)
+
+// ensure interfaces conpliance
+var (
+	_ fs.NodeGetxattrer    = (*Dir)(nil)
+	_ fs.NodeListxattrer   = (*Dir)(nil)
+	_ fs.NodeSetxattrer    = (*Dir)(nil)
+	_ fs.NodeRemovexattrer = (*Dir)(nil)
+	_ fs.NodeGetxattrer    = (*File)(nil)
+	_ fs.NodeListxattrer   = (*File)(nil)
+	_ fs.NodeSetxattrer    = (*File)(nil)
+	_ fs.NodeRemovexattrer = (*File)(nil)
+)
+
+// xattrCache holds the extended attributes of a node once they are computed.
+// A nil value records that the attribute does not exist.
+type xattrCache struct {
+	lock   sync.Mutex
+	values map[string][]byte
+}
+
+func (x *xattrCache) get(name string) ([]byte, bool) {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	value, ok := x.values[name]
+	metrics.CacheLookup("xattrs", ok)
+	return value, ok
+}
+
+func (x *xattrCache) set(values map[string][]byte) {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	if x.values == nil {
+		x.values = map[string][]byte{}
+	}
+	for name, value := range values {
+		x.values[name] = value
+	}
+}
+
+// absent reports whether the attribute name is known not to exist.
+func (x *xattrCache) absent(name string) bool {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	value, ok := x.values[name]
+	return ok && value == nil
+}
+
+// reset drops the cached attributes, such as when the file is replaced by another.
+func (x *xattrCache) reset() {
+	x.lock.Lock()
+	x.values = nil
+	x.lock.Unlock()
+}
+
+// resetContent drops the cached attributes that change with the content of the file.
+func (x *xattrCache) resetContent() {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	for _, name := range contentXattrs {
+		delete(x.values, name)
+	}
+}
+
+// metadataXattrs returns the extended attributes found in the metadata md.
+func metadataXattrs(md *sdk.Metadata) map[string][]byte {
+	values := map[string][]byte{
+		XattrCreated: nil,
+	}
+	if md.Created != nil {
+		values[XattrCreated] = []byte(md.Created.UTC().Format(time.RFC3339))
+	}
+	if !md.IsFolder {
+		values[XattrHash] = []byte(strconv.FormatUint(md.Hash, 10))
+		values[XattrContentType] = []byte(md.ContentType)
+	}
+	return values
+}
+
+// publink returns the public link of the folder or file id, or nil if it has none.
+func (fs *FS) publink(ctx context.Context, folder bool, id uint64) ([]byte, error) {
+	links, err := fs.pcClient.ListPublinks(ctx)
+	if err != nil {
+		logger.ErrorContext(ctx, "ListPublinks failed", "error", err)
+		return nil, err
+	}
+
+	for _, link := range links {
+		if folder && link.IsFolder && link.FolderID == id ||
+			!folder && !link.IsFolder && link.FileID == id {
+			return []byte(link.Link), nil
+		}
+	}
+
+	return nil, nil
+}
+
+// userXattr returns the value of the user extended attribute name of the node key.
+func (fs *FS) userXattr(key metastore.Key, name string) ([]byte, error) {
+	if fs.metadata == nil || !strings.HasPrefix(name, userXattrPrefix) {
+		return nil, fuse.ErrNoXattr
+	}
+
+	value, ok := fs.metadata.Get(key, name)
+	if !ok {
+		return nil, fuse.ErrNoXattr
+	}
+	return value, nil
+}
+
+// checkUserXattr returns an error if the extended attribute name cannot be changed by users.
+func (fs *FS) checkUserXattr(name string) error {
+	if !fs.readWrite {
+		return syscall.EROFS
+	}
+	if strings.HasPrefix(name, xattrPCloudPrefix) {
+		// the pCloud metadata is read-only.
+		return syscall.EPERM
+	}
+	if !strings.HasPrefix(name, userXattrPrefix) || fs.metadata == nil {
+		return fuse.Errno(syscall.ENOTSUP)
+	}
+	return nil
+}
+
+// setUserXattr sets the user extended attribute of the node key as req asks.
+func (fs *FS) setUserXattr(ctx context.Context, key metastore.Key, req *fuse.SetxattrRequest) error {
+	if err := fs.checkUserXattr(req.Name); err != nil {
+		return err
+	}
+
+	_, exists := fs.metadata.Get(key, req.Name)
+	if req.Flags&xattrCreate != 0 && exists {
+		return fuse.Errno(syscall.EEXIST)
+	}
+	if req.Flags&xattrReplace != 0 && !exists {
+		return fuse.ErrNoXattr
+	}
+
+	// the request buffer is not ours to keep.
+	if err := fs.metadata.Set(key, req.Name, append([]byte{}, req.Xattr...)); err != nil {
+		logger.ErrorContext(ctx, "metadata store Set failed", "name", req.Name, "error", err)
+		return err
+	}
+
+	return nil
+}
+
+// removeUserXattr removes the user extended attribute name of the node key.
+func (fs *FS) removeUserXattr(ctx context.Context, key metastore.Key, name string) error {
+	if err := fs.checkUserXattr(name); err != nil {
+		return err
+	}
+
+	removed, err := fs.metadata.Remove(key, name)
+	if err != nil {
+		logger.ErrorContext(ctx, "metadata store Remove failed", "name", name, "error", err)
+		return err
+	}
+	if !removed {
+		return fuse.ErrNoXattr
+	}
+
+	return nil
+}
+
+// unpinXattr returns the error of the removal of XattrPinned from a node, given the outcome
+// of unpinning it: there is nothing to remove when the node was not pinned itself.
+func unpinXattr(removed bool, err error) error {
+	if err != nil {
+		return err
+	}
+	if !removed {
+		return fuse.ErrNoXattr
+	}
+	return nil
+}
+
+// forget drops the metadata stored locally for the node key, once it is purged from pCloud.
+func (fs *FS) forget(ctx context.Context, key metastore.Key) {
+	if fs.metadata == nil {
+		return
+	}
+	if err := fs.metadata.Delete(key); err != nil {
+		logger.WarnContext(ctx, "metadata store Delete failed", "key", key, "error", err)
+	}
+}
+
+// listXattrs appends to resp those of names that the node may have, followed by its user
+// extended attributes of the node key. The values are not computed, which could take
+// requests to pCloud: only the names that absent reports are left out.
+func (fs *FS) listXattrs(key metastore.Key, names []string, absent func(string) bool, resp *fuse.ListxattrResponse) {
+	for _, name := range names {
+		if !absent(name) {
+			resp.Append(name)
+		}
+	}
+
+	if fs.metadata != nil {
+		for _, name := range fs.metadata.Names(key) {
+			if strings.HasPrefix(name, userXattrPrefix) {
+				resp.Append(name)
+			}
+		}
+	}
+}
+
+func (d *Dir) Getxattr(ctx context.Context, req *fuse.GetxattrRequest, resp *fuse.GetxattrResponse) (err error) {
+	ctx, end := d.fs.startOp(ctx, "Getxattr", d.path)
+	defer end(&err)
+	ctx = d.logContext(ctx)
+	logger.DebugContext(ctx, "entering", slog.Group("args", "req", req))
+
+	resp.Xattr, err = d.xattr(ctx, req.Name)
+	return err
+}
+
+func (d *Dir) Listxattr(ctx context.Context, req *fuse.ListxattrRequest, resp *fuse.ListxattrResponse) (err error) {
+	ctx, end := d.fs.startOp(ctx, "Listxattr", d.path)
+	defer end(&err)
+	ctx = d.logContext(ctx)
+	logger.DebugContext(ctx, "entering", slog.Group("args", "req", req))
+
+	d.fs.listXattrs(metastore.FolderKey(d.folderID), dirXattrs, d.absentXattr, resp)
+	return nil
+}
+
+func (d *Dir) Setxattr(ctx context.Context, req *fuse.SetxattrRequest) (err error) {
+	ctx, end := d.fs.startOp(ctx, "Setxattr", d.path)
+	defer end(&err)
+	ctx = d.logContext(ctx)
+	logger.DebugContext(ctx, "entering", slog.Group("args", "req", req))
+
+	if req.Name == XattrPinned {
+		return d.fs.pin(ctx, d)
+	}
+	return d.fs.setUserXattr(ctx, metastore.FolderKey(d.folderID), req)
+}
+
+func (d *Dir) Removexattr(ctx context.Context, req *fuse.RemovexattrRequest) (err error) {
+	ctx, end := d.fs.startOp(ctx, "Removexattr", d.path)
+	defer end(&err)
+	ctx = d.logContext(ctx)
+	logger.DebugContext(ctx, "entering", slog.Group("args", "req", req))
+
+	if req.Name == XattrPinned {
+		return unpinXattr(d.fs.unpin(ctx, d))
+	}
+	return d.fs.removeUserXattr(ctx, metastore.FolderKey(d.folderID), req.Name)
+}
+
+// absentXattr reports whether the extended attribute name is known not to exist, without
+// asking pCloud.
+func (d *Dir) absentXattr(name string) bool {
+	switch name {
+	case XattrState:
+		return d != d.fs.root
+	case XattrPinned:
+		value, _ := d.fs.pinnedXattr(true, d.folderID)
+		return value == nil
+	default:
+		return d.xattrs.absent(name)
+	}
+}
+
+// xattr returns the value of the extended attribute name, computing it if it is not cached.
+func (d *Dir) xattr(ctx context.Context, name string) ([]byte, error) {
+	if name == XattrState {
+		if d != d.fs.root {
+			return nil, fuse.ErrNoXattr
+		}
+		return d.fs.state(), nil
+	}
+	if name == XattrPinned {
+		// the pins change without the node knowing: never cached.
+		return d.fs.pinnedXattr(true, d.folderID)
+	}
+
+	value, ok := d.xattrs.get(name)
+	if !ok {
+		switch name {
+		case XattrFolderID:
+			value = []byte(strconv.FormatUint(d.folderID, 10))
+			d.xattrs.set(map[string][]byte{name: value})
+
+		case XattrCreated:
+			fsList, err := d.fs.pcClient.ListFolder(ctx, sdk.T1FolderByID(d.folderID), false, false, true, true)
+			if err != nil {
+				logger.ErrorContext(ctx, "ListFolder failed", "folderID", d.folderID, "error", err)
+				return nil, err
+			}
+			values := metadataXattrs(fsList.Metadata)
+			d.xattrs.set(values)
+			value = values[name]
+
+		case XattrPublink:
+			var err error
+			if value, err = d.fs.publink(ctx, true, d.folderID); err != nil {
+				return nil, err
+			}
+			d.xattrs.set(map[string][]byte{name: value})
+
+		default:
+			return d.fs.userXattr(metastore.FolderKey(d.folderID), name)
+		}
+	}
+
+	if value == nil {
+		return nil, fuse.ErrNoXattr
+	}
+	return value, nil
+}
+
+func (f *File) Getxattr(ctx context.Context, req *fuse.GetxattrRequest, resp *fuse.GetxattrResponse) (err error) {
+	ctx, end := f.fs.startOp(ctx, "Getxattr", f.path)
+	defer end(&err)
+	ctx = f.logContext(ctx)
+	logger.DebugContext(ctx, "entering", slog.Group("args", "req", req))
+
+	resp.Xattr, err = f.xattr(ctx, req.Name)
+	return err
+}
+
+func (f *File) Listxattr(ctx context.Context, req *fuse.ListxattrRequest, resp *fuse.ListxattrResponse) (err error) {
+	ctx, end := f.fs.startOp(ctx, "Listxattr", f.path)
+	defer end(&err)
+	ctx = f.logContext(ctx)
+	logger.DebugContext(ctx, "entering", slog.Group("args", "req", req))
+
+	f.fs.listXattrs(metastore.FileKey(f.id()), fileXattrs, f.absentXattr, resp)
+	return nil
+}
+
+func (f *File) Setxattr(ctx context.Context, req *fuse.SetxattrRequest) (err error) {
+	ctx, end := f.fs.startOp(ctx, "Setxattr", f.path)
+	defer end(&err)
+	ctx = f.logContext(ctx)
+	logger.DebugContext(ctx, "entering", slog.Group("args", "req", req))
+
+	if f.id() == 0 {
+		// the file is not on pCloud yet: its attributes could not be kept by its ID.
+		return errOffline
+	}
+	if req.Name == XattrPinned {
+		return f.fs.pin(ctx, f)
+	}
+	return f.fs.setUserXattr(ctx, metastore.FileKey(f.id()), req)
+}
+
+func (f *File) Removexattr(ctx context.Context, req *fuse.RemovexattrRequest) (err error) {
+	ctx, end := f.fs.startOp(ctx, "Removexattr", f.path)
+	defer end(&err)
+	ctx = f.logContext(ctx)
+	logger.DebugContext(ctx, "entering", slog.Group("args", "req", req))
+
+	if f.id() == 0 {
+		// the file is not on pCloud yet: it has no attributes kept by its ID.
+		return fuse.ErrNoXattr
+	}
+	if req.Name == XattrPinned {
+		return unpinXattr(f.fs.unpin(ctx, f))
+	}
+	return f.fs.removeUserXattr(ctx, metastore.FileKey(f.id()), req.Name)
+}
+
+// absentXattr reports whether the extended attribute name is known not to exist, without
+// asking pCloud.
+func (f *File) absentXattr(name string) bool {
+	if name == XattrPinned {
+		value, _ := f.fs.pinnedXattr(false, f.id())
+		return value == nil
+	}
+	return f.xattrs.absent(name)
+}
+
+// xattr returns the value of the extended attribute name, computing it if it is not cached.
+func (f *File) xattr(ctx context.Context, name string) ([]byte, error) {
+	if name == XattrPinned {
+		// the pins change without the node knowing: never cached.
+		return f.fs.pinnedXattr(false, f.id())
+	}
+
+	value, ok := f.xattrs.get(name)
+	if !ok {
+		switch name {
+		case XattrFileID:
+			value = []byte(strconv.FormatUint(f.id(), 10))
+			f.xattrs.set(map[string][]byte{name: value})
+
+		case XattrHash, XattrContentType, XattrCreated:
+			fr, err := f.fs.pcClient.Stat(ctx, sdk.T3FileByID(f.id()))
+			if err != nil {
+				logger.ErrorContext(ctx, "Stat failed", "fileID", f.id(), "error", err)
+				return nil, err
+			}
+			values := metadataXattrs(&fr.Metadata)
+			f.xattrs.set(values)
+			value = values[name]
+
+		case XattrSHA256:
+			cs, err := f.fs.pcClient.ChecksumFile(ctx, sdk.T3FileByID(f.id()))
+			if err != nil {
+				logger.ErrorContext(ctx, "ChecksumFile failed", "fileID", f.id(), "error", err)
+				return nil, err
+			}
+			values := metadataXattrs(&cs.Metadata)
+			values[name] = nil
+			if cs.SHA256 != "" {
+				// pCloud only computes SHA-256 checksums in its European data centres.
+				values[name] = []byte(cs.SHA256)
+			}
+			f.xattrs.set(values)
+			value = values[name]
+
+		case XattrPublink:
+			var err error
+			if value, err = f.fs.publink(ctx, false, f.id()); err != nil {
+				return nil, err
+			}
+			f.xattrs.set(map[string][]byte{name: value})
+
+		default:
+			return f.fs.userXattr(metastore.FileKey(f.id()), name)
+		}
+	}
+
+	if value == nil {
+		return nil, fuse.ErrNoXattr
+	}
+	return value, nil
+}
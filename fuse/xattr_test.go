@@ -0,0 +1,121 @@
+package fuse
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"bazil.org/fuse"
+	"github.com/stretchr/testify/require"
+
+	"github.com/seborama/pcloud-drive/v1/metastore"
+)
+
+func TestXattrs(t *testing.T) {
+	var (
+		lock     sync.Mutex
+		requests = map[string]int{}
+		hash     = 111
+	)
+
+	pcClient := newFakePCloud(t, func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		requests[r.URL.Path]++
+		switch r.URL.Path {
+		case "/stat":
+			require.Equal(t, "11", r.URL.Query().Get("fileid"))
+			_, _ = fmt.Fprintf(w, `{"result": 0, "metadata": {"fileid": 11, "name": "a.txt", "hash": %d, "contenttype": "text/plain"}}`, hash)
+
+		case "/listpublinks":
+			_, _ = w.Write([]byte(`{"result": 0, "publinks": [
+				{"linkid": 1, "link": "https://u.pcloud.link/root", "isfolder": true, "folderid": 0},
+				{"linkid": 2, "link": "https://u.pcloud.link/a", "isfolder": false, "fileid": 11}
+			]}`))
+
+		default:
+			t.Errorf("unexpected method %s", r.URL.Path)
+		}
+	})
+
+	fs := &FS{pcClient: pcClient, activity: newActivity()}
+	root := &Dir{fs: fs, path: "/", folderID: 0}
+	fs.root = root
+	sub := &Dir{fs: fs, path: "/sub", folderID: 5}
+	file := &File{fs: fs, path: "/a.txt", fileID: 11}
+	ctx := context.Background()
+
+	names := func(resp *fuse.ListxattrResponse) []string {
+		return strings.Split(strings.TrimSuffix(string(resp.Xattr), "\x00"), "\x00")
+	}
+
+	// the names are listed without asking pCloud for the values.
+	resp := &fuse.ListxattrResponse{}
+	require.NoError(t, file.Listxattr(ctx, &fuse.ListxattrRequest{}, resp))
+	require.Equal(t, []string{XattrFileID, XattrHash, XattrContentType, XattrCreated, XattrSHA256, XattrPublink}, names(resp))
+	resp = &fuse.ListxattrResponse{}
+	require.NoError(t, root.Listxattr(ctx, &fuse.ListxattrRequest{}, resp))
+	require.Equal(t, []string{XattrFolderID, XattrCreated, XattrPublink, XattrState}, names(resp))
+	resp = &fuse.ListxattrResponse{}
+	require.NoError(t, sub.Listxattr(ctx, &fuse.ListxattrRequest{}, resp))
+	require.Equal(t, []string{XattrFolderID, XattrCreated, XattrPublink}, names(resp))
+	require.Empty(t, requests)
+
+	// the root has a public link too.
+	value, err := root.xattr(ctx, XattrPublink)
+	require.NoError(t, err)
+	require.Equal(t, "https://u.pcloud.link/root", string(value))
+
+	// an attribute found missing is no longer listed.
+	_, err = sub.xattr(ctx, XattrPublink)
+	require.ErrorIs(t, err, fuse.ErrNoXattr)
+	resp = &fuse.ListxattrResponse{}
+	require.NoError(t, sub.Listxattr(ctx, &fuse.ListxattrRequest{}, resp))
+	require.Equal(t, []string{XattrFolderID, XattrCreated}, names(resp))
+
+	// a change of content only drops the attributes that depend on it.
+	value, err = file.xattr(ctx, XattrHash)
+	require.NoError(t, err)
+	require.Equal(t, "111", string(value))
+	_, err = file.xattr(ctx, XattrPublink)
+	require.NoError(t, err)
+	require.Equal(t, map[string]int{"/stat": 1, "/listpublinks": 3}, requests)
+
+	lock.Lock()
+	hash = 112
+	lock.Unlock()
+	file.xattrs.resetContent()
+
+	value, err = file.xattr(ctx, XattrHash)
+	require.NoError(t, err)
+	require.Equal(t, "112", string(value))
+	value, err = file.xattr(ctx, XattrContentType)
+	require.NoError(t, err)
+	require.Equal(t, "text/plain", string(value))
+	value, err = file.xattr(ctx, XattrPublink)
+	require.NoError(t, err)
+	require.Equal(t, "https://u.pcloud.link/a", string(value))
+	require.Equal(t, map[string]int{"/stat": 2, "/listpublinks": 3}, requests)
+}
+
+func TestFile_XattrsNotOnPCloud(t *testing.T) {
+	store, err := metastore.Open(filepath.Join(t.TempDir(), "metadata.json"))
+	require.NoError(t, err)
+	require.NoError(t, store.Set(metastore.FileKey(0), "user.note", []byte("kept")))
+
+	fs := &FS{metadata: store, activity: newActivity()}
+	file := &File{fs: fs, path: "/new.txt"} // created offline, awaiting its upload
+	ctx := context.Background()
+
+	// the attributes kept for ID 0 belong to no file.
+	require.ErrorIs(t, file.Setxattr(ctx, &fuse.SetxattrRequest{Name: "user.note", Xattr: []byte("mine")}), errOffline)
+	require.ErrorIs(t, file.Removexattr(ctx, &fuse.RemovexattrRequest{Name: "user.note"}), fuse.ErrNoXattr)
+	value, ok := store.Get(metastore.FileKey(0), "user.note")
+	require.True(t, ok)
+	require.Equal(t, "kept", string(value))
+}
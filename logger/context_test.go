@@ -0,0 +1,27 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithAttrs(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := slog.New(contextHandler{slog.NewJSONHandler(buf, nil)})
+
+	ctx := WithAttrs(context.Background(), slog.Uint64("fuse.req", 12), slog.String("path", "/a"))
+	ctx = WithAttrs(ctx, slog.String("path", "/a/b"), slog.Uint64("fd", 3))
+
+	l.InfoContext(ctx, "hello")
+
+	line := map[string]any{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &line))
+	require.EqualValues(t, 12, line["fuse.req"])
+	require.Equal(t, "/a/b", line["path"])
+	require.EqualValues(t, 3, line["fd"])
+}
@@ -0,0 +1,178 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupTimeFormat is the timestamp appended to the name of rotated log files.
+const backupTimeFormat = "20060102T150405.000"
+
+// RotatingFile is an io.WriteCloser that rotates the underlying file when it grows
+// beyond MaxSize or when it has been in use for longer than MaxAge.
+// Rotated files are renamed with a timestamp suffix and only the newest MaxBackups
+// are retained.
+type RotatingFile struct {
+	path       string
+	maxSize    int64
+	maxAge     time.Duration
+	maxBackups int
+
+	lock     sync.Mutex
+	file     *os.File
+	size     int64
+	openedAt time.Time
+
+	now func() time.Time
+}
+
+// NewRotatingFile opens (or creates) the log file at path.
+// A zero maxSize, maxAge or maxBackups disables the corresponding limit.
+func NewRotatingFile(path string, maxSize int64, maxAge time.Duration, maxBackups int) (*RotatingFile, error) {
+	rf := &RotatingFile{
+		path:       path,
+		maxSize:    maxSize,
+		maxAge:     maxAge,
+		maxBackups: maxBackups,
+		now:        time.Now,
+	}
+
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+
+	return rf, nil
+}
+
+// Write implements io.Writer.
+func (rf *RotatingFile) Write(p []byte) (int, error) {
+	rf.lock.Lock()
+	defer rf.lock.Unlock()
+
+	if rf.file == nil {
+		return 0, os.ErrClosed
+	}
+
+	if rf.mustRotate(int64(len(p))) {
+		if err := rf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := rf.file.Write(p)
+	rf.size += int64(n)
+
+	return n, err
+}
+
+// Close implements io.Closer.
+func (rf *RotatingFile) Close() error {
+	rf.lock.Lock()
+	defer rf.lock.Unlock()
+
+	if rf.file == nil {
+		return nil
+	}
+
+	err := rf.file.Close()
+	rf.file = nil
+
+	return err
+}
+
+func (rf *RotatingFile) mustRotate(writeLen int64) bool {
+	if rf.size == 0 {
+		// never rotate an empty file, even if a single write exceeds maxSize.
+		return false
+	}
+	if rf.maxSize > 0 && rf.size+writeLen > rf.maxSize {
+		return true
+	}
+	if rf.maxAge > 0 && rf.now().Sub(rf.openedAt) >= rf.maxAge {
+		return true
+	}
+	return false
+}
+
+func (rf *RotatingFile) open() error {
+	f, err := os.OpenFile(rf.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return fmt.Errorf("open log file: %w", err)
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return fmt.Errorf("stat log file: %w", err)
+	}
+
+	rf.file = f
+	rf.size = fi.Size()
+	rf.openedAt = rf.now()
+	if rf.size > 0 {
+		// an existing log file is as old as its last modification at best.
+		rf.openedAt = fi.ModTime()
+	}
+
+	return nil
+}
+
+func (rf *RotatingFile) rotate() error {
+	if err := rf.file.Close(); err != nil {
+		return fmt.Errorf("close log file: %w", err)
+	}
+	rf.file = nil
+
+	backup := rf.path + "." + rf.now().Format(backupTimeFormat)
+	if err := os.Rename(rf.path, backup); err != nil {
+		return fmt.Errorf("rename log file: %w", err)
+	}
+
+	if err := rf.open(); err != nil {
+		return err
+	}
+
+	return rf.prune()
+}
+
+// prune removes the oldest backups in excess of maxBackups.
+func (rf *RotatingFile) prune() error {
+	if rf.maxBackups <= 0 {
+		return nil
+	}
+
+	backups, err := filepath.Glob(rf.path + ".*")
+	if err != nil {
+		return err
+	}
+	backups = filterBackups(rf.path, backups)
+	if len(backups) <= rf.maxBackups {
+		return nil
+	}
+
+	// the timestamp suffix sorts chronologically.
+	sort.Strings(backups)
+	for _, b := range backups[:len(backups)-rf.maxBackups] {
+		if err := os.Remove(b); err != nil {
+			return fmt.Errorf("remove old log file: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func filterBackups(path string, candidates []string) []string {
+	backups := make([]string, 0, len(candidates))
+	for _, c := range candidates {
+		suffix := strings.TrimPrefix(c, path+".")
+		if _, err := time.Parse(backupTimeFormat, suffix); err == nil {
+			backups = append(backups, c)
+		}
+	}
+	return backups
+}
@@ -0,0 +1,72 @@
+package logger
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRotatingFile_RotatesOnSize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "drive.log")
+
+	rf, err := NewRotatingFile(path, 10, 0, 2)
+	require.NoError(t, err)
+	defer func() { _ = rf.Close() }()
+
+	clock := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	rf.now = func() time.Time {
+		clock = clock.Add(time.Second)
+		return clock
+	}
+
+	for i := 0; i < 4; i++ {
+		_, err = rf.Write([]byte("12345678\n"))
+		require.NoError(t, err)
+	}
+
+	backups, err := filepath.Glob(path + ".*")
+	require.NoError(t, err)
+	require.Len(t, backups, 2)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "12345678\n", string(data))
+}
+
+func TestRotatingFile_RotatesOnAge(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "drive.log")
+
+	clock := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	rf, err := NewRotatingFile(path, 0, time.Hour, 0)
+	require.NoError(t, err)
+	defer func() { _ = rf.Close() }()
+	rf.now = func() time.Time { return clock }
+	rf.openedAt = clock
+
+	_, err = rf.Write([]byte("first\n"))
+	require.NoError(t, err)
+
+	clock = clock.Add(30 * time.Minute)
+	_, err = rf.Write([]byte("second\n"))
+	require.NoError(t, err)
+
+	clock = clock.Add(30 * time.Minute)
+	_, err = rf.Write([]byte("third\n"))
+	require.NoError(t, err)
+
+	backups, err := filepath.Glob(path + ".*")
+	require.NoError(t, err)
+	require.Len(t, backups, 1)
+
+	data, err := os.ReadFile(backups[0])
+	require.NoError(t, err)
+	require.Equal(t, "first\nsecond\n", string(data))
+
+	data, err = os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "third\n", string(data))
+}
@@ -3,65 +3,176 @@ package logger
 import (
 	"context"
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"time"
 )
 
 const RFC3339Milli = "2006-01-02T15:04:05.999Z07:00"
 
-func LoggerSetup() {
+// Supported log output formats.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Config holds the logging configuration.
+type Config struct {
+	// Level is one of "debug", "info", "warn" or "error".
+	Level string
+	// Format is one of FormatText or FormatJSON.
+	Format string
+	// File is the path of the log file. When empty, logs are written to stdout.
+	File string
+	// MaxSize is the size in bytes beyond which the log file is rotated. Zero disables it.
+	MaxSize int64
+	// MaxAge is the age beyond which the log file is rotated. Zero disables it.
+	MaxAge time.Duration
+	// MaxBackups is the number of rotated log files to keep. Zero keeps them all.
+	MaxBackups int
+}
+
+// level is the minimum level that is logged. It can be changed at runtime.
+// Its zero value is slog.LevelInfo.
+var level = new(slog.LevelVar)
+
+// output is the current log destination, closed by Close when it is a file.
+var output io.Writer = os.Stdout
+
+// LoggerSetup configures the default slog logger as per cfg.
+func LoggerSetup(cfg Config) error {
+	if err := SetLevel(cfg.Level); err != nil {
+		return err
+	}
+
+	format := strings.ToLower(cfg.Format)
+	if format == "" {
+		format = FormatText
+	}
+	if format != FormatText && format != FormatJSON {
+		return fmt.Errorf("unknown log format: '%s'", cfg.Format)
+	}
+
+	var w io.Writer = os.Stdout
+	if cfg.File != "" {
+		f, err := NewRotatingFile(cfg.File, cfg.MaxSize, cfg.MaxAge, cfg.MaxBackups)
+		if err != nil {
+			return err
+		}
+		w = f
+	}
+
+	// the time of day is enough on the console but log files and JSON output outlive the day.
+	timeFormat := time.TimeOnly
+	if cfg.File != "" || format == FormatJSON {
+		timeFormat = RFC3339Milli
+	}
+
 	replace := func(groups []string, a slog.Attr) slog.Attr {
 		// change the time format.
 		if a.Key == slog.TimeKey && len(groups) == 0 {
-			return slog.String(a.Key, a.Value.Time().Format(time.TimeOnly))
+			return slog.String(a.Key, a.Value.Time().Format(timeFormat))
 		}
 		return a
 	}
 
-	slog.SetDefault(slog.New(slog.NewTextHandler(
-		os.Stdout,
-		&slog.HandlerOptions{
-			AddSource:   false,
-			Level:       slog.LevelInfo,
-			ReplaceAttr: replace,
-		},
-	)))
-	// slog.SetDefault(slog.New(slog.NewJSONHandler(
-	// 	os.Stdout,
-	// 	&slog.HandlerOptions{
-	// 		AddSource:   false,
-	// 		Level:       slog.LevelInfo,
-	// 		ReplaceAttr: replace,
-	// 	},
-	// )))
+	opts := &slog.HandlerOptions{
+		AddSource:   false,
+		Level:       level,
+		ReplaceAttr: replace,
+	}
+
+	var handler slog.Handler = slog.NewTextHandler(w, opts)
+	if format == FormatJSON {
+		handler = slog.NewJSONHandler(w, opts)
+	}
+
+	_ = Close()
+	output = w
+
+	slog.SetDefault(slog.New(contextHandler{handler}))
+
+	return nil
+}
+
+// SetLevel changes the minimum level that is logged.
+// An empty name is equivalent to "info".
+func SetLevel(name string) error {
+	if name == "" {
+		level.Set(slog.LevelInfo)
+		return nil
+	}
+
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(name)); err != nil {
+		return fmt.Errorf("unknown log level: '%s'", name)
+	}
+	level.Set(l)
+
+	return nil
+}
+
+// Level returns the minimum level that is logged.
+func Level() slog.Level {
+	return level.Level()
+}
+
+// Close releases the log file, if any.
+func Close() error {
+	if c, ok := output.(io.Closer); ok && output != os.Stdout {
+		output = os.Stdout
+		return c.Close()
+	}
+	return nil
 }
 
 // Debugf wraps slog.Log with caller info from the stacktrace.
 func Debugf(msg string, args ...any) {
-	log(slog.LevelDebug, msg, args...)
+	log(context.Background(), slog.LevelDebug, msg, args...)
 }
 
 // Infof wraps slog.Log with caller info from the stacktrace.
 func Infof(msg string, args ...any) {
-	log(slog.LevelInfo, msg, args...)
+	log(context.Background(), slog.LevelInfo, msg, args...)
 }
 
 // Warnf wraps slog.Log with caller info from the stacktrace.
 func Warnf(msg string, args ...any) {
-	log(slog.LevelWarn, msg, args...)
+	log(context.Background(), slog.LevelWarn, msg, args...)
 }
 
 // Errorf wraps slog.Log with caller info from the stacktrace.
 func Errorf(msg string, args ...any) {
-	log(slog.LevelError, msg, args...)
+	log(context.Background(), slog.LevelError, msg, args...)
+}
+
+// DebugContext is like Debugf and also logs the attributes carried by ctx.
+func DebugContext(ctx context.Context, msg string, args ...any) {
+	log(ctx, slog.LevelDebug, msg, args...)
+}
+
+// InfoContext is like Infof and also logs the attributes carried by ctx.
+func InfoContext(ctx context.Context, msg string, args ...any) {
+	log(ctx, slog.LevelInfo, msg, args...)
+}
+
+// WarnContext is like Warnf and also logs the attributes carried by ctx.
+func WarnContext(ctx context.Context, msg string, args ...any) {
+	log(ctx, slog.LevelWarn, msg, args...)
+}
+
+// ErrorContext is like Errorf and also logs the attributes carried by ctx.
+func ErrorContext(ctx context.Context, msg string, args ...any) {
+	log(ctx, slog.LevelError, msg, args...)
 }
 
-func log(level slog.Level, msg string, args ...any) {
+func log(ctx context.Context, level slog.Level, msg string, args ...any) {
 	logger := slog.Default()
-	if !logger.Enabled(context.Background(), slog.LevelInfo) {
+	if !logger.Enabled(ctx, level) {
 		return
 	}
 	var pcs [1]uintptr
@@ -70,5 +181,5 @@ func log(level slog.Level, msg string, args ...any) {
 	f, _ := fs.Next()
 	caller := fmt.Sprintf("%s/%s:%d", filepath.Base(f.File), filepath.Base(f.Function), f.Line)
 
-	slog.Log(context.Background(), level, msg, append([]any{slog.String("caller", caller)}, args...)...)
+	logger.Log(ctx, level, msg, append([]any{slog.String("caller", caller)}, args...)...)
 }
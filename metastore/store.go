@@ -0,0 +1,199 @@
+// Package metastore persists metadata of pCloud files and folders that pCloud itself cannot
+// hold, such as user extended attributes.
+package metastore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// Key identifies a pCloud file or folder in the store.
+type Key string
+
+// FileKey returns the key of the pCloud file fileID.
+func FileKey(fileID uint64) Key {
+	return Key("f" + strconv.FormatUint(fileID, 10))
+}
+
+// FolderKey returns the key of the pCloud folder folderID.
+func FolderKey(folderID uint64) Key {
+	return Key("d" + strconv.FormatUint(folderID, 10))
+}
+
+// Store is a local database of named values attached to pCloud files and folders, kept in
+// a JSON file.
+// It is safe for concurrent use.
+type Store struct {
+	path string
+
+	lock    sync.RWMutex // protects entries
+	entries map[Key]map[string][]byte
+}
+
+// Open opens the store kept in the file at path, which is created upon first change if it
+// does not exist.
+func Open(path string) (*Store, error) {
+	s := &Store{
+		path:    path,
+		entries: map[Key]map[string][]byte{},
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err = json.Unmarshal(data, &s.entries); err != nil {
+		return nil, fmt.Errorf("metadata store '%s' is corrupt: %w", path, err)
+	}
+
+	return s, nil
+}
+
+// Get returns the value called name attached to key.
+func (s *Store) Get(key Key, name string) ([]byte, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	value, ok := s.entries[key][name]
+	return value, ok
+}
+
+// Names returns the sorted names of the values attached to key.
+func (s *Store) Names(key Key) []string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	names := make([]string, 0, len(s.entries[key]))
+	for name := range s.entries[key] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Set attaches value to key under name, replacing any previous value.
+func (s *Store) Set(key Key, name string, value []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	previous, existed := s.entries[key][name]
+	if s.entries[key] == nil {
+		s.entries[key] = map[string][]byte{}
+	}
+	s.entries[key][name] = value
+
+	if err := s.save(); err != nil {
+		s.restore(key, name, previous, existed)
+		return err
+	}
+	return nil
+}
+
+// Remove removes the value called name from key. It reports whether it existed.
+func (s *Store) Remove(key Key, name string) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.entries[key][name]; !ok {
+		return false, nil
+	}
+
+	previous := s.entries[key][name]
+	s.restore(key, name, nil, false)
+
+	if err := s.save(); err != nil {
+		s.restore(key, name, previous, true)
+		return false, err
+	}
+	return true, nil
+}
+
+// Delete removes all the values attached to key, such as when its file is deleted.
+func (s *Store) Delete(key Key) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	previous, ok := s.entries[key]
+	if !ok {
+		return nil
+	}
+	delete(s.entries, key)
+
+	if err := s.save(); err != nil {
+		s.entries[key] = previous
+		return err
+	}
+	return nil
+}
+
+// restore sets the value called name of key back to value, or removes it unless it
+// existed, such as when the change to it fails to be saved. The caller must hold the lock.
+func (s *Store) restore(key Key, name string, value []byte, existed bool) {
+	if existed {
+		if s.entries[key] == nil {
+			s.entries[key] = map[string][]byte{}
+		}
+		s.entries[key][name] = value
+		return
+	}
+
+	delete(s.entries[key], name)
+	if len(s.entries[key]) == 0 {
+		delete(s.entries, key)
+	}
+}
+
+// save writes the store to its file, atomically and durably. The caller must hold the lock.
+func (s *Store) save() error {
+	data, err := json.Marshal(s.entries)
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(filepath.Dir(s.path), 0o700); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	if _, err = tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	// the content must be on disk before the file replaces the previous one.
+	if err = tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	if err = os.Rename(tmp.Name(), s.path); err != nil {
+		return err
+	}
+
+	// the rename is durable once the directory is.
+	dir, err := os.Open(filepath.Dir(s.path))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = dir.Close() }()
+
+	return dir.Sync()
+}
@@ -0,0 +1,82 @@
+package metastore_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/seborama/pcloud-drive/v1/metastore"
+)
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sub", "metadata.json")
+
+	s, err := metastore.Open(path)
+	require.NoError(t, err)
+
+	file := metastore.FileKey(123)
+	folder := metastore.FolderKey(123)
+
+	require.NoError(t, s.Set(file, "user.b", []byte("2")))
+	require.NoError(t, s.Set(file, "user.a", []byte("1")))
+	require.NoError(t, s.Set(folder, "user.a", []byte("folder")))
+
+	require.Equal(t, []string{"user.a", "user.b"}, s.Names(file))
+
+	// the values persist across sessions.
+	s, err = metastore.Open(path)
+	require.NoError(t, err)
+
+	value, ok := s.Get(file, "user.a")
+	require.True(t, ok)
+	require.Equal(t, "1", string(value))
+
+	value, ok = s.Get(folder, "user.a")
+	require.True(t, ok)
+	require.Equal(t, "folder", string(value))
+
+	removed, err := s.Remove(file, "user.a")
+	require.NoError(t, err)
+	require.True(t, removed)
+
+	removed, err = s.Remove(file, "user.a")
+	require.NoError(t, err)
+	require.False(t, removed)
+
+	require.NoError(t, s.Delete(file))
+	require.Empty(t, s.Names(file))
+
+	s, err = metastore.Open(path)
+	require.NoError(t, err)
+	require.Empty(t, s.Names(file))
+	require.Equal(t, []string{"user.a"}, s.Names(folder))
+}
+
+func TestStore_SaveFailure(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "sub")
+	path := filepath.Join(dir, "metadata.json")
+
+	s, err := metastore.Open(path)
+	require.NoError(t, err)
+
+	file := metastore.FileKey(123)
+	require.NoError(t, s.Set(file, "user.a", []byte("1")))
+
+	// the store can no longer be saved: its directory is now a file.
+	require.NoError(t, os.RemoveAll(dir))
+	require.NoError(t, os.WriteFile(dir, nil, 0o600))
+
+	// the changes that fail to be saved are rolled back.
+	require.Error(t, s.Set(file, "user.a", []byte("2")))
+	require.Error(t, s.Set(file, "user.b", []byte("3")))
+	_, err = s.Remove(file, "user.a")
+	require.Error(t, err)
+	require.Error(t, s.Delete(file))
+
+	value, ok := s.Get(file, "user.a")
+	require.True(t, ok)
+	require.Equal(t, "1", string(value))
+	require.Equal(t, []string{"user.a"}, s.Names(file))
+}
@@ -0,0 +1,184 @@
+package metrics
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"bazil.org/fuse"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "pcloud_drive"
+
+var (
+	fuseOpDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "fuse",
+			Name:      "operation_duration_seconds",
+			Help:      "Latency of the FUSE operations served by the drive.",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 4, 8),
+		},
+		[]string{"op"},
+	)
+
+	fuseOpErrors = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "fuse",
+			Name:      "operation_errors_total",
+			Help:      "Number of FUSE operations that returned an error, by errno.",
+		},
+		[]string{"op", "errno"},
+	)
+
+	fuseBytes = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "fuse",
+			Name:      "bytes_total",
+			Help:      "Number of bytes read from or written to files on the drive.",
+		},
+		[]string{"direction"},
+	)
+
+	openHandles = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "fuse",
+			Name:      "open_handles",
+			Help:      "Number of file handles currently open on the drive.",
+		},
+	)
+
+	duplicateNames = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "fuse",
+			Name:      "duplicate_names",
+			Help:      "Number of pCloud entries, in the folders listed so far, presented under a disambiguated name because another entry of their folder has the same name.",
+		},
+	)
+
+	cacheLookups = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "cache",
+			Name:      "lookups_total",
+			Help:      "Number of look-ups in the directory entries cache, by result (hit or miss).",
+		},
+		[]string{"cache", "result"},
+	)
+
+	apiDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "pcloud",
+			Name:      "request_duration_seconds",
+			Help:      "Latency of the pCloud API calls, by API method.",
+			Buckets:   prometheus.ExponentialBuckets(0.01, 3, 8),
+		},
+		[]string{"method"},
+	)
+
+	apiErrors = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "pcloud",
+			Name:      "request_errors_total",
+			Help:      "Number of failed pCloud API calls, by API method and pCloud error code (or 'http' for transport errors).",
+		},
+		[]string{"method", "errno"},
+	)
+)
+
+// Direction labels for bytes counters.
+const (
+	DirectionRead  = "read"
+	DirectionWrite = "write"
+)
+
+// ObserveFuseOp records the latency of the FUSE operation op that started at start and,
+// if *errp is not nil, its failure.
+// It is intended to be deferred with a named error return value.
+func ObserveFuseOp(op string, start time.Time, errp *error) {
+	fuseOpDuration.WithLabelValues(op).Observe(time.Since(start).Seconds())
+
+	if errp == nil || *errp == nil {
+		return
+	}
+
+	// report the errno as the kernel receives it.
+	fuseOpErrors.WithLabelValues(op, fuse.ToErrno(*errp).ErrnoName()).Inc()
+}
+
+// AddBytes counts n bytes transferred in the given direction.
+func AddBytes(direction string, n int) {
+	fuseBytes.WithLabelValues(direction).Add(float64(n))
+}
+
+// HandleOpened counts a newly opened file handle.
+func HandleOpened() {
+	openHandles.Inc()
+}
+
+// HandleReleased counts a released file handle.
+func HandleReleased() {
+	openHandles.Dec()
+}
+
+// DuplicateNames sets the number of entries presented under a disambiguated name.
+func DuplicateNames(n int) {
+	duplicateNames.Set(float64(n))
+}
+
+// CacheLookup counts a look-up in the named cache.
+func CacheLookup(cache string, hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	cacheLookups.WithLabelValues(cache, result).Inc()
+}
+
+// Snapshot returns the current value of the drive's metrics, keyed by metric name and labels.
+// Histograms are reported by their count and sum.
+func Snapshot() (map[string]float64, error) {
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := map[string]float64{}
+
+	for _, mf := range families {
+		if !strings.HasPrefix(mf.GetName(), namespace+"_") {
+			continue
+		}
+
+		for _, m := range mf.GetMetric() {
+			labels := make([]string, 0, len(m.GetLabel()))
+			for _, l := range m.GetLabel() {
+				labels = append(labels, fmt.Sprintf("%s=%q", l.GetName(), l.GetValue()))
+			}
+			suffix := ""
+			if len(labels) > 0 {
+				suffix = "{" + strings.Join(labels, ",") + "}"
+			}
+
+			switch {
+			case m.Counter != nil:
+				snapshot[mf.GetName()+suffix] = m.GetCounter().GetValue()
+			case m.Gauge != nil:
+				snapshot[mf.GetName()+suffix] = m.GetGauge().GetValue()
+			case m.Histogram != nil:
+				snapshot[mf.GetName()+"_count"+suffix] = float64(m.GetHistogram().GetSampleCount())
+				snapshot[mf.GetName()+"_sum"+suffix] = m.GetHistogram().GetSampleSum()
+			}
+		}
+	}
+
+	return snapshot, nil
+}
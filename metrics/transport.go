@@ -0,0 +1,69 @@
+package metrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/seborama/pcloud-drive/v1/pcloud"
+)
+
+// Transport is an http.RoundTripper that records metrics about the pCloud API calls
+// that go through it.
+type Transport struct {
+	Base http.RoundTripper
+}
+
+// NewTransport wraps base with pCloud API metrics.
+func NewTransport(base http.RoundTripper) *Transport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &Transport{Base: base}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	method := pcloud.MethodName(req.URL)
+	start := time.Now()
+
+	resp, err := t.Base.RoundTrip(req)
+	defer func() { apiDuration.WithLabelValues(method).Observe(time.Since(start).Seconds()) }()
+
+	if err != nil {
+		apiErrors.WithLabelValues(method, "http").Inc()
+		return resp, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		apiErrors.WithLabelValues(method, "http_"+strconv.Itoa(resp.StatusCode)).Inc()
+		return resp, nil
+	}
+
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		// binary payload, such as file_pread: there is no result code to inspect.
+		return resp, nil
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		// the truncated body would be reported as invalid JSON.
+		apiErrors.WithLabelValues(method, "http").Inc()
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	r := struct {
+		Result int `json:"result"`
+	}{}
+	if err = json.Unmarshal(body, &r); err == nil && r.Result != 0 {
+		apiErrors.WithLabelValues(method, strconv.Itoa(r.Result)).Inc()
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,49 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransport_CountsPCloudErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		_, _ = w.Write([]byte(`{"result": 2009, "error": "File not found."}`))
+	}))
+	defer srv.Close()
+
+	c := &http.Client{Transport: NewTransport(nil)}
+
+	resp, err := c.Get(srv.URL + "/stat?fileid=1")
+	require.NoError(t, err)
+	defer func() { _ = resp.Body.Close() }()
+
+	// the body must remain readable by the SDK.
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Contains(t, string(body), "File not found.")
+
+	require.Equal(t, 1.0, testutil.ToFloat64(apiErrors.WithLabelValues("stat", "2009")))
+}
+
+func TestTransport_ReadError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the connection closes before the body is complete.
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Header().Set("Content-Length", "100")
+		_, _ = w.Write([]byte(`{"result": 0,`))
+	}))
+	defer srv.Close()
+
+	c := &http.Client{Transport: NewTransport(nil)}
+
+	before := testutil.ToFloat64(apiErrors.WithLabelValues("listfolder", "http"))
+	_, err := c.Get(srv.URL + "/listfolder?folderid=0")
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	require.Equal(t, before+1, testutil.ToFloat64(apiErrors.WithLabelValues("listfolder", "http")))
+}
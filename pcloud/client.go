@@ -0,0 +1,199 @@
+// Package pcloud complements the pCloud SDK with the API methods that the drive needs but
+// that the SDK does not provide.
+package pcloud
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/seborama/pcloud-sdk/sdk"
+)
+
+// Client is a pCloud SDK client augmented with additional pCloud API methods.
+// The additional methods share the session of the SDK client.
+type Client struct {
+	*sdk.Client
+
+	httpClient *http.Client
+	session    *session
+}
+
+// NewClient creates a new initialised pCloud Client that issues its calls through c.
+func NewClient(c *http.Client) *Client {
+	s := &session{base: c.Transport}
+	if s.base == nil {
+		s.base = http.DefaultTransport
+	}
+
+	hc := *c
+	hc.Transport = s
+
+	return &Client{
+		Client:     sdk.NewClient(&hc),
+		httpClient: &hc,
+		session:    s,
+	}
+}
+
+// Login performs a user login by credentials supplied via opts.
+// See sdk.Client.Login.
+func (c *Client) Login(ctx context.Context, otpCodeOpt string, opts ...sdk.ClientOption) error {
+	if err := c.Client.Login(ctx, otpCodeOpt, opts...); err != nil {
+		return err
+	}
+
+	// the SDK keeps the auth token to itself: it is captured from a first authenticated call.
+	_, err := c.Client.UserInfo(ctx)
+	return err
+}
+
+// APIError is an error reported by the pCloud API.
+type APIError struct {
+	Result  int
+	Message string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("error %d: %s", e.Result, e.Message)
+}
+
+// pCloud API result codes of interest.
+const (
+	ResultUploadNotFound = 1900
+	ResultFolderNotFound = 2005
+	ResultFileNotFound   = 2009
+)
+
+// IsNotFound returns true when err reports that a file or folder does not exist.
+func IsNotFound(err error) bool {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	return apiErr.Result == ResultFolderNotFound || apiErr.Result == ResultFileNotFound
+}
+
+type result struct {
+	Result int    `json:"result"`
+	Error  string `json:"error"`
+}
+
+func (r *result) err() error {
+	if r.Result == 0 {
+		return nil
+	}
+	return &APIError{Result: r.Result, Message: r.Error}
+}
+
+type resulter interface {
+	err() error
+}
+
+// get calls the pCloud API method with the query q and decodes the response into out.
+func (c *Client) get(ctx context.Context, method string, q url.Values, out resulter) error {
+	return c.do(ctx, http.MethodGet, method, q, nil, out)
+}
+
+// put calls the pCloud API method with the query q and data as the body, and decodes the
+// response into out.
+func (c *Client) put(ctx context.Context, method string, q url.Values, data io.Reader, out resulter) error {
+	return c.do(ctx, http.MethodPut, method, q, data, out)
+}
+
+func (c *Client) do(ctx context.Context, httpMethod, method string, q url.Values, data io.Reader, out resulter) error {
+	auth, host := c.session.get()
+	if auth == "" {
+		return errors.New("not logged in to pCloud")
+	}
+	q.Set("auth", auth)
+
+	u := url.URL{
+		Scheme:   "https",
+		Host:     host,
+		Path:     method,
+		RawQuery: q.Encode(),
+	}
+
+	req, err := http.NewRequestWithContext(ctx, httpMethod, u.String(), data)
+	if err != nil {
+		return fmt.Errorf("http request: %s: %w", method, err)
+	}
+	if data != nil {
+		req.Header.Set("Content-Type", "application/octet-stream")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("http Do: %s: %w", method, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%s: HTTP status %d: %s", method, resp.StatusCode, string(body))
+	}
+
+	if err = json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("%s: unmarshal: %w", method, err)
+	}
+
+	return out.err()
+}
+
+// session is an http.RoundTripper that records the auth token and the API host used by the
+// SDK, so that the additional methods of Client can share its session. It also keeps track of
+// whether pCloud is reachable.
+type session struct {
+	base http.RoundTripper
+
+	lock sync.RWMutex
+	auth string
+	host string
+
+	reach reachability
+}
+
+func (s *session) RoundTrip(req *http.Request) (*http.Response, error) {
+	if auth := req.URL.Query().Get("auth"); auth != "" {
+		s.lock.Lock()
+		s.auth = auth
+		s.host = req.URL.Host
+		s.lock.Unlock()
+	}
+
+	resp, err := s.base.RoundTrip(req)
+	s.reach.observe(req.Context(), err)
+
+	return resp, err
+}
+
+func (s *session) get() (string, string) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.auth, s.host
+}
+
+// ContentMethod is the method name given to the requests made to the content servers.
+const ContentMethod = "content"
+
+// MethodName returns the name of the pCloud API method targeted by u, or ContentMethod
+// when u is a file link on a content server.
+func MethodName(u *url.URL) string {
+	name := strings.TrimPrefix(u.Path, "/")
+
+	for _, r := range name {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '_' {
+			return ContentMethod
+		}
+	}
+
+	return name
+}
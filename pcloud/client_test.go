@@ -0,0 +1,216 @@
+package pcloud
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/seborama/pcloud-sdk/sdk"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestClient returns a Client logged in to a fake pCloud API served by handler.
+func newTestClient(t *testing.T, handler http.HandlerFunc) *Client {
+	t.Helper()
+
+	srv := httptest.NewTLSServer(handler)
+	t.Cleanup(srv.Close)
+
+	c := NewClient(srv.Client())
+	c.session.auth = "test-auth"
+	c.session.host = srv.Listener.Addr().String()
+
+	return c
+}
+
+func TestClient_ListTrash(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/trash_list", r.URL.Path)
+		require.Equal(t, "test-auth", r.URL.Query().Get("auth"))
+		require.Equal(t, "0", r.URL.Query().Get("folderid"))
+
+		_, _ = w.Write([]byte(`{"result": 0, "metadata": {"isfolder": true, "folderid": 0, "contents": [
+			{"name": "old.txt", "fileid": 123, "size": 42, "isfolder": false},
+			{"name": "old dir", "folderid": 456, "isfolder": true}
+		]}}`))
+	})
+
+	md, err := c.ListTrash(context.Background(), TrashRootFolderID)
+	require.NoError(t, err)
+	require.Len(t, md.Contents, 2)
+	require.Equal(t, uint64(123), md.Contents[0].FileID)
+	require.Equal(t, uint64(456), md.Contents[1].FolderID)
+}
+
+func TestClient_APIError(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"result": 2009, "error": "File not found."}`))
+	})
+
+	err := c.PurgeFromTrash(context.Background(), 123, 0)
+	require.Error(t, err)
+	require.True(t, IsNotFound(err))
+	require.EqualError(t, err, "error 2009: File not found.")
+}
+
+func TestClient_TrashNoID(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		require.FailNow(t, "unexpected request", r.URL.String())
+	})
+
+	require.Error(t, c.RestoreFromTrash(context.Background(), 0, 0, 123))
+	require.Error(t, c.PurgeFromTrash(context.Background(), 0, 0))
+}
+
+func TestClient_StatFolder(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/listfolder", r.URL.Path)
+		require.Equal(t, "1", r.URL.Query().Get("nofiles"))
+
+		if r.URL.Query().Get("path") != "/Backups/laptop" {
+			_, _ = w.Write([]byte(`{"result": 2005, "error": "Directory does not exist."}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"result": 0, "metadata": {"isfolder": true, "folderid": 789, "name": "laptop"}}`))
+	})
+
+	md, err := c.StatFolder(context.Background(), sdk.T1FolderByPath("/Backups/laptop"))
+	require.NoError(t, err)
+	require.Equal(t, uint64(789), md.FolderID)
+
+	_, err = c.StatFolder(context.Background(), sdk.T1FolderByPath("/Backups/missing"))
+	require.True(t, IsNotFound(err))
+}
+
+func TestClient_Upload(t *testing.T) {
+	var content []byte
+
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+
+		switch r.URL.Path {
+		case "/upload_create":
+			_, _ = w.Write([]byte(`{"result": 0, "uploadid": 42}`))
+
+		case "/upload_write":
+			require.Equal(t, http.MethodPut, r.Method)
+			require.Equal(t, "42", q.Get("uploadid"))
+			require.Equal(t, strconv.Itoa(len(content)), q.Get("uploadoffset"))
+			data, err := io.ReadAll(r.Body)
+			require.NoError(t, err)
+			content = append(content, data...)
+			_, _ = w.Write([]byte(`{"result": 0}`))
+
+		case "/upload_info":
+			if q.Get("uploadid") != "42" {
+				_, _ = w.Write([]byte(`{"result": 1900, "error": "Upload not found."}`))
+				return
+			}
+			_, _ = fmt.Fprintf(w, `{"result": 0, "size": %d}`, len(content))
+
+		case "/upload_save":
+			require.Equal(t, "7", q.Get("folderid"))
+			require.Equal(t, "big.bin", q.Get("name"))
+			_, _ = fmt.Fprintf(w, `{"result": 0, "metadata": {"fileid": 99, "name": "big.bin", "size": %d}}`, len(content))
+
+		default:
+			t.Fatalf("unexpected method %s", r.URL.Path)
+		}
+	})
+
+	ctx := context.Background()
+
+	uploadID, err := c.CreateUpload(ctx)
+	require.NoError(t, err)
+	require.Equal(t, uint64(42), uploadID)
+
+	require.NoError(t, c.WriteUpload(ctx, uploadID, 0, []byte("hello, ")))
+	require.NoError(t, c.WriteUpload(ctx, uploadID, 7, []byte("world")))
+
+	size, err := c.UploadSize(ctx, uploadID)
+	require.NoError(t, err)
+	require.EqualValues(t, 12, size)
+
+	_, err = c.UploadSize(ctx, 43)
+	require.True(t, IsUploadNotFound(err))
+
+	md, err := c.SaveUpload(ctx, uploadID, 7, "big.bin")
+	require.NoError(t, err)
+	require.Equal(t, uint64(99), md.FileID)
+	require.Equal(t, "hello, world", string(content))
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+func TestClient_Online(t *testing.T) {
+	var unreachable bool
+
+	c := NewClient(&http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		if err := req.Context().Err(); err != nil {
+			return nil, err
+		}
+		if unreachable {
+			return nil, errors.New("dial tcp: connect: network is unreachable")
+		}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(`{"result": 0}`)),
+			Request:    req,
+		}, nil
+	})})
+	c.session.auth = "test-auth"
+	c.session.host = "api.pcloud.com"
+
+	ctx := context.Background()
+	require.True(t, c.Online())
+
+	// a single failure does not take pCloud offline.
+	unreachable = true
+	require.Error(t, c.Probe(ctx))
+	require.True(t, c.Online())
+	unreachable = false
+	require.NoError(t, c.Probe(ctx))
+
+	unreachable = true
+	for range offlineAfterFailures - 1 {
+		require.Error(t, c.Probe(ctx))
+		require.True(t, c.Online())
+	}
+	require.Error(t, c.Probe(ctx))
+	require.False(t, c.Online())
+	since := c.OfflineSince()
+	require.False(t, since.IsZero())
+
+	require.Error(t, c.Probe(ctx))
+	require.Equal(t, since, c.OfflineSince(), "offline since the first failure")
+
+	// a cancelled request says nothing of pCloud.
+	unreachable = false
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	require.Error(t, c.Probe(cancelled))
+	require.False(t, c.Online())
+
+	require.NoError(t, c.Probe(ctx))
+	require.True(t, c.Online())
+	require.True(t, c.OfflineSince().IsZero())
+}
+
+func TestClient_FileTruncate(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/file_truncate", r.URL.Path)
+		require.Equal(t, "3", r.URL.Query().Get("fd"))
+		require.Equal(t, "5", r.URL.Query().Get("length"))
+		_, _ = w.Write([]byte(`{"result": 0}`))
+	})
+
+	require.NoError(t, c.FileTruncate(context.Background(), 3, 5))
+}
@@ -0,0 +1,74 @@
+package pcloud
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/seborama/pcloud-sdk/sdk"
+)
+
+// ErrLinkExpired is returned when the content servers no longer honour a file link.
+var ErrLinkExpired = errors.New("file link expired")
+
+// ReadRange reads at most size bytes at offset from the content servers of link.
+// The hosts of link are tried in turn until one serves the range.
+// Fewer than size bytes are returned when the range goes past the end of the file.
+func (c *Client) ReadRange(ctx context.Context, link *sdk.FileLink, offset, size int64) ([]byte, error) {
+	if len(link.Hosts) == 0 {
+		return nil, errors.New("file link has no host")
+	}
+
+	var err error
+	for _, host := range link.Hosts {
+		var data []byte
+		data, err = c.readRange(ctx, host+link.Path, offset, size)
+		if err == nil || errors.Is(err, ErrLinkExpired) || ctx.Err() != nil {
+			return data, err
+		}
+		// try the next host
+	}
+
+	return nil, err
+}
+
+func (c *Client) readRange(ctx context.Context, u string, offset, size int64) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+size-1))
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		return io.ReadAll(io.LimitReader(resp.Body, size))
+
+	case http.StatusOK:
+		// the content server ignored the range: skip to the offset.
+		if _, err = io.CopyN(io.Discard, resp.Body, offset); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil, nil
+			}
+			return nil, err
+		}
+		return io.ReadAll(io.LimitReader(resp.Body, size))
+
+	case http.StatusRequestedRangeNotSatisfiable:
+		// the offset is at or beyond the end of the file.
+		return nil, nil
+
+	case http.StatusForbidden, http.StatusGone, http.StatusNotFound:
+		return nil, ErrLinkExpired
+
+	default:
+		return nil, fmt.Errorf("content server: HTTP status %d", resp.StatusCode)
+	}
+}
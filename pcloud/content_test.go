@@ -0,0 +1,134 @@
+package pcloud
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/seborama/pcloud-sdk/sdk"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_ReadRange(t *testing.T) {
+	content := strings.NewReader("0123456789")
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/expired/file.txt":
+			w.WriteHeader(http.StatusGone)
+		default:
+			http.ServeContent(w, r, "file.txt", time.Time{}, content)
+		}
+	}))
+	defer srv.Close()
+
+	c := NewClient(srv.Client())
+	ctx := context.Background()
+
+	// the first host is down: the second one serves the range.
+	link := &sdk.FileLink{Path: "/ok/file.txt", Hosts: []string{"http://127.0.0.1:1", srv.URL}}
+
+	data, err := c.ReadRange(ctx, link, 2, 5)
+	require.NoError(t, err)
+	require.Equal(t, "23456", string(data))
+
+	data, err = c.ReadRange(ctx, link, 8, 5)
+	require.NoError(t, err)
+	require.Equal(t, "89", string(data))
+
+	data, err = c.ReadRange(ctx, link, 10, 5)
+	require.NoError(t, err)
+	require.Empty(t, data)
+
+	link.Path = "/expired/file.txt"
+	_, err = c.ReadRange(ctx, link, 0, 5)
+	require.ErrorIs(t, err, ErrLinkExpired)
+}
+
+func TestMethodName(t *testing.T) {
+	u, err := url.Parse("https://eapi.pcloud.com/file_pread?fd=1")
+	require.NoError(t, err)
+	require.Equal(t, "file_pread", MethodName(u))
+
+	u, err = url.Parse("https://c123.pcloud.com/dHZkDVW7ZLmYy/report.pdf")
+	require.NoError(t, err)
+	require.Equal(t, ContentMethod, MethodName(u))
+}
+
+// BenchmarkRead compares reading a file by 128 KiB chunks, as FUSE does, through a file
+// descriptor on the API servers (file_pread) and through HTTP range requests to the content
+// servers. Both servers answer after the same simulated latency.
+// The SDK serialises its calls, hence the file descriptor reads of concurrent readers too.
+func BenchmarkRead(b *testing.B) {
+	const (
+		latency   = 2 * time.Millisecond
+		chunkSize = 128 * 1024
+		fileSize  = 4 * 1024 * 1024
+	)
+
+	content := make([]byte, fileSize)
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(latency)
+
+		if r.URL.Path != "/file_pread" {
+			http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(content))
+			return
+		}
+
+		offset, _ := strconv.ParseInt(r.URL.Query().Get("offset"), 10, 64)
+		count, _ := strconv.ParseInt(r.URL.Query().Get("count"), 10, 64)
+		w.Header().Set("Content-Type", "application/octet-stream")
+		_, _ = w.Write(content[offset:min(offset+count, fileSize)])
+	}))
+	defer srv.Close()
+
+	// every host, including the SDK's API server, resolves to the test server.
+	hc := srv.Client()
+	transport := hc.Transport.(*http.Transport).Clone()
+	transport.TLSClientConfig.InsecureSkipVerify = true
+	transport.DialContext = func(ctx context.Context, network, _ string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, network, srv.Listener.Addr().String())
+	}
+	hc.Transport = transport
+
+	c := NewClient(hc)
+	link := &sdk.FileLink{Path: "/file.bin", Hosts: []string{"https://c1.pcloud.com"}}
+
+	reads := map[string]func(ctx context.Context, offset int64) ([]byte, error){
+		"fd": func(ctx context.Context, offset int64) ([]byte, error) {
+			return c.FilePRead(ctx, 1, chunkSize, uint64(offset))
+		},
+		"http": func(ctx context.Context, offset int64) ([]byte, error) {
+			return c.ReadRange(ctx, link, offset, chunkSize)
+		},
+	}
+
+	for _, mode := range []string{"fd", "http"} {
+		read := reads[mode]
+
+		b.Run(mode, func(b *testing.B) {
+			b.SetBytes(fileSize)
+			b.SetParallelism(2)
+			b.RunParallel(func(pb *testing.PB) {
+				ctx := context.Background()
+				for pb.Next() {
+					for offset := int64(0); offset < fileSize; offset += chunkSize {
+						data, err := read(ctx, offset)
+						if err != nil || len(data) != chunkSize {
+							b.Errorf("read at %d: %d bytes, error %v", offset, len(data), err)
+							return
+						}
+					}
+				}
+			})
+		})
+	}
+}
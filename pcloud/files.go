@@ -0,0 +1,40 @@
+package pcloud
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+
+	"github.com/seborama/pcloud-sdk/sdk"
+)
+
+// StatFile returns the metadata of the file fileID.
+// Unlike sdk.Client.Stat, it fails with an error for which IsNotFound is true when the file
+// does not exist.
+// https://docs.pcloud.com/methods/file/stat.html
+func (c *Client) StatFile(ctx context.Context, fileID uint64) (*sdk.Metadata, error) {
+	q := url.Values{}
+	q.Set("fileid", strconv.FormatUint(fileID, 10))
+
+	r := &struct {
+		result
+		Metadata *sdk.Metadata `json:"metadata"`
+	}{}
+
+	if err := c.get(ctx, "stat", q, r); err != nil {
+		return nil, err
+	}
+
+	return r.Metadata, nil
+}
+
+// FileTruncate sets the size of the file open as fd to length: the content beyond it is cut,
+// and the content up to it, if the file grows, reads as zeros.
+// https://docs.pcloud.com/methods/fileops/file_truncate.html
+func (c *Client) FileTruncate(ctx context.Context, fd uint64, length int64) error {
+	q := url.Values{}
+	q.Set("fd", strconv.FormatUint(fd, 10))
+	q.Set("length", strconv.FormatInt(length, 10))
+
+	return c.get(ctx, "file_truncate", q, &result{})
+}
@@ -0,0 +1,51 @@
+package pcloud
+
+import (
+	"context"
+	"net/url"
+
+	"github.com/seborama/pcloud-sdk/sdk"
+)
+
+// StatFolder returns the metadata of folder, without its contents.
+// It fails with an error for which IsNotFound is true when the folder does not exist.
+// https://docs.pcloud.com/methods/folder/listfolder.html
+func (c *Client) StatFolder(ctx context.Context, folder sdk.T1PathOrFolderID) (*sdk.Metadata, error) {
+	q := url.Values{}
+	folder(q)
+	q.Set("nofiles", "1")
+	q.Set("noshares", "1")
+
+	r := &struct {
+		result
+		Metadata *sdk.Metadata `json:"metadata"`
+	}{}
+
+	if err := c.get(ctx, "listfolder", q, r); err != nil {
+		return nil, err
+	}
+
+	return r.Metadata, nil
+}
+
+// ListTree returns the metadata of the folder folderID along with the contents of its whole
+// subtree.
+// It fails with an error for which IsNotFound is true when the folder does not exist.
+// https://docs.pcloud.com/methods/folder/listfolder.html
+func (c *Client) ListTree(ctx context.Context, folderID uint64) (*sdk.Metadata, error) {
+	q := url.Values{}
+	sdk.T1FolderByID(folderID)(q)
+	q.Set("recursive", "1")
+	q.Set("noshares", "1")
+
+	r := &struct {
+		result
+		Metadata *sdk.Metadata `json:"metadata"`
+	}{}
+
+	if err := c.get(ctx, "listfolder", q, r); err != nil {
+		return nil, err
+	}
+
+	return r.Metadata, nil
+}
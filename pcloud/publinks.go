@@ -0,0 +1,31 @@
+package pcloud
+
+import (
+	"context"
+	"net/url"
+)
+
+// Publink is a public link to a file or a folder.
+type Publink struct {
+	LinkID   uint64 `json:"linkid"`
+	Code     string `json:"code"`
+	Link     string `json:"link"`
+	IsFolder bool   `json:"isfolder"`
+	FileID   uint64 `json:"fileid"`
+	FolderID uint64 `json:"folderid"`
+}
+
+// ListPublinks lists the public links of the user.
+// https://docs.pcloud.com/methods/public_links/listpublinks.html
+func (c *Client) ListPublinks(ctx context.Context) ([]Publink, error) {
+	r := &struct {
+		result
+		Publinks []Publink `json:"publinks"`
+	}{}
+
+	if err := c.get(ctx, "listpublinks", url.Values{}, r); err != nil {
+		return nil, err
+	}
+
+	return r.Publinks, nil
+}
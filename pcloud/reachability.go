@@ -0,0 +1,71 @@
+package pcloud
+
+import (
+	"context"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// offlineAfterFailures is how many requests in a row must fail for lack of a response before
+// pCloud is deemed unreachable, so that a single dropped connection does not take the drive
+// offline.
+const offlineAfterFailures = 3
+
+// reachability tracks whether pCloud is reachable, from the outcome of the requests made to
+// its servers: pCloud is deemed unreachable once offlineAfterFailures requests in a row fail
+// for lack of a response, since the first of them, until a request gets one.
+type reachability struct {
+	lock         sync.RWMutex
+	failures     int       // the requests that failed in a row
+	failingSince time.Time // when the first of them was made
+	offlineSince time.Time // zero while pCloud is reachable
+}
+
+func (r *reachability) observe(ctx context.Context, err error) {
+	if err != nil && ctx.Err() != nil {
+		// the request was cancelled: it says nothing of pCloud.
+		return
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if err == nil {
+		r.failures = 0
+		r.offlineSince = time.Time{}
+		return
+	}
+
+	if r.failures == 0 {
+		r.failingSince = time.Now()
+	}
+	r.failures++
+	if r.failures >= offlineAfterFailures && r.offlineSince.IsZero() {
+		r.offlineSince = r.failingSince
+	}
+}
+
+func (r *reachability) get() time.Time {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	return r.offlineSince
+}
+
+// Online reports whether pCloud is reachable, as far as the last request knows.
+func (c *Client) Online() bool {
+	return c.session.reach.get().IsZero()
+}
+
+// OfflineSince returns when pCloud became unreachable, or the zero time while it is
+// reachable.
+func (c *Client) OfflineSince() time.Time {
+	return c.session.reach.get()
+}
+
+// Probe makes a light request to pCloud, which updates whether pCloud is reachable.
+// https://docs.pcloud.com/methods/general/currentserver.html
+func (c *Client) Probe(ctx context.Context) error {
+	return c.get(ctx, "currentserver", url.Values{}, &result{})
+}
@@ -0,0 +1,56 @@
+package pcloud
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+
+	"github.com/seborama/pcloud-sdk/sdk"
+)
+
+// Revision describes a past version of a file.
+type Revision struct {
+	RevisionID uint64      `json:"revisionid"`
+	Size       uint64      `json:"size"`
+	Hash       uint64      `json:"hash"`
+	Created    sdk.APITime `json:"created"`
+}
+
+// ListRevisions lists the past revisions of the file fileID.
+// https://docs.pcloud.com/methods/revisions/listrevisions.html
+func (c *Client) ListRevisions(ctx context.Context, fileID uint64) ([]Revision, error) {
+	q := url.Values{}
+	q.Set("fileid", strconv.FormatUint(fileID, 10))
+
+	r := &struct {
+		result
+		Revisions []Revision `json:"revisions"`
+	}{}
+
+	if err := c.get(ctx, "listrevisions", q, r); err != nil {
+		return nil, err
+	}
+
+	return r.Revisions, nil
+}
+
+// RevertRevision makes the revision revisionID the current version of the file fileID.
+// The current version becomes a revision in turn.
+// https://docs.pcloud.com/methods/revisions/revertrevision.html
+func (c *Client) RevertRevision(ctx context.Context, fileID, revisionID uint64) error {
+	q := url.Values{}
+	q.Set("fileid", strconv.FormatUint(fileID, 10))
+	q.Set("revisionid", strconv.FormatUint(revisionID, 10))
+
+	return c.get(ctx, "revertrevision", q, &result{})
+}
+
+// GetRevisionLink gets a download link for the revision revisionID of the file fileID.
+// See sdk.Client.GetFileLink.
+func (c *Client) GetRevisionLink(ctx context.Context, fileID, revisionID uint64) (*sdk.FileLink, error) {
+	withRevision := func(q *url.Values) {
+		q.Set("revisionid", strconv.FormatUint(revisionID, 10))
+	}
+
+	return c.Client.GetFileLink(ctx, sdk.T3FileByID(fileID), true, "", 0, true, withRevision)
+}
@@ -0,0 +1,71 @@
+package pcloud
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strconv"
+
+	"github.com/seborama/pcloud-sdk/sdk"
+)
+
+// TrashRootFolderID is the folderID of the root of the trash.
+const TrashRootFolderID = uint64(0)
+
+// ListTrash lists the content of the trash folder folderID (TrashRootFolderID for the root
+// of the trash).
+// https://docs.pcloud.com/methods/trash/trash_list.html
+func (c *Client) ListTrash(ctx context.Context, folderID uint64) (*sdk.Metadata, error) {
+	q := url.Values{}
+	q.Set("folderid", strconv.FormatUint(folderID, 10))
+
+	r := &struct {
+		result
+		Metadata *sdk.Metadata `json:"metadata"`
+	}{}
+
+	if err := c.get(ctx, "trash_list", q, r); err != nil {
+		return nil, err
+	}
+
+	return r.Metadata, nil
+}
+
+// RestoreFromTrash restores the trashed file fileID or folder folderID (exactly one must
+// be non-zero) into the folder toFolderID.
+// https://docs.pcloud.com/methods/trash/trash_restore.html
+func (c *Client) RestoreFromTrash(ctx context.Context, fileID, folderID, toFolderID uint64) error {
+	q := url.Values{}
+	if err := setFileOrFolderID(q, fileID, folderID); err != nil {
+		return err
+	}
+	q.Set("restoreto", strconv.FormatUint(toFolderID, 10))
+
+	return c.get(ctx, "trash_restore", q, &result{})
+}
+
+// PurgeFromTrash deletes permanently the trashed file fileID or folder folderID (exactly
+// one must be non-zero).
+// https://docs.pcloud.com/methods/trash/trash_clear.html
+func (c *Client) PurgeFromTrash(ctx context.Context, fileID, folderID uint64) error {
+	q := url.Values{}
+	if err := setFileOrFolderID(q, fileID, folderID); err != nil {
+		return err
+	}
+
+	return c.get(ctx, "trash_clear", q, &result{})
+}
+
+// setFileOrFolderID sets the trashed item of q. It fails when both IDs are 0: folderid=0
+// is the root of the trash, which would be restored or deleted whole.
+func setFileOrFolderID(q url.Values, fileID, folderID uint64) error {
+	switch {
+	case fileID != 0:
+		q.Set("fileid", strconv.FormatUint(fileID, 10))
+	case folderID != 0:
+		q.Set("folderid", strconv.FormatUint(folderID, 10))
+	default:
+		return errors.New("a trashed file ID or folder ID is required")
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package pcloud
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/url"
+	"strconv"
+
+	"github.com/seborama/pcloud-sdk/sdk"
+)
+
+// IsUploadNotFound returns true when err reports that an upload does not exist.
+func IsUploadNotFound(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.Result == ResultUploadNotFound
+}
+
+// CreateUpload creates an upload, to which the content of a file is written in chunks, in
+// any order, before it is saved as a file.
+// https://docs.pcloud.com/methods/upload/upload_create.html
+func (c *Client) CreateUpload(ctx context.Context) (uint64, error) {
+	r := &struct {
+		result
+		UploadID uint64 `json:"uploadid"`
+	}{}
+
+	if err := c.get(ctx, "upload_create", url.Values{}, r); err != nil {
+		return 0, err
+	}
+
+	return r.UploadID, nil
+}
+
+// WriteUpload writes data at offset in the upload uploadID.
+// https://docs.pcloud.com/methods/upload/upload_write.html
+func (c *Client) WriteUpload(ctx context.Context, uploadID uint64, offset int64, data []byte) error {
+	q := url.Values{}
+	q.Set("uploadid", strconv.FormatUint(uploadID, 10))
+	q.Set("uploadoffset", strconv.FormatInt(offset, 10))
+
+	return c.put(ctx, "upload_write", q, bytes.NewReader(data), &result{})
+}
+
+// UploadSize returns how many bytes the upload uploadID holds.
+// https://docs.pcloud.com/methods/upload/upload_info.html
+func (c *Client) UploadSize(ctx context.Context, uploadID uint64) (int64, error) {
+	q := url.Values{}
+	q.Set("uploadid", strconv.FormatUint(uploadID, 10))
+
+	r := &struct {
+		result
+		Size int64 `json:"size"`
+	}{}
+
+	if err := c.get(ctx, "upload_info", q, r); err != nil {
+		return 0, err
+	}
+
+	return r.Size, nil
+}
+
+// SaveUpload saves the upload uploadID as the file called name in the folder folderID,
+// which it replaces if it exists. The upload ceases to exist.
+// https://docs.pcloud.com/methods/upload/upload_save.html
+func (c *Client) SaveUpload(ctx context.Context, uploadID, folderID uint64, name string) (*sdk.Metadata, error) {
+	q := url.Values{}
+	q.Set("uploadid", strconv.FormatUint(uploadID, 10))
+	q.Set("folderid", strconv.FormatUint(folderID, 10))
+	q.Set("name", name)
+
+	r := &struct {
+		result
+		Metadata *sdk.Metadata `json:"metadata"`
+	}{}
+
+	if err := c.get(ctx, "upload_save", q, r); err != nil {
+		return nil, err
+	}
+
+	return r.Metadata, nil
+}
+
+// DeleteUpload abandons the upload uploadID.
+// https://docs.pcloud.com/methods/upload/upload_delete.html
+func (c *Client) DeleteUpload(ctx context.Context, uploadID uint64) error {
+	q := url.Values{}
+	q.Set("uploadid", strconv.FormatUint(uploadID, 10))
+
+	return c.get(ctx, "upload_delete", q, &result{})
+}
@@ -0,0 +1,183 @@
+// Package scheduler caps the number of concurrent requests to pCloud, and serves the waiting
+// requests by priority, so that the interactive operations are not held up by the bulk
+// transfers.
+package scheduler
+
+import (
+	"context"
+	"sync"
+)
+
+// Priority is the priority of a request to pCloud.
+type Priority int
+
+const (
+	// Background is the priority of the transfers that nobody waits for: background uploads,
+	// pinning and prefetch.
+	Background Priority = iota
+	// Normal is the priority of the requests that serve the other operations, such as reads
+	// and writes.
+	Normal
+	// Interactive is the priority of the operations that users wait for when browsing the
+	// drive: look-ups, attributes and directory listings.
+	Interactive
+
+	numPriorities = iota
+)
+
+// String returns the name of the priority.
+func (p Priority) String() string {
+	switch p {
+	case Background:
+		return "background"
+	case Interactive:
+		return "interactive"
+	default:
+		return "normal"
+	}
+}
+
+type priorityKey struct{}
+
+// WithPriority returns a copy of ctx whose requests to pCloud have the priority p.
+func WithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+// PriorityFrom returns the priority of the requests made with ctx, Normal by default.
+func PriorityFrom(ctx context.Context) Priority {
+	if p, ok := ctx.Value(priorityKey{}).(Priority); ok {
+		return p
+	}
+	return Normal
+}
+
+// Scheduler admits at most a fixed number of concurrent requests. The waiting requests are
+// admitted by priority, then in order of arrival. Some of the slots are reserved to the
+// Interactive requests, so that they are admitted promptly even while long transfers take
+// the other slots.
+// It is safe for concurrent use.
+type Scheduler struct {
+	limit    int
+	reserved int // slots only Interactive requests take
+
+	lock    sync.Mutex // protects running and waiting
+	running int
+	waiting [numPriorities][]chan struct{}
+}
+
+// Status describes the state of a Scheduler.
+type Status struct {
+	Limit   int
+	Running int
+	Waiting map[string]int `json:",omitempty"` // by priority
+}
+
+// New returns a Scheduler that admits at most limit concurrent requests.
+func New(limit int) *Scheduler {
+	limit = max(limit, 1)
+
+	reserved := 0
+	if limit > 1 {
+		reserved = max(limit/4, 1)
+	}
+
+	return &Scheduler{limit: limit, reserved: reserved}
+}
+
+// Acquire waits until a request of priority p is admitted, or until ctx is done. The
+// returned function must be called once the request is complete.
+func (s *Scheduler) Acquire(ctx context.Context, p Priority) (func(), error) {
+	s.lock.Lock()
+	if s.admits(p) {
+		s.running++
+		s.lock.Unlock()
+		return s.release, nil
+	}
+
+	ready := make(chan struct{})
+	s.waiting[p] = append(s.waiting[p], ready)
+	s.lock.Unlock()
+
+	select {
+	case <-ready:
+		return s.release, nil
+
+	case <-ctx.Done():
+		s.lock.Lock()
+		defer s.lock.Unlock()
+
+		for i, c := range s.waiting[p] {
+			if c == ready {
+				s.waiting[p] = append(s.waiting[p][:i], s.waiting[p][i+1:]...)
+				return nil, ctx.Err()
+			}
+		}
+		// admitted meanwhile: the slot is given up.
+		s.running--
+		s.admit()
+
+		return nil, ctx.Err()
+	}
+}
+
+// admits reports whether a request of priority p can start now, ahead of none of the
+// waiting requests of the same or a higher priority. The caller must hold the lock.
+func (s *Scheduler) admits(p Priority) bool {
+	for q := p; q < numPriorities; q++ {
+		if len(s.waiting[q]) > 0 {
+			return false
+		}
+	}
+	return s.running < s.capacity(p)
+}
+
+// capacity returns how many requests may run when a request of priority p starts.
+func (s *Scheduler) capacity(p Priority) int {
+	if p == Interactive {
+		return s.limit
+	}
+	return s.limit - s.reserved
+}
+
+func (s *Scheduler) release() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.running--
+	s.admit()
+}
+
+// admit starts the waiting requests that can run, by priority. The caller must hold the
+// lock.
+func (s *Scheduler) admit() {
+	for p := numPriorities - 1; p >= 0; p-- {
+		for len(s.waiting[p]) > 0 && s.running < s.capacity(Priority(p)) {
+			close(s.waiting[p][0])
+			s.waiting[p] = s.waiting[p][1:]
+			s.running++
+		}
+		if len(s.waiting[p]) > 0 {
+			// the lower priorities wait for those.
+			return
+		}
+	}
+}
+
+// Status returns the state of the scheduler.
+func (s *Scheduler) Status() Status {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	st := Status{Limit: s.limit, Running: s.running}
+	for p, waiting := range s.waiting {
+		if len(waiting) > 0 {
+			if st.Waiting == nil {
+				st.Waiting = map[string]int{}
+			}
+			st.Waiting[Priority(p).String()] = len(waiting)
+		}
+	}
+
+	return st
+}
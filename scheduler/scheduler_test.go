@@ -0,0 +1,115 @@
+package scheduler
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// admitted reports whether the request waiting on ready is admitted.
+func admitted(ready <-chan func()) bool {
+	select {
+	case <-ready:
+		return true
+	case <-time.After(50 * time.Millisecond):
+		return false
+	}
+}
+
+func acquire(t *testing.T, s *Scheduler, ctx context.Context, p Priority) <-chan func() {
+	t.Helper()
+
+	ready := make(chan func(), 1)
+	go func() {
+		release, err := s.Acquire(ctx, p)
+		if err == nil {
+			ready <- release
+		}
+	}()
+
+	return ready
+}
+
+func TestScheduler(t *testing.T) {
+	ctx := context.Background()
+	s := New(4) // one slot is reserved to the interactive requests
+
+	var releases []func()
+	for i := 0; i < 3; i++ {
+		release, err := s.Acquire(ctx, Background)
+		require.NoError(t, err)
+		releases = append(releases, release)
+	}
+
+	// the background requests cannot take the reserved slot.
+	background := acquire(t, s, ctx, Background)
+	require.False(t, admitted(background))
+
+	normal := acquire(t, s, ctx, Normal)
+	require.False(t, admitted(normal))
+
+	// the interactive requests can.
+	release, err := s.Acquire(ctx, Interactive)
+	require.NoError(t, err)
+	require.Equal(t, Status{Limit: 4, Running: 4, Waiting: map[string]int{"background": 1, "normal": 1}}, s.Status())
+
+	// an interactive request waits for the next slot, but goes first.
+	interactive := acquire(t, s, ctx, Interactive)
+	require.False(t, admitted(interactive))
+	release()
+	release = <-interactive
+
+	// then the normal requests, before the background ones.
+	release()
+	releases[0]()
+	<-normal
+	require.False(t, admitted(background))
+	releases[1]()
+	<-background
+
+	// a request that gives up waiting leaves the queue.
+	cancelled, cancel := context.WithCancel(ctx)
+	_ = acquire(t, s, cancelled, Background)
+	require.Eventually(t, func() bool { return s.Status().Waiting["background"] == 1 }, time.Second, time.Millisecond)
+	cancel()
+	require.Eventually(t, func() bool { return s.Status().Waiting == nil }, time.Second, time.Millisecond)
+}
+
+func TestTransport(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("content"))
+	}))
+	defer srv.Close()
+
+	s := New(1)
+	c := &http.Client{Transport: NewTransport(nil, s)}
+
+	// the slot is held until the response body is closed.
+	resp, err := c.Get(srv.URL)
+	require.NoError(t, err)
+	require.Equal(t, 1, s.Status().Running)
+
+	_, err = io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.NoError(t, resp.Body.Close())
+	require.Zero(t, s.Status().Running)
+
+	// the priority is carried by the context of the requests.
+	release, err := s.Acquire(context.Background(), Interactive)
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(WithPriority(context.Background(), Background), 50*time.Millisecond)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	require.NoError(t, err)
+	_, err = c.Do(req)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	release()
+	require.Equal(t, Background, PriorityFrom(ctx))
+	require.Equal(t, Normal, PriorityFrom(context.Background()))
+}
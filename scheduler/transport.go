@@ -0,0 +1,57 @@
+package scheduler
+
+import (
+	"io"
+	"net/http"
+	"sync"
+)
+
+// Transport is an http.RoundTripper that admits the requests through a Scheduler, by the
+// priority that their context carries. A request holds its slot until its response body
+// is closed.
+type Transport struct {
+	Base      http.RoundTripper
+	Scheduler *Scheduler
+}
+
+// NewTransport wraps base with the admission of s.
+func NewTransport(base http.RoundTripper, s *Scheduler) *Transport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &Transport{Base: base, Scheduler: s}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	release, err := t.Scheduler.Acquire(req.Context(), PriorityFrom(req.Context()))
+	if err != nil {
+		if req.Body != nil {
+			_ = req.Body.Close()
+		}
+		return nil, err
+	}
+
+	resp, err := t.Base.RoundTrip(req)
+	if err != nil {
+		release()
+		return resp, err
+	}
+
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: release}
+
+	return resp, nil
+}
+
+// releasingBody is a response body that releases the slot of its request once closed.
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+	once    sync.Once
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
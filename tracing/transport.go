@@ -0,0 +1,55 @@
+package tracing
+
+import (
+	"fmt"
+	"net/http"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+
+	"github.com/seborama/pcloud-drive/v1/pcloud"
+)
+
+// Transport is an http.RoundTripper that traces each pCloud API call as a child span of
+// the span carried by the request context.
+type Transport struct {
+	Base http.RoundTripper
+}
+
+// NewTransport wraps base with pCloud API tracing.
+func NewTransport(base http.RoundTripper) *Transport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &Transport{Base: base}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	method := pcloud.MethodName(req.URL)
+
+	ctx, span := Start(
+		req.Context(),
+		"pcloud."+method,
+		attribute.String("pcloud.method", method),
+		semconv.HTTPRequestMethodKey.String(req.Method),
+		semconv.ServerAddress(req.URL.Hostname()),
+	)
+	defer span.End()
+
+	// the request URL is not recorded: it carries the pCloud auth token.
+	resp, err := t.Base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return resp, err
+	}
+
+	span.SetAttributes(semconv.HTTPResponseStatusCode(resp.StatusCode))
+	if resp.StatusCode != http.StatusOK {
+		span.SetStatus(codes.Error, fmt.Sprintf("HTTP status %d", resp.StatusCode))
+	}
+
+	return resp, nil
+}
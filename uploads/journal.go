@@ -0,0 +1,180 @@
+// Package uploads keeps track of the chunked uploads of files to pCloud, so that they
+// survive a restart of the drive.
+package uploads
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Upload describes a chunked upload of the content of a file. The content is staged in a
+// local file and written to pCloud in chunks, in order.
+type Upload struct {
+	ID       uint64    `json:"id"`
+	UploadID uint64    `json:"upload_id"` // pCloud's upload, 0 until the first chunk is written
+	FileID   uint64    `json:"file_id"`   // the file that the upload replaces once saved, 0 for a new file
+	FolderID uint64    `json:"folder_id"` // the folder of the new file, once saved
+	Name     string    `json:"name"`      // the name of the new file, once saved
+	Path     string    `json:"path"`      // the path of the file in the drive, for information
+	Staging  string    `json:"staging"`   // the local file that holds the content
+	Offset   int64     `json:"offset"`    // how much of the content pCloud has acknowledged
+	Closed   bool      `json:"closed"`    // whether the content is complete, awaiting upload
+	Started  time.Time `json:"started"`
+}
+
+// Journal is the record of the uploads in progress, kept in a directory along with their
+// staging files.
+// It is safe for concurrent use.
+type Journal struct {
+	dir string
+
+	lock    sync.RWMutex // protects uploads and lastID
+	uploads map[uint64]Upload
+	lastID  uint64
+}
+
+// journalFile is the name of the file of the journal in its directory.
+const journalFile = "journal.json"
+
+// Open opens the journal kept in the directory dir, which is created if it does not exist.
+func Open(dir string) (*Journal, error) {
+	j := &Journal{
+		dir:     dir,
+		uploads: map[uint64]Upload{},
+	}
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, journalFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return j, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var uploads []Upload
+	if err = json.Unmarshal(data, &uploads); err != nil {
+		return nil, fmt.Errorf("upload journal in '%s' is corrupt: %w", dir, err)
+	}
+	for _, u := range uploads {
+		j.uploads[u.ID] = u
+		j.lastID = max(j.lastID, u.ID)
+	}
+
+	return j, nil
+}
+
+// NewUpload returns a new upload of the content of the file fileID, at p in the drive.
+// It is not recorded until it is put in the journal.
+func (j *Journal) NewUpload(fileID uint64, p string) Upload {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	j.lastID++
+
+	return Upload{
+		ID:      j.lastID,
+		FileID:  fileID,
+		Path:    p,
+		Staging: filepath.Join(j.dir, strconv.FormatUint(j.lastID, 10)+".part"),
+		Started: time.Now(),
+	}
+}
+
+// Uploads returns the uploads in progress, oldest first.
+func (j *Journal) Uploads() []Upload {
+	j.lock.RLock()
+	defer j.lock.RUnlock()
+
+	uploads := make([]Upload, 0, len(j.uploads))
+	for _, u := range j.uploads {
+		uploads = append(uploads, u)
+	}
+	sort.Slice(uploads, func(a, b int) bool {
+		if uploads[a].Started.Equal(uploads[b].Started) {
+			return uploads[a].ID < uploads[b].ID
+		}
+		return uploads[a].Started.Before(uploads[b].Started)
+	})
+
+	return uploads
+}
+
+// Put records u, replacing the previous record of the upload.
+func (j *Journal) Put(u Upload) error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	j.uploads[u.ID] = u
+
+	return j.save()
+}
+
+// Remove forgets the upload id, such as once it is saved.
+func (j *Journal) Remove(id uint64) error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	if _, ok := j.uploads[id]; !ok {
+		return nil
+	}
+	delete(j.uploads, id)
+
+	return j.save()
+}
+
+// save writes the journal to its file, atomically. The caller must hold the lock.
+func (j *Journal) save() error {
+	uploads := make([]Upload, 0, len(j.uploads))
+	for _, u := range j.uploads {
+		uploads = append(uploads, u)
+	}
+
+	data, err := json.Marshal(uploads)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(j.dir, journalFile+".*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	if _, err = tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	// the journal must be on disk before it replaces the previous one: the uploads are
+	// resumed from it after a crash.
+	if err = tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	if err = os.Rename(tmp.Name(), filepath.Join(j.dir, journalFile)); err != nil {
+		return err
+	}
+
+	// the rename is durable once the directory is.
+	dir, err := os.Open(j.dir)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = dir.Close() }()
+
+	return dir.Sync()
+}
@@ -0,0 +1,51 @@
+package uploads_test
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/seborama/pcloud-drive/v1/uploads"
+)
+
+func TestJournal(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "uploads")
+
+	j, err := uploads.Open(dir)
+	require.NoError(t, err)
+	require.Empty(t, j.Uploads())
+
+	older := j.NewUpload(20, "a.bin")
+	newer := j.NewUpload(10, "b.bin")
+	require.NotEqual(t, older.ID, newer.ID)
+	require.NotEqual(t, older.Staging, newer.Staging)
+	require.Equal(t, dir, filepath.Dir(older.Staging))
+
+	now := time.Now().UTC().Truncate(time.Second)
+	older.Started, newer.Started = now.Add(-time.Minute), now
+
+	require.NoError(t, j.Put(newer))
+	require.NoError(t, j.Put(older))
+
+	older.UploadID = 42
+	older.Offset = 8 << 20
+	older.Closed = true
+	require.NoError(t, j.Put(older))
+
+	// the uploads persist across sessions.
+	j, err = uploads.Open(dir)
+	require.NoError(t, err)
+	require.Equal(t, []uploads.Upload{older, newer}, j.Uploads())
+
+	// the identifiers are not reused.
+	require.Greater(t, j.NewUpload(30, "c.bin").ID, newer.ID)
+
+	require.NoError(t, j.Remove(older.ID))
+	require.NoError(t, j.Remove(older.ID)) // no-op
+
+	j, err = uploads.Open(dir)
+	require.NoError(t, err)
+	require.Equal(t, []uploads.Upload{newer}, j.Uploads())
+}